@@ -6,6 +6,11 @@
 package build
 
 import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+
 	"bldy.build/build/executor"
 	"bldy.build/build/label"
 	"bldy.build/build/workspace"
@@ -35,6 +40,16 @@ const (
 var (
 	HostPlatform    = label.Label("@bldy//platforms:host")
 	DefaultPlatform = HostPlatform
+
+	// HostConstraints are the constraint values of the host platform.
+	HostConstraints = []label.Label{
+		label.Label("@bldy//platforms/os:" + runtime.GOOS),
+		label.Label("@bldy//platforms/cpu:" + runtime.GOARCH),
+	}
+
+	// DefaultCondition is the condition of the branch of a select() that's
+	// picked when none of the other conditions match.
+	DefaultCondition = label.Label("//conditions:default")
 )
 
 // Rule defines the interface that rules must implement for becoming build targets.
@@ -48,8 +63,203 @@ type Rule interface {
 	Workspace() workspace.Workspace
 }
 
+// Declarable is implemented by rules that have to know the label they are
+// declared with, like native rules. The VM calls Declare before it declares
+// the rule, rules that were called with bad attributes return an error.
+type Declarable interface {
+	Declare(lbl label.Label, ws workspace.Workspace) error
+}
+
+// Files is implemented by targets whose files aren't only their outputs,
+// like source files and groups of them. Outputs are relative to the output
+// directory and source files are absolute.
+type Files interface {
+	Files() []string
+}
+
+// Analyzer is implemented by rules that have to see the rules they depend
+// on before they can be built. The graph analyzes a rule once every one of
+// its dependencies has been loaded and analyzed.
+type Analyzer interface {
+	Analyze(deps map[label.Label]Rule) error
+}
+
 // VM seperate the parsing and evauluating targets logic from rest of bldy
 // so we can implement and use new grammars like jsonnet or go it self.
 type VM interface {
 	GetTarget(label.Label) (Rule, error)
+	// Targets returns the labels of every target declared in a package.
+	Targets(pkg string) ([]label.Label, error)
+	// Position returns the BUILD file and line a target was declared on,
+	// or an empty string if the target hasn't been loaded.
+	Position(label.Label) string
+}
+
+// Compilation modes, see --compilation_mode.
+const (
+	Fastbuild = "fastbuild"
+	Dbg       = "dbg"
+	Opt       = "opt"
+)
+
+// Config is the configuration targets are built in, the branches of select()s
+// are picked by the config_settings that match it.
+type Config struct {
+	// Defines are the variables set with --define.
+	Defines map[string]string
+	// Platform is the platform the targets are built for, Constraints are
+	// its constraint values.
+	Platform    label.Label
+	Constraints []label.Label
+	// CompilationMode is one of fastbuild, dbg or opt.
+	CompilationMode string
+	// Copts and Linkopts are passed to the compilers and linkers rules
+	// run.
+	Copts, Linkopts []string
+	// ActionEnv is the environment set with --action_env.
+	ActionEnv map[string]string
+}
+
+// DefaultConfig returns the configuration for building for the host without
+// any flags.
+func DefaultConfig() *Config {
+	return &Config{
+		Defines:         make(map[string]string),
+		Platform:        HostPlatform,
+		Constraints:     HostConstraints,
+		CompilationMode: Fastbuild,
+		ActionEnv:       make(map[string]string),
+	}
+}
+
+// OutputDir is the name of the directory the outputs of targets built in
+// the configuration go in, so targets built in different modes don't
+// overwrite each other.
+func (c *Config) OutputDir() string {
+	return fmt.Sprintf("%s-%s", runtime.GOARCH, c.CompilationMode)
+}
+
+// String encodes every setting of the configuration in a stable order, two
+// configurations are the same if their strings are.
+func (c *Config) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "compilation_mode=%q\n", c.CompilationMode)
+	fmt.Fprintf(&b, "platform %q\n", c.Platform)
+	for _, k := range sortedKeys(c.Defines) {
+		fmt.Fprintf(&b, "define %q=%q\n", k, c.Defines[k])
+	}
+	for _, k := range sortedKeys(c.ActionEnv) {
+		fmt.Fprintf(&b, "action_env %q=%q\n", k, c.ActionEnv[k])
+	}
+	for _, lbl := range c.Constraints {
+		fmt.Fprintf(&b, "constraint %q\n", lbl)
+	}
+	for _, opt := range c.Copts {
+		fmt.Fprintf(&b, "copt %q\n", opt)
+	}
+	for _, opt := range c.Linkopts {
+		fmt.Fprintf(&b, "linkopt %q\n", opt)
+	}
+	return b.String()
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Configurable is implemented by rules with attributes that depend on the
+// configuration. The graph configures them before it asks them for their
+// dependencies.
+type Configurable interface {
+	// Conditions returns the labels of the config_settings the rule
+	// selects its attributes with.
+	Conditions() []label.Label
+	// Configure picks the branches of the rule's select()s, matches has
+	// every condition in Conditions and whether it matches. config is
+	// what the rule is built in.
+	Configure(config *Config, matches map[label.Label]bool) error
+}
+
+// Condition is implemented by targets, like config_setting, that can be used
+// as conditions in select()s.
+type Condition interface {
+	Matches(*Config) bool
+}
+
+var (
+	// PublicVisibility makes targets visible to every package.
+	PublicVisibility = label.Label("//visibility:public")
+	// PrivateVisibility makes targets visible to their own package only.
+	PrivateVisibility = label.Label("//visibility:private")
+)
+
+// Visible is implemented by targets that limit which targets can depend
+// on them.
+type Visible interface {
+	// Visibility returns the labels the target is visible to, they are
+	// PublicVisibility, PrivateVisibility, //<package>:__pkg__,
+	// //<package>:__subpackages__ or labels of package groups.
+	Visibility() []label.Label
+}
+
+// PackageGroup is implemented by package_group targets, targets that are
+// visible to a package group are visible to its packages.
+type PackageGroup interface {
+	// Contains reports whether pkg is one of the packages of the group,
+	// without the groups it includes.
+	Contains(pkg string) bool
+	// Includes returns the labels of the package groups the group
+	// includes.
+	Includes() []label.Label
+}
+
+// Restricted is implemented by rules that can only be built for some
+// platforms.
+type Restricted interface {
+	// CompatibleWith returns why the rule can't be built in the
+	// configuration, or nil if it can.
+	CompatibleWith(*Config) error
+}
+
+// Platform is implemented by platform targets.
+type Platform interface {
+	// ConstraintValues returns the constraint values of the platform.
+	ConstraintValues() []label.Label
+}
+
+// Toolchain is implemented by toolchain targets, they declare which target
+// implements a toolchain type and the platforms it can be used on.
+type Toolchain interface {
+	// ToolchainType returns the type of toolchain the toolchain is.
+	ToolchainType() label.Label
+	// Toolchain returns the label of the target that implements the
+	// toolchain.
+	Toolchain() label.Label
+	// Matches reports whether the toolchain runs on a platform with the
+	// constraint values exec and builds for one with target.
+	Matches(exec, target []label.Label) bool
+}
+
+// ToolchainRegistry is implemented by VMs that know which toolchains are
+// registered in the workspace.
+type ToolchainRegistry interface {
+	// RegisteredToolchains returns the labels of the toolchains in the
+	// order they were registered in.
+	RegisteredToolchains() ([]label.Label, error)
+}
+
+// ToolchainUser is implemented by rules that need toolchains to be built.
+// The graph resolves their toolchains before it asks them for their
+// dependencies.
+type ToolchainUser interface {
+	// ToolchainTypes returns the types of toolchains the rule needs.
+	ToolchainTypes() []label.Label
+	// SetToolchains sets the targets that implement the toolchain types,
+	// the rule depends on them.
+	SetToolchains(toolchains map[label.Label]label.Label) error
 }
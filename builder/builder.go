@@ -17,8 +17,10 @@ import (
 	"sync"
 	"time"
 
+	"bldy.build/build/cache"
 	"bldy.build/build/executor"
 	"bldy.build/build/namespace"
+	"bldy.build/build/project"
 
 	"sevki.org/pqueue"
 
@@ -46,10 +48,17 @@ type Builder struct {
 	graph       *graph.Graph
 	pq          *pqueue.PQueue `json:"-"`
 	config      *Config
+	store       cache.Store
+	local       *cache.Disk
 	notifier    Notifier `json:"-"`
 	start       time.Time
 
 	wg sync.WaitGroup
+	// slots limits how many actions run at once across every node.
+	slots chan struct{}
+
+	mu      sync.Mutex
+	visited map[*graph.Node]bool
 }
 
 type Notifier interface {
@@ -62,6 +71,9 @@ type Config struct {
 	Fresh    bool
 	BuildOut *string
 	Cache    *string
+	// Remote is the URL of a remote cache, when it's nil BLDY_REMOTE_CACHE
+	// is looked up in the environment and bldy.cfg.
+	Remote *string
 }
 
 func New(g *graph.Graph, c *Config, n Notifier) (b Builder) {
@@ -71,6 +83,7 @@ func New(g *graph.Graph, c *Config, n Notifier) (b Builder) {
 		l.Fatal(err)
 	}
 	b.pq = pqueue.New()
+	b.visited = make(map[*graph.Node]bool)
 	b.graph = g
 	b.notifier = n
 	if c.Fresh {
@@ -87,6 +100,25 @@ func New(g *graph.Graph, c *Config, n Notifier) (b Builder) {
 		c.BuildOut = &x
 	}
 
+	if c.Remote == nil && !c.Fresh {
+		if remote := project.Getenv("BLDY_REMOTE_CACHE"); remote != "" {
+			c.Remote = &remote
+		}
+	}
+	disk, err := cache.NewDisk(*c.Cache)
+	if err != nil {
+		l.Fatal(err)
+	}
+	b.store = disk
+	b.local = disk
+	if c.Remote != nil {
+		remote, err := cache.NewHTTP(*c.Remote)
+		if err != nil {
+			l.Fatal(err)
+		}
+		b.store = cache.NewLayered(disk, remote)
+	}
+
 	b.config = c
 	b.ProjectPath = g.Workspace().AbsPath()
 
@@ -98,28 +130,39 @@ var (
 )
 
 func bldyCache() *string {
+	x := DefaultCacheDir()
+	return &x
+}
+
+// DefaultCacheDir returns the directory builds are cached in unless the
+// Config says otherwise.
+func DefaultCacheDir() string {
 	usr, err := user.Current()
 	if err != nil {
 		l.Fatal(err)
 	}
-	x := path.Join(usr.HomeDir, "/.cache/bldy")
-	return &x
+	return path.Join(usr.HomeDir, "/.cache/bldy")
 }
 
 func (b *Builder) Execute(ctx context.Context, r int) {
 	b.start = time.Now()
+	// actions of every node share the workers
+	b.slots = make(chan struct{}, r)
 	for i := 0; i < r; i++ {
 		go b.work(ctx, i)
 	}
 	if b.graph == nil {
 		l.Fatal("couldn't find the build graph")
 	}
-	if b.graph.Root == nil {
+	if len(b.graph.Roots) == 0 {
 		l.Fatal("couldn't find the graph root")
 	}
-	b.wg.Add(1)
-	b.visit(b.graph.Root)
+	b.wg.Add(len(b.graph.Roots))
+	for _, root := range b.graph.Roots {
+		go b.visit(root)
+	}
 	b.wg.Wait()
+	b.notifier.Done(time.Now().Sub(b.start))
 }
 
 func (b *Builder) build(e *executor.Executor, n *graph.Node) error {
@@ -133,10 +176,13 @@ func (b *Builder) build(e *executor.Executor, n *graph.Node) error {
 	n.End = time.Now().UnixNano()
 	n.Output = e.CombinedLog()
 
-	b.saveLog(n)
 	if err != nil {
 		return err
 	}
+	if err := b.saveResult(n); err != nil {
+		n.Status = build.Fail
+		return err
+	}
 	return nil
 }
 
@@ -159,6 +205,8 @@ func (b *Builder) work(ctx context.Context, workerNumber int) {
 			b.notifier.Update(job)
 			if err != nil {
 				b.notifier.Error(err)
+			} else if job.Status == build.Success {
+				b.recordInputs(job)
 			}
 			job.Once.Do(func() {
 				for _, parent := range job.Parents {
@@ -171,14 +219,13 @@ func (b *Builder) work(ctx context.Context, workerNumber int) {
 				if job.Status == build.Success {
 					b.install(job)
 				}
-				b.notifier.Done(time.Now().Sub(b.start))
 				b.wg.Done()
 			}
 			job.Unlock()
 		}
 
 		if b.cached(job) {
-			finish(b.builderror(job))
+			finish(nil)
 		} else {
 			if err := ctx.Err(); err != nil {
 				finish(err)
@@ -191,6 +238,8 @@ func (b *Builder) work(ctx context.Context, workerNumber int) {
 				return
 			}
 			e := executor.New(ctx, ns)
+			e.SetActionCache(newActionCache(b, job))
+			e.SetSlots(b.slots)
 			finish(b.build(e, job))
 		}
 
@@ -199,6 +248,15 @@ func (b *Builder) work(ctx context.Context, workerNumber int) {
 }
 
 func (b *Builder) visit(n *graph.Node) {
+	// nodes that are shared between roots or parents are only queued once
+	b.mu.Lock()
+	if b.visited[n] {
+		b.mu.Unlock()
+		return
+	}
+	b.visited[n] = true
+	b.mu.Unlock()
+
 	// This is not an airplane so let's make sure children get their masks on before the parents.
 	for _, child := range n.Children {
 		// Visit children first
@@ -318,7 +376,10 @@ func (b *Builder) bindChildOutputs(n *graph.Node, ns namespace.Namespace) error
 	return nil
 }
 func (b *Builder) prepare(ctx context.Context, n *graph.Node) (namespace.Namespace, error) {
-	// prepare
+	// whatever is in the build path is left over from an interrupted build
+	if err := os.RemoveAll(b.buildpath(n)); err != nil {
+		return nil, err
+	}
 	ns, err := b.newnamespace(n)
 	if err != nil {
 		return nil, err
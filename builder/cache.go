@@ -0,0 +1,286 @@
+package builder
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"bldy.build/build"
+	"bldy.build/build/cache"
+	"bldy.build/build/graph"
+	"bldy.build/build/racy"
+	"github.com/pkg/errors"
+)
+
+// buildpath is the directory a node is built in, outputs of the node are
+// relative to it. Nodes built in different configurations are built in
+// different directories.
+func (b *Builder) buildpath(n *graph.Node) string {
+	return filepath.Join(
+		*b.config.Cache,
+		"out",
+		outputdir(n),
+		nodeid(n),
+	)
+}
+
+func outputdir(n *graph.Node) string {
+	if n.Config == nil {
+		return build.DefaultConfig().OutputDir()
+	}
+	return n.Config.OutputDir()
+}
+
+func cachekey(n *graph.Node) string {
+	return fmt.Sprintf("%x", n.HashNode())
+}
+
+// cached looks the node up in the action cache. On a hit, every output
+// recorded for the node is copied out of the store in to the nodes build
+// path and validated against its digest. Anything short of that is a miss.
+func (b *Builder) cached(n *graph.Node) bool {
+	n.Cached = false
+	ar, err := b.store.ActionResult(cachekey(n))
+	if err != nil {
+		if err != cache.ErrNotFound {
+			l.Printf("cache lookup for %s failed: %v", n.Label, err)
+		}
+		return false
+	}
+	if err := b.materialize(n, ar); err != nil {
+		l.Printf("cached outputs of %s are unusable: %v", n.Label, err)
+		return false
+	}
+	n.Output = ar.Log
+	n.Status = build.Success
+	n.Cached = true
+	return true
+}
+
+// materialize restores the outputs of the action result in to the build path
+// of the node. They are restored next to it and swapped in once all of them
+// check out, so a failed restore leaves the build path as it was.
+func (b *Builder) materialize(n *graph.Node, ar *cache.ActionResult) error {
+	if err := ar.Valid(); err != nil {
+		return err
+	}
+	for _, output := range n.Target.Outputs() {
+		if !recorded(ar, output) {
+			return fmt.Errorf("declared output %q isn't recorded", output)
+		}
+	}
+	dir := b.buildpath(n)
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(filepath.Dir(dir), ".bldy")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	for _, out := range ar.Outputs {
+		if err := b.materializeFile(tmp, out); err != nil {
+			return errors.Wrap(err, out.Path)
+		}
+	}
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dir)
+}
+
+// recorded reports whether the action result has the output, or any of the
+// files in it when it's a directory.
+func recorded(ar *cache.ActionResult, output string) bool {
+	output = filepath.Clean(output)
+	for _, out := range ar.Outputs {
+		p := filepath.Clean(out.Path)
+		if p == output || strings.HasPrefix(p, output+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// materializeFile copies the output out of the store in to dir.
+func (b *Builder) materializeFile(dir string, out cache.OutputFile) error {
+	if err := out.Valid(); err != nil {
+		return err
+	}
+	dst := filepath.Join(dir, out.Path)
+	blob, err := b.store.Blob(out.Digest)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), ".bldy")
+	if err != nil {
+		return err
+	}
+	dgst, _, err := cache.Compute(io.TeeReader(blob, tmp))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && dgst != out.Digest {
+		if d, ok := b.store.(cache.Evicter); ok {
+			d.Delete(out.Digest)
+		}
+		err = cache.ErrDigestMismatch
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), out.Mode.Perm())
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// saveResult records the outputs of a successfully built node in the store.
+// Every declared output has to exist, otherwise there is nothing trustworthy
+// to record and the build fails.
+func (b *Builder) saveResult(n *graph.Node) error {
+	outputs, err := b.storeOutputs(b.buildpath(n), n.Target.Outputs())
+	if err != nil {
+		return errors.Wrapf(err, "%s", n.Label)
+	}
+	ar := &cache.ActionResult{Outputs: outputs, Log: n.Output}
+	return b.store.PutActionResult(cachekey(n), ar)
+}
+
+// storeOutputs puts the outputs, relative to dir, in the store. Outputs that
+// are directories are stored file by file.
+func (b *Builder) storeOutputs(dir string, outputs []string) ([]cache.OutputFile, error) {
+	var files []cache.OutputFile
+	for _, output := range outputs {
+		err := filepath.Walk(filepath.Join(dir, output), func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			out, err := b.storeFile(path)
+			if err != nil {
+				return err
+			}
+			if out.Path, err = filepath.Rel(dir, path); err != nil {
+				return err
+			}
+			files = append(files, out)
+			return nil
+		})
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("didn't produce the declared output %q", output)
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "saving output %q", output)
+		}
+	}
+	return files, nil
+}
+
+// actionCache caches the outputs of the actions of a node, so when a node
+// has to be rebuilt only the actions whose inputs changed run again. The
+// same action can do different things in different configurations and
+// namespaces, so they are part of the keys it caches actions under.
+type actionCache struct {
+	b   *Builder
+	dir string
+	// salt is the configuration and the namespace of the node.
+	salt string
+}
+
+func newActionCache(b *Builder, n *graph.Node) *actionCache {
+	config := build.DefaultConfig()
+	if n.Config != nil {
+		config = n.Config
+	}
+	salt := fmt.Sprintf("%snamespace %q %s/%s\n", config, n.Target.Platform(), runtime.GOOS, runtime.GOARCH)
+	return &actionCache{b: b, dir: b.buildpath(n), salt: salt}
+}
+
+// key returns the key the action whose key is key is cached under.
+func (c *actionCache) key(key string) string {
+	h := racy.NewHash()
+	io.WriteString(h, c.salt)
+	io.WriteString(h, key)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func (c *actionCache) Get(key string) (bool, error) {
+	ar, err := c.b.store.ActionResult(c.key(key))
+	if err == cache.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if err := ar.Valid(); err != nil {
+		return false, err
+	}
+	for _, out := range ar.Outputs {
+		if err := c.b.materializeFile(c.dir, out); err != nil {
+			return false, errors.Wrap(err, out.Path)
+		}
+	}
+	return true, nil
+}
+
+func (c *actionCache) Put(key string, outputs []string) error {
+	files, err := c.b.storeOutputs(c.dir, outputs)
+	if err != nil {
+		return err
+	}
+	return c.b.store.PutActionResult(c.key(key), &cache.ActionResult{Outputs: files})
+}
+
+func (b *Builder) storeFile(path string) (cache.OutputFile, error) {
+	// child outputs are symlinked in to the build path, so we follow them.
+	stat, err := os.Stat(path)
+	if err != nil {
+		return cache.OutputFile{}, err
+	}
+	dgst, size, err := cache.ComputeFile(path)
+	if err != nil {
+		return cache.OutputFile{}, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return cache.OutputFile{}, err
+	}
+	defer f.Close()
+	if err := b.store.PutBlob(dgst, f); err != nil {
+		return cache.OutputFile{}, err
+	}
+	return cache.OutputFile{
+		Digest: dgst,
+		Size:   size,
+		Mode:   stat.Mode(),
+	}, nil
+}
+
+// recordInputs keeps the inputs of the nodes hash around so the next build
+// can explain why it had to rebuild the node.
+func (b *Builder) recordInputs(n *graph.Node) {
+	rec := &cache.InputRecord{
+		Label:  n.Label.String(),
+		Hash:   cachekey(n),
+		Inputs: n.HashInputs(),
+	}
+	if err := b.local.PutLastInputs(rec); err != nil {
+		l.Printf("recording the inputs of %s failed: %v", n.Label, err)
+	}
+}
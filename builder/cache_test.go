@@ -0,0 +1,198 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bldy.build/build/cache"
+	"bldy.build/build/executor"
+	"bldy.build/build/graph"
+	"bldy.build/build/label"
+	"bldy.build/build/workspace"
+)
+
+type testRule struct {
+	name    string
+	outputs []string
+}
+
+func (r *testRule) Name() string                   { return r.name }
+func (r *testRule) Dependencies() []label.Label    { return nil }
+func (r *testRule) Outputs() []string              { return r.outputs }
+func (r *testRule) Hash() []byte                   { return []byte(r.name) }
+func (r *testRule) Build(*executor.Executor) error { return nil }
+func (r *testRule) Platform() label.Label          { return "" }
+func (r *testRule) Workspace() workspace.Workspace { return nil }
+
+// testBuilder returns a builder that caches in a temporary directory.
+func testBuilder(t *testing.T) *Builder {
+	dir, err := ioutil.TempDir("", "bldy_builder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk, err := cache.NewDisk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Builder{config: &Config{Cache: &dir}, store: disk, local: disk}
+}
+
+func testNode(outputs ...string) *graph.Node {
+	return &graph.Node{Label: "//a:b", Target: &testRule{name: "b", outputs: outputs}}
+}
+
+// writeOutputs writes the files in to the build path of the node.
+func writeOutputs(t *testing.T, b *Builder, n *graph.Node, files map[string]string) {
+	for name, contents := range files {
+		p := filepath.Join(b.buildpath(n), name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCached(t *testing.T) {
+	b := testBuilder(t)
+	defer os.RemoveAll(*b.config.Cache)
+
+	n := testNode("a.txt", "dir")
+	files := map[string]string{"a.txt": "a", "dir/b.txt": "b"}
+	writeOutputs(t, b, n, files)
+	n.Output = "built"
+	if err := b.saveResult(n); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(b.buildpath(n)); err != nil {
+		t.Fatal(err)
+	}
+
+	n = testNode("a.txt", "dir")
+	if !b.cached(n) {
+		t.Fatal("was expecting the node to be cached")
+	}
+	if !n.Cached || n.Output != "built" {
+		t.Logf("was expecting a cached node with the log %q got %v %q instead", "built", n.Cached, n.Output)
+		t.Fail()
+	}
+	for name, want := range files {
+		got, err := ioutil.ReadFile(filepath.Join(b.buildpath(n), name))
+		if err != nil || string(got) != want {
+			t.Logf("was expecting %s to be %q got %q %v instead", name, want, got, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestMissingOutput(t *testing.T) {
+	b := testBuilder(t)
+	defer os.RemoveAll(*b.config.Cache)
+
+	n := testNode("a.txt", "missing.txt")
+	writeOutputs(t, b, n, map[string]string{"a.txt": "a"})
+	if err := b.saveResult(n); err == nil || !strings.Contains(err.Error(), `didn't produce the declared output "missing.txt"`) {
+		t.Fatalf("was expecting the missing output to fail the save got %v instead", err)
+	}
+	if _, err := b.store.ActionResult(cachekey(n)); err != cache.ErrNotFound {
+		t.Logf("was expecting no action result to be recorded got %v instead", err)
+		t.Fail()
+	}
+}
+
+func TestCachedUnusable(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(t *testing.T, b *Builder, ar *cache.ActionResult)
+	}{
+		{
+			name: "truncated blob",
+			corrupt: func(t *testing.T, b *Builder, ar *cache.ActionResult) {
+				dgst := string(ar.Outputs[len(ar.Outputs)-1].Digest)
+				p := filepath.Join(*b.config.Cache, "cas", dgst[:2], dgst)
+				if err := os.Truncate(p, 1); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "missing blob",
+			corrupt: func(t *testing.T, b *Builder, ar *cache.ActionResult) {
+				dgst := string(ar.Outputs[len(ar.Outputs)-1].Digest)
+				if err := os.Remove(filepath.Join(*b.config.Cache, "cas", dgst[:2], dgst)); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "invalid action result",
+			corrupt: func(t *testing.T, b *Builder, ar *cache.ActionResult) {
+				key := cachekey(testNode())
+				if err := ioutil.WriteFile(filepath.Join(*b.config.Cache, "ac", key[:2], key), []byte("{"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "escaping path",
+			corrupt: func(t *testing.T, b *Builder, ar *cache.ActionResult) {
+				ar.Outputs[0].Path = "../../a.txt"
+				if err := b.store.PutActionResult(cachekey(testNode()), ar); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "unrecorded output",
+			corrupt: func(t *testing.T, b *Builder, ar *cache.ActionResult) {
+				ar.Outputs = ar.Outputs[:1]
+				if err := b.store.PutActionResult(cachekey(testNode()), ar); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := testBuilder(t)
+			defer os.RemoveAll(*b.config.Cache)
+
+			n := testNode("a.txt", "b.txt")
+			writeOutputs(t, b, n, map[string]string{"a.txt": "contents of a", "b.txt": "contents of b"})
+			if err := b.saveResult(n); err != nil {
+				t.Fatal(err)
+			}
+			ar, err := b.store.ActionResult(cachekey(n))
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.corrupt(t, b, ar)
+			// whatever is in the build path has to survive a failed restore
+			if err := os.RemoveAll(b.buildpath(n)); err != nil {
+				t.Fatal(err)
+			}
+			writeOutputs(t, b, n, map[string]string{"old.txt": "old"})
+
+			n = testNode("a.txt", "b.txt")
+			if b.cached(n) || n.Cached {
+				t.Fatal("was expecting unusable outputs to be a miss")
+			}
+			infos, err := ioutil.ReadDir(b.buildpath(n))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(infos) != 1 || infos[0].Name() != "old.txt" {
+				t.Logf("was expecting the build path to be left alone got %v instead", infos)
+				t.Fail()
+			}
+			if infos, _ := ioutil.ReadDir(filepath.Dir(b.buildpath(n))); len(infos) != 1 {
+				t.Logf("was expecting the restored outputs to be cleaned up got %d entries instead", len(infos))
+				t.Fail()
+			}
+		})
+	}
+}
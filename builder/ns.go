@@ -3,7 +3,6 @@ package builder
 import (
 	"errors"
 	"fmt"
-	"path/filepath"
 	"runtime"
 
 	"bldy.build/build"
@@ -23,9 +22,9 @@ func nodeid(n *graph.Node) string {
 func (b *Builder) newnamespace(n *graph.Node) (namespace.Namespace, error) {
 	switch {
 	case n.Target.Platform() == build.HostPlatform:
-		return host.New(filepath.Join(*b.config.Cache, nodeid(n)))
+		return host.New(b.buildpath(n))
 	case n.Target.Platform().Repo() == "docker":
-		return docker.New(n.Target.Platform(), filepath.Join(*b.config.Cache, nodeid(n)), nodeid(n), "debian:jessie")
+		return docker.New(n.Target.Platform(), b.buildpath(n), nodeid(n), "debian:jessie")
 	}
 	return nil, ErrHostNotAvailable
 }
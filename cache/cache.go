@@ -0,0 +1,123 @@
+// Copyright 2018 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package cache implements a content addressable store for build outputs.
+//
+// A store has two halves: blobs, which are keyed by the digest of their
+// contents, and action results, which are keyed by the hash of the node
+// that produced them. An action result lists every output a node declared
+// along with the digest of the blob that holds its contents, so a cache hit
+// can be validated and materialized without trusting the state of any
+// particular build directory.
+package cache // import "bldy.build/build/cache"
+
+import (
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"bldy.build/build/racy"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotFound is returned when a blob or an action result isn't in the store.
+	ErrNotFound = errors.New("cache: not found")
+	// ErrDigestMismatch is returned when the contents of a blob don't hash to its digest.
+	ErrDigestMismatch = errors.New("cache: digest mismatch")
+	// ErrInvalidKey is returned for keys and digests that aren't hex encoded hashes.
+	ErrInvalidKey = errors.New("cache: invalid key")
+	// ErrInvalidPath is returned for outputs whose paths aren't inside the
+	// directory the node was built in.
+	ErrInvalidPath = errors.New("cache: invalid path")
+)
+
+// Digest is the hex encoded hash of a blob's contents.
+type Digest string
+
+// Valid checks if a digest could have been produced by racy.NewHash.
+func (d Digest) Valid() error {
+	b, err := hex.DecodeString(string(d))
+	if err != nil || len(b) != racy.NewHash().Size() {
+		return errors.Wrapf(ErrInvalidKey, "digest %q", d)
+	}
+	return nil
+}
+
+// Compute reads r to completion and returns the digest of its contents.
+func Compute(r io.Reader) (Digest, int64, error) {
+	h := racy.NewHash()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", n, err
+	}
+	return Digest(hex.EncodeToString(h.Sum(nil))), n, nil
+}
+
+// ComputeFile returns the digest of the file at path.
+func ComputeFile(path string) (Digest, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	return Compute(f)
+}
+
+// OutputFile is a single output recorded in an action result.
+type OutputFile struct {
+	// Path is relative to the directory the node was built in.
+	Path   string
+	Digest Digest
+	Size   int64
+	Mode   os.FileMode
+}
+
+// Valid checks the path of the output is relative and doesn't leave the
+// directory the node was built in. Action results can come from other
+// machines, so their paths can't be trusted.
+func (o OutputFile) Valid() error {
+	p := filepath.Clean(o.Path)
+	if filepath.IsAbs(o.Path) || p == "." || p == ".." || strings.HasPrefix(p, ".."+string(filepath.Separator)) {
+		return errors.Wrapf(ErrInvalidPath, "output %q", o.Path)
+	}
+	return nil
+}
+
+// ActionResult is the record of a successful build of a node.
+type ActionResult struct {
+	Outputs []OutputFile
+	// Log is the combined log of the executor that built the node.
+	Log string
+}
+
+// Valid checks every output of the action result is valid.
+func (ar *ActionResult) Valid() error {
+	for _, o := range ar.Outputs {
+		if err := o.Valid(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Store is a content addressable store paired with an action cache.
+type Store interface {
+	// ActionResult returns the action result recorded under key.
+	ActionResult(key string) (*ActionResult, error)
+	// PutActionResult records ar under key.
+	PutActionResult(key string, ar *ActionResult) error
+	// Blob returns the contents of the blob with the digest d.
+	Blob(d Digest) (io.ReadCloser, error)
+	// PutBlob stores the contents of r, which should hash to d.
+	PutBlob(d Digest, r io.Reader) error
+}
+
+// Evicter is implemented by stores that can drop blobs which turned out
+// to be corrupt.
+type Evicter interface {
+	Delete(d Digest) error
+}
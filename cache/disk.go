@@ -0,0 +1,184 @@
+// Copyright 2018 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"bldy.build/build/racy"
+	"github.com/pkg/errors"
+)
+
+const (
+	acDir  = "ac"
+	casDir = "cas"
+	tmpDir = "tmp"
+)
+
+// Disk is a Store that keeps everything in a directory.
+//
+// All writes go to a temporary file first and are renamed in to place,
+// so readers never observe a partially written blob or action result
+// even if the process writing it crashes.
+type Disk struct {
+	root string
+}
+
+// NewDisk returns a store rooted at dir, creating it if necessary.
+func NewDisk(dir string) (*Disk, error) {
+	for _, sub := range []string{acDir, casDir, tmpDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, errors.Wrap(err, "cache: new disk")
+		}
+	}
+	return &Disk{root: dir}, nil
+}
+
+// Root returns the directory the store lives in.
+func (d *Disk) Root() string { return d.root }
+
+func (d *Disk) path(kind, key string) (string, error) {
+	if _, err := hex.DecodeString(key); err != nil || len(key) < 2 {
+		return "", errors.Wrapf(ErrInvalidKey, "key %q", key)
+	}
+	return filepath.Join(d.root, kind, key[:2], key), nil
+}
+
+// ActionResult returns the action result recorded under key.
+func (d *Disk) ActionResult(key string) (*ActionResult, error) {
+	p, err := d.path(acDir, key)
+	if err != nil {
+		return nil, err
+	}
+	bytz, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, errors.Wrap(err, "cache: action result")
+	}
+	var ar ActionResult
+	if err := json.Unmarshal(bytz, &ar); err != nil {
+		return nil, errors.Wrapf(err, "cache: action result %s", key)
+	}
+	return &ar, nil
+}
+
+// PutActionResult records ar under key.
+func (d *Disk) PutActionResult(key string, ar *ActionResult) error {
+	p, err := d.path(acDir, key)
+	if err != nil {
+		return err
+	}
+	bytz, err := json.Marshal(ar)
+	if err != nil {
+		return errors.Wrap(err, "cache: put action result")
+	}
+	tmp, err := d.tempFile()
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(bytz); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "cache: put action result")
+	}
+	return d.commit(tmp, p)
+}
+
+// Blob returns the contents of the blob with the digest dgst.
+func (d *Disk) Blob(dgst Digest) (io.ReadCloser, error) {
+	p, err := d.path(casDir, string(dgst))
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, errors.Wrap(err, "cache: blob")
+	}
+	return f, nil
+}
+
+// PutBlob stores the contents of r. The contents are hashed while they are
+// being written and the blob is discarded if they don't match dgst.
+func (d *Disk) PutBlob(dgst Digest, r io.Reader) error {
+	if err := dgst.Valid(); err != nil {
+		return err
+	}
+	p, err := d.path(casDir, string(dgst))
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(p); err == nil {
+		// blobs are immutable, there is no point in writing it again.
+		_, err = io.Copy(ioutil.Discard, r)
+		return err
+	}
+	tmp, err := d.tempFile()
+	if err != nil {
+		return err
+	}
+	h := racy.NewHash()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "cache: put blob")
+	}
+	if got := Digest(hex.EncodeToString(h.Sum(nil))); got != dgst {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return ErrDigestMismatch
+	}
+	return d.commit(tmp, p)
+}
+
+// Delete removes the blob with the digest dgst, it is used for evicting
+// blobs that turned out to be corrupt.
+func (d *Disk) Delete(dgst Digest) error {
+	p, err := d.path(casDir, string(dgst))
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (d *Disk) tempFile() (*os.File, error) {
+	f, err := ioutil.TempFile(filepath.Join(d.root, tmpDir), "bldy")
+	if err != nil {
+		return nil, errors.Wrap(err, "cache: temp file")
+	}
+	return f, nil
+}
+
+// commit syncs and closes tmp and renames it to p.
+func (d *Disk) commit(tmp *os.File, p string) error {
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "cache: commit")
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "cache: commit")
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "cache: commit")
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "cache: commit")
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestDisk(t *testing.T) *Disk {
+	dir, err := ioutil.TempDir("", "bldy_cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDisk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestBlob(t *testing.T) {
+	d := newTestDisk(t)
+	defer os.RemoveAll(d.Root())
+
+	contents := "int main() { return 0; }"
+	dgst, size, err := Compute(strings.NewReader(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(contents)) {
+		t.Logf("was expecting size %d got %d instead", len(contents), size)
+		t.Fail()
+	}
+	if _, err := d.Blob(dgst); err != ErrNotFound {
+		t.Logf("was expecting %v got %v instead", ErrNotFound, err)
+		t.Fail()
+	}
+	if err := d.PutBlob(dgst, strings.NewReader(contents)); err != nil {
+		t.Fatal(err)
+	}
+	r, err := d.Blob(dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadAll(r)
+	r.Close()
+	if !bytes.Equal(got, []byte(contents)) {
+		t.Logf("was expecting %q got %q instead", contents, got)
+		t.Fail()
+	}
+}
+
+func TestBlobMismatch(t *testing.T) {
+	d := newTestDisk(t)
+	defer os.RemoveAll(d.Root())
+
+	dgst, _, _ := Compute(strings.NewReader("a"))
+	if err := d.PutBlob(dgst, strings.NewReader("b")); err != ErrDigestMismatch {
+		t.Logf("was expecting %v got %v instead", ErrDigestMismatch, err)
+		t.Fail()
+	}
+	if _, err := d.Blob(dgst); err != ErrNotFound {
+		t.Logf("mismatched blob shouldn't be stored, got %v", err)
+		t.Fail()
+	}
+	files, _ := ioutil.ReadDir(d.Root() + "/" + tmpDir)
+	if len(files) != 0 {
+		t.Logf("temporary files weren't cleaned up: %d left", len(files))
+		t.Fail()
+	}
+}
+
+func TestActionResult(t *testing.T) {
+	d := newTestDisk(t)
+	defer os.RemoveAll(d.Root())
+
+	dgst, size, _ := Compute(strings.NewReader("a"))
+	ar := &ActionResult{
+		Outputs: []OutputFile{{Path: "bin/hello", Digest: dgst, Size: size, Mode: 0755}},
+		Log:     "clang hello.c",
+	}
+	key := "deadbeef"
+	if _, err := d.ActionResult(key); err != ErrNotFound {
+		t.Logf("was expecting %v got %v instead", ErrNotFound, err)
+		t.Fail()
+	}
+	if err := d.PutActionResult(key, ar); err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.ActionResult(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ar, got) {
+		t.Logf("was expecting %v got %v instead", ar, got)
+		t.Fail()
+	}
+}
+
+func TestInvalidKey(t *testing.T) {
+	d := newTestDisk(t)
+	defer os.RemoveAll(d.Root())
+
+	for _, key := range []string{"", "../../etc/passwd", "x"} {
+		if err := d.PutActionResult(key, &ActionResult{}); err == nil {
+			t.Logf("%q should not be a valid key", key)
+			t.Fail()
+		}
+	}
+}
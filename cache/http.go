@@ -0,0 +1,189 @@
+// Copyright 2018 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The remote protocol is plain HTTP:
+//
+//	GET /ac/<key>       returns the JSON encoded action result recorded under key
+//	PUT /ac/<key>       records the JSON encoded action result in the body under key
+//	GET /cas/<digest>   returns the blob with the digest
+//	PUT /cas/<digest>   stores the body, which has to hash to digest
+//
+// Missing entries are reported with 404.
+
+// Timeout is how long requests to a remote cache can take, a remote cache
+// that hangs shouldn't hang the build with it.
+var Timeout = 30 * time.Second
+
+// HTTP is a Store that talks to a remote cache.
+type HTTP struct {
+	base   *url.URL
+	client *http.Client
+}
+
+// NewHTTP returns a remote store at the given base URL.
+func NewHTTP(base string) (*HTTP, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, errors.Wrap(err, "cache: new http")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("cache: new http: %q is not an http url", base)
+	}
+	return &HTTP{base: u, client: &http.Client{Timeout: Timeout}}, nil
+}
+
+func (h *HTTP) url(kind, key string) string {
+	u := *h.base
+	u.Path = path.Join(u.Path, kind, key)
+	return u.String()
+}
+
+func (h *HTTP) get(kind, key string) (io.ReadCloser, error) {
+	resp, err := h.client.Get(h.url(kind, key))
+	if err != nil {
+		return nil, errors.Wrap(err, "cache: http get")
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("cache: http get %s/%s: %s", kind, key, resp.Status)
+	}
+}
+
+func (h *HTTP) put(kind, key string, r io.Reader) error {
+	req, err := http.NewRequest(http.MethodPut, h.url(kind, key), r)
+	if err != nil {
+		return errors.Wrap(err, "cache: http put")
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "cache: http put")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("cache: http put %s/%s: %s: %s", kind, key, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// ActionResult returns the action result recorded under key.
+func (h *HTTP) ActionResult(key string) (*ActionResult, error) {
+	body, err := h.get(acDir, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	var ar ActionResult
+	if err := json.NewDecoder(body).Decode(&ar); err != nil {
+		return nil, errors.Wrapf(err, "cache: action result %s", key)
+	}
+	return &ar, nil
+}
+
+// PutActionResult records ar under key.
+func (h *HTTP) PutActionResult(key string, ar *ActionResult) error {
+	bytz, err := json.Marshal(ar)
+	if err != nil {
+		return errors.Wrap(err, "cache: put action result")
+	}
+	return h.put(acDir, key, bytes.NewReader(bytz))
+}
+
+// Blob returns the contents of the blob with the digest d.
+func (h *HTTP) Blob(d Digest) (io.ReadCloser, error) {
+	return h.get(casDir, string(d))
+}
+
+// PutBlob stores the contents of r, which should hash to d.
+func (h *HTTP) PutBlob(d Digest, r io.Reader) error {
+	return h.put(casDir, string(d), r)
+}
+
+// Handler serves s over HTTP using the same protocol HTTP speaks.
+func Handler(s Store) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+acDir+"/", func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/"+acDir+"/")
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			ar, err := s.ActionResult(key)
+			if err != nil {
+				httpError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(ar)
+		case http.MethodPut:
+			var ar ActionResult
+			if err := json.NewDecoder(r.Body).Decode(&ar); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := ar.Valid(); err != nil {
+				httpError(w, err)
+				return
+			}
+			if err := s.PutActionResult(key, &ar); err != nil {
+				httpError(w, err)
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/"+casDir+"/", func(w http.ResponseWriter, r *http.Request) {
+		dgst := Digest(strings.TrimPrefix(r.URL.Path, "/"+casDir+"/"))
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			blob, err := s.Blob(dgst)
+			if err != nil {
+				httpError(w, err)
+				return
+			}
+			defer blob.Close()
+			w.Header().Set("Content-Type", "application/octet-stream")
+			io.Copy(w, blob)
+		case http.MethodPut:
+			if err := s.PutBlob(dgst, r.Body); err != nil {
+				httpError(w, err)
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	return mux
+}
+
+func httpError(w http.ResponseWriter, err error) {
+	switch errors.Cause(err) {
+	case ErrNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case ErrDigestMismatch, ErrInvalidKey, ErrInvalidPath:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
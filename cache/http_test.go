@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTP(t *testing.T) {
+	server := newTestDisk(t)
+	defer os.RemoveAll(server.Root())
+	ts := httptest.NewServer(Handler(server))
+	defer ts.Close()
+
+	remote, err := NewHTTP(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dgst, size, _ := Compute(strings.NewReader("hello"))
+	if _, err := remote.Blob(dgst); err != ErrNotFound {
+		t.Logf("was expecting %v got %v instead", ErrNotFound, err)
+		t.Fail()
+	}
+	if err := remote.PutBlob(dgst, strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	other, _, _ := Compute(strings.NewReader("goodbye"))
+	if err := remote.PutBlob(other, strings.NewReader("hello")); err == nil {
+		t.Log("server accepted a blob that doesn't match its digest")
+		t.Fail()
+	}
+	ar := &ActionResult{Outputs: []OutputFile{{Path: "hello.txt", Digest: dgst, Size: size, Mode: 0644}}}
+	if err := remote.PutActionResult("cafe", ar); err != nil {
+		t.Fatal(err)
+	}
+	got, err := server.ActionResult("cafe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ar, got) {
+		t.Logf("was expecting %v got %v instead", ar, got)
+		t.Fail()
+	}
+	if err := remote.PutActionResult("../cafe", ar); err == nil {
+		t.Log("server accepted an invalid key")
+		t.Fail()
+	}
+	evil := &ActionResult{Outputs: []OutputFile{{Path: "../../.bashrc", Digest: dgst, Size: size, Mode: 0644}}}
+	if err := remote.PutActionResult("deadbeef", evil); err == nil {
+		t.Log("server accepted an output outside the build directory")
+		t.Fail()
+	}
+	if _, err := server.ActionResult("deadbeef"); err != ErrNotFound {
+		t.Logf("was expecting %v got %v instead", ErrNotFound, err)
+		t.Fail()
+	}
+}
+
+func TestOutputFileValid(t *testing.T) {
+	tests := []struct {
+		path  string
+		valid bool
+	}{
+		{"hello.txt", true},
+		{"a/b/hello.txt", true},
+		{"a/../hello.txt", true},
+		{"..hello", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../.bashrc", false},
+		{"../../.bashrc", false},
+		{"a/../../.bashrc", false},
+		{"/etc/passwd", false},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			err := OutputFile{Path: test.path}.Valid()
+			if valid := err == nil; valid != test.valid {
+				t.Logf("was expecting valid to be %v got %v instead", test.valid, err)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestLayeredRemoteFailure(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	}))
+	defer ts.Close()
+	remote, _ := NewHTTP(ts.URL)
+	remote.client.Timeout = 50 * time.Millisecond
+
+	local := newTestDisk(t)
+	defer os.RemoveAll(local.Root())
+	s := NewLayered(local, remote)
+	if _, err := s.ActionResult("beef"); err != ErrNotFound {
+		t.Logf("was expecting a remote that times out to be a miss got %v instead", err)
+		t.Fail()
+	}
+	dgst, _, _ := Compute(strings.NewReader("hello"))
+	if _, err := s.Blob(dgst); err != ErrNotFound {
+		t.Logf("was expecting a remote that times out to be a miss got %v instead", err)
+		t.Fail()
+	}
+}
+
+func TestLayered(t *testing.T) {
+	server := newTestDisk(t)
+	defer os.RemoveAll(server.Root())
+	ts := httptest.NewServer(Handler(server))
+	defer ts.Close()
+	remote, _ := NewHTTP(ts.URL)
+
+	// one machine populates the remote cache
+	first := newTestDisk(t)
+	defer os.RemoveAll(first.Root())
+	dgst, size, _ := Compute(strings.NewReader("hello"))
+	ar := &ActionResult{Outputs: []OutputFile{{Path: "hello.txt", Digest: dgst, Size: size, Mode: 0644}}}
+	s := NewLayered(first, remote)
+	if err := s.PutBlob(dgst, strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutActionResult("beef", ar); err != nil {
+		t.Fatal(err)
+	}
+
+	// and another one reads from it
+	second := newTestDisk(t)
+	defer os.RemoveAll(second.Root())
+	s = NewLayered(second, remote)
+	got, err := s.ActionResult("beef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ar, got) {
+		t.Logf("was expecting %v got %v instead", ar, got)
+		t.Fail()
+	}
+	blob, err := s.Blob(dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, _ := ioutil.ReadAll(blob)
+	blob.Close()
+	if string(contents) != "hello" {
+		t.Logf("was expecting %q got %q instead", "hello", contents)
+		t.Fail()
+	}
+	if blob, err := second.Blob(dgst); err != nil {
+		t.Logf("remote blob wasn't copied to the local cache: %v", err)
+		t.Fail()
+	} else {
+		blob.Close()
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2018 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"bldy.build/build/racy"
+	"github.com/pkg/errors"
+)
+
+const inputsDir = "inputs"
+
+// InputRecord holds the inputs that went in to the hash of a target the
+// last time it was built, so a later build can explain what changed.
+type InputRecord struct {
+	Label  string
+	Hash   string
+	Inputs []racy.Input
+}
+
+func (d *Disk) inputsPath(lbl string) string {
+	h := racy.NewHash()
+	io.WriteString(h, lbl)
+	return filepath.Join(d.root, inputsDir, hex.EncodeToString(h.Sum(nil)))
+}
+
+// LastInputs returns the inputs recorded the last time the target lbl was built.
+func (d *Disk) LastInputs(lbl string) (*InputRecord, error) {
+	bytz, err := ioutil.ReadFile(d.inputsPath(lbl))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, errors.Wrap(err, "cache: last inputs")
+	}
+	var rec InputRecord
+	if err := json.Unmarshal(bytz, &rec); err != nil {
+		return nil, errors.Wrapf(err, "cache: last inputs of %s", lbl)
+	}
+	return &rec, nil
+}
+
+// PutLastInputs records the inputs of the latest build of a target.
+func (d *Disk) PutLastInputs(rec *InputRecord) error {
+	bytz, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Wrap(err, "cache: put last inputs")
+	}
+	tmp, err := d.tempFile()
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(bytz); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "cache: put last inputs")
+	}
+	return d.commit(tmp, d.inputsPath(rec.Label))
+}
@@ -0,0 +1,104 @@
+// Copyright 2018 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"io"
+	"log"
+	"os"
+)
+
+var l = log.New(os.Stdout, "cache: ", 0)
+
+// Layered is a Store that puts a local disk cache in front of a remote one.
+//
+// Lookups go to the local cache first, blobs that are only found remotely
+// are copied in to the local cache as they are fetched. Writes go to both,
+// but failing to write to the remote is only logged since the build itself
+// succeeded. Failing to read from the remote is a miss.
+type Layered struct {
+	Local  *Disk
+	Remote Store
+}
+
+// NewLayered returns a store that consults local before remote.
+func NewLayered(local *Disk, remote Store) *Layered {
+	return &Layered{Local: local, Remote: remote}
+}
+
+// ActionResult returns the action result recorded under key.
+func (s *Layered) ActionResult(key string) (*ActionResult, error) {
+	ar, err := s.Local.ActionResult(key)
+	if err != ErrNotFound {
+		return ar, err
+	}
+	ar, err = s.Remote.ActionResult(key)
+	if err == ErrNotFound {
+		return nil, err
+	} else if err != nil {
+		l.Printf("fetching action result %s failed: %v", key, err)
+		return nil, ErrNotFound
+	}
+	// Blobs of remote results are fetched lazily by Blob. If some of them
+	// turn out to be missing the result fails validation and gets
+	// overwritten by the next build anyway.
+	if err := s.Local.PutActionResult(key, ar); err != nil {
+		return nil, err
+	}
+	return ar, nil
+}
+
+// PutActionResult records ar under key.
+func (s *Layered) PutActionResult(key string, ar *ActionResult) error {
+	if err := s.Local.PutActionResult(key, ar); err != nil {
+		return err
+	}
+	if err := s.Remote.PutActionResult(key, ar); err != nil {
+		l.Printf("uploading action result %s failed: %v", key, err)
+	}
+	return nil
+}
+
+// Blob returns the contents of the blob with the digest d.
+func (s *Layered) Blob(d Digest) (io.ReadCloser, error) {
+	blob, err := s.Local.Blob(d)
+	if err != ErrNotFound {
+		return blob, err
+	}
+	remote, err := s.Remote.Blob(d)
+	if err == ErrNotFound {
+		return nil, err
+	} else if err != nil {
+		l.Printf("fetching blob %s failed: %v", d, err)
+		return nil, ErrNotFound
+	}
+	err = s.Local.PutBlob(d, remote)
+	remote.Close()
+	if err != nil {
+		return nil, err
+	}
+	return s.Local.Blob(d)
+}
+
+// PutBlob stores the contents of r, which should hash to d.
+func (s *Layered) PutBlob(d Digest, r io.Reader) error {
+	if err := s.Local.PutBlob(d, r); err != nil {
+		return err
+	}
+	blob, err := s.Local.Blob(d)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	if err := s.Remote.PutBlob(d, blob); err != nil {
+		l.Printf("uploading blob %s failed: %v", d, err)
+	}
+	return nil
+}
+
+// Delete evicts the blob with the digest d from the local cache.
+func (s *Layered) Delete(d Digest) error {
+	return s.Local.Delete(d)
+}
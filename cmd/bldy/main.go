@@ -8,8 +8,10 @@ import (
 	"os"
 
 	"bldy.build/build/cmd/build"
+	"bldy.build/build/cmd/cache"
 	"bldy.build/build/cmd/query"
 	"bldy.build/build/label"
+	_ "bldy.build/build/rules/genrule"
 	"github.com/google/subcommands"
 )
 
@@ -20,6 +22,8 @@ func main() {
 	subcommands.Register(&build.BuildCmd{}, "")
 	subcommands.Register(&query.QueryCmd{}, "")
 	subcommands.Register(&query.HashCmd{}, "")
+	subcommands.Register(&query.ExplainCmd{}, "")
+	subcommands.Register(&cache.CacheCmd{}, "")
 
 	flag.Parse()
 	ctx := context.Background()
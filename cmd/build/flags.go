@@ -0,0 +1,48 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// listFlag is a flag that can be repeated, every value is appended to it.
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, " ") }
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// defineFlag collects name=value pairs, later values override earlier ones.
+type defineFlag map[string]string
+
+func (d defineFlag) String() string {
+	pairs := []string{}
+	for k, v := range d {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, " ")
+}
+func (d defineFlag) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("%q isn't in the form name=value", s)
+	}
+	d[kv[0]] = kv[1]
+	return nil
+}
+
+// envFlag is like defineFlag but a name on its own takes its value from the
+// environment bldy runs in.
+type envFlag map[string]string
+
+func (e envFlag) String() string { return defineFlag(e).String() }
+func (e envFlag) Set(s string) error {
+	if !strings.Contains(s, "=") {
+		e[s] = os.Getenv(s)
+		return nil
+	}
+	return defineFlag(e).Set(s)
+}
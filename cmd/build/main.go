@@ -8,6 +8,7 @@ import (
 	"os"
 	"runtime"
 
+	"bldy.build/build"
 	"bldy.build/build/builder"
 	"bldy.build/build/graph"
 	"bldy.build/build/label"
@@ -15,27 +16,74 @@ import (
 )
 
 type BuildCmd struct {
-	fresh bool
+	fresh     bool
+	keepGoing bool
+
+	defines         defineFlag
+	compilationMode string
+	copts, linkopts listFlag
+	actionEnv       envFlag
+	platform        string
 }
 
 func (*BuildCmd) Name() string     { return "build" }
 func (*BuildCmd) Synopsis() string { return "builds a target" }
 func (*BuildCmd) Usage() string {
-	return `build //<package>:<name>
-Builds a target
+	return `build <target pattern>...
+Builds the targets matched by the patterns, for example
+	//<package>:<name>	a single target
+	//<package>:all		every target in a package
+	//<package>/...		every target in a package and the ones beneath it
+	-//<package>/...	excludes targets matched by the pattern
 `
 }
 
 func (b *BuildCmd) SetFlags(f *flag.FlagSet) {
 	f.BoolVar(&b.fresh, "fresh", false, "use the cache or build fresh")
+	f.BoolVar(&b.keepGoing, "keep_going", false, "build the targets that loaded even if some failed to")
+
+	b.defines = make(defineFlag)
+	b.actionEnv = make(envFlag)
+	f.Var(b.defines, "define", "sets a variable for config_settings and ctx.var, `name=value`")
+	f.StringVar(&b.compilationMode, "compilation_mode", build.Fastbuild, "one of fastbuild, dbg or opt")
+	f.StringVar(&b.compilationMode, "c", build.Fastbuild, "shorthand for -compilation_mode")
+	f.Var(&b.copts, "copt", "an option to pass to compilers")
+	f.Var(&b.linkopts, "linkopt", "an option to pass to linkers")
+	f.Var(b.actionEnv, "action_env", "sets a variable in the environment of actions, `name[=value]`")
+	f.StringVar(&b.platform, "platforms", build.HostPlatform.String(), "the `label` of the platform to build for")
+}
+
+// config returns the configuration the flags describe.
+func (b *BuildCmd) config() (*build.Config, error) {
+	switch b.compilationMode {
+	case build.Fastbuild, build.Dbg, build.Opt:
+	default:
+		return nil, fmt.Errorf("%q isn't a compilation mode, it has to be one of fastbuild, dbg or opt", b.compilationMode)
+	}
+	c := build.DefaultConfig()
+	var err error
+	c.CompilationMode = b.compilationMode
+	if c.Platform, err = label.Parse(b.platform); err != nil {
+		return nil, fmt.Errorf("-platforms: %v", err)
+	}
+	c.Copts = b.copts
+	c.Linkopts = b.linkopts
+	for k, v := range b.defines {
+		c.Defines[k] = v
+	}
+	for k, v := range b.actionEnv {
+		c.ActionEnv[k] = v
+	}
+	return c, nil
 }
 
 func (b *BuildCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
-	if len(args) != 1 {
+	if f.NArg() < 1 {
 		return subcommands.ExitUsageError
 	}
-	l, ok := args[0].(label.Label)
-	if !ok {
+	config, err := b.config()
+	if err != nil {
+		fmt.Println(err.Error())
 		return subcommands.ExitUsageError
 	}
 	wd, err := os.Getwd()
@@ -43,12 +91,23 @@ func (b *BuildCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interfa
 		fmt.Println(err.Error())
 		return 3
 	}
-	g, err := graph.New(wd, string(l))
+	g, err := graph.Load(wd)
 	if err != nil {
 		fmt.Println(err.Error())
 		return 4
 	}
-	if g == nil {
+	if err := g.SetConfig(config); err != nil {
+		fmt.Println(err.Error())
+		return 4
+	}
+	loadErr := g.AddRoots(f.Args()...)
+	if loadErr != nil {
+		fmt.Println(loadErr.Error())
+		if !b.keepGoing {
+			return 4
+		}
+	}
+	if len(g.Roots) == 0 {
 		fmt.Println("nothing to build")
 		return 5
 	}
@@ -62,7 +121,7 @@ func (b *BuildCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interfa
 	)
 	bldr.Execute(ctx, int(math.Round(workers)))
 
-	if err != nil {
+	if loadErr != nil {
 		return subcommands.ExitFailure
 	}
 	return subcommands.ExitSuccess
@@ -0,0 +1,71 @@
+// Copyright 2018 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/user"
+	"path"
+
+	"bldy.build/build/cache"
+	"github.com/google/subcommands"
+)
+
+type CacheCmd struct{}
+
+func (*CacheCmd) Name() string     { return "cache" }
+func (*CacheCmd) Synopsis() string { return "manages the build cache" }
+func (*CacheCmd) Usage() string {
+	return `cache serve [-addr :7070] [-dir ~/.cache/bldy-remote]
+Serves a disk backed remote cache over HTTP. Point builds at it by setting
+BLDY_REMOTE_CACHE=http://<host>:7070 in bldy.cfg or the environment.
+`
+}
+
+func (c *CacheCmd) SetFlags(f *flag.FlagSet) {}
+
+func (c *CacheCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if f.NArg() < 1 {
+		return subcommands.ExitUsageError
+	}
+	switch f.Arg(0) {
+	case "serve":
+		return serve(f.Args()[1:])
+	}
+	return subcommands.ExitUsageError
+}
+
+func serve(args []string) subcommands.ExitStatus {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("addr", ":7070", "address to listen on")
+	dir := fs.String("dir", defaultDir(), "directory to keep the cache in")
+	if err := fs.Parse(args); err != nil {
+		return subcommands.ExitUsageError
+	}
+	store, err := cache.NewDisk(*dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return subcommands.ExitFailure
+	}
+	log.Printf("serving %s on %s", *dir, *addr)
+	if err := http.ListenAndServe(*addr, cache.Handler(store)); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+func defaultDir() string {
+	usr, err := user.Current()
+	if err != nil {
+		return "bldy-remote"
+	}
+	return path.Join(usr.HomeDir, ".cache/bldy-remote")
+}
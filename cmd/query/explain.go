@@ -0,0 +1,106 @@
+package query
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"bldy.build/build/builder"
+	"bldy.build/build/cache"
+	"bldy.build/build/graph"
+	"bldy.build/build/label"
+	"github.com/google/subcommands"
+)
+
+type ExplainCmd struct {
+	cache string
+}
+
+func (*ExplainCmd) Name() string     { return "explain" }
+func (*ExplainCmd) Synopsis() string { return "explains why a target will be rebuilt" }
+func (*ExplainCmd) Usage() string {
+	return `explain //<package>:<name>
+//<package>:<name> changed
+  ~ <input>: <last build> -> <now>
+`
+}
+
+func (e *ExplainCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&e.cache, "cache", builder.DefaultCacheDir(), "cache the last build was recorded in")
+}
+
+func (e *ExplainCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if len(args) != 1 {
+		return subcommands.ExitUsageError
+	}
+	l, ok := args[0].(label.Label)
+	if !ok {
+		return subcommands.ExitUsageError
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		fmt.Println(err.Error())
+		return 4
+	}
+	g, err := graph.New(wd, string(l))
+	if err != nil {
+		fmt.Println(err.Error())
+		return 4
+	}
+	disk, err := cache.NewDisk(e.cache)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 4
+	}
+	explain(subcommands.DefaultCommander.Output, g.Root, disk, 0, make(map[string]bool))
+	return subcommands.ExitSuccess
+}
+
+// explain prints the inputs of n that changed since the last time it was
+// built, and does the same for every dependency whose hash changed.
+func explain(w io.Writer, n *graph.Node, disk *cache.Disk, depth int, seen map[string]bool) {
+	indent := strings.Repeat("  ", depth)
+	lbl := n.Label.String()
+	if seen[lbl] {
+		return
+	}
+	seen[lbl] = true
+
+	rec, err := disk.LastInputs(lbl)
+	if err == cache.ErrNotFound {
+		fmt.Fprintf(w, "%s%s has never been built\n", indent, lbl)
+		return
+	} else if err != nil {
+		fmt.Fprintf(w, "%s%s: %v\n", indent, lbl, err)
+		return
+	}
+	if rec.Hash == fmt.Sprintf("%x", n.HashNode()) {
+		fmt.Fprintf(w, "%s%s is unchanged\n", indent, lbl)
+		return
+	}
+	fmt.Fprintf(w, "%s%s changed\n", indent, lbl)
+	changes := graph.DiffInputs(rec.Inputs, n.HashInputs())
+	for _, c := range changes {
+		switch {
+		case len(c.Old) == 1 && len(c.New) == 1:
+			fmt.Fprintf(w, "%s  ~ %s: %s -> %s\n", indent, c.Name, c.Old[0], c.New[0])
+		default:
+			for _, v := range c.Old {
+				fmt.Fprintf(w, "%s  - %s: %s\n", indent, c.Name, v)
+			}
+			for _, v := range c.New {
+				fmt.Fprintf(w, "%s  + %s: %s\n", indent, c.Name, v)
+			}
+		}
+	}
+	for _, c := range changes {
+		for _, child := range n.Children {
+			if c.Name == graph.DepInput(child.Label.String()) && len(c.New) > 0 {
+				explain(w, child, disk, depth+1, seen)
+			}
+		}
+	}
+}
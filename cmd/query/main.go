@@ -6,35 +6,46 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	"bldy.build/build/graph"
 	"bldy.build/build/label"
+	"bldy.build/build/query"
 	"github.com/google/subcommands"
-	"sevki.org/x/pretty"
 )
 
 type QueryCmd struct {
-	fresh bool
+	output string
 }
 
 func (*QueryCmd) Name() string     { return "query" }
-func (*QueryCmd) Synopsis() string { return "queries a target" }
+func (*QueryCmd) Synopsis() string { return "queries the build graph" }
 func (*QueryCmd) Usage() string {
-	return `query //<package>:<name>
-{
-...
-}
+	return `query [-output=label|json|dot|graphml] <expression>
+Prints the targets the expression evaluates to, for example
+
+	query 'rdeps(//..., //lib:foo)'		everything that depends on //lib:foo
+	query 'deps(//cmd:bar, 1)'		//cmd:bar and its direct dependencies
+	query 'kind(cc_library, //lib/...) - //lib:foo'
+
 `
 }
 
-func (q *QueryCmd) SetFlags(f *flag.FlagSet) {}
+func (q *QueryCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&q.output, "output", "label", "output format, one of label, json, dot or graphml")
+}
 
 func (q *QueryCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
-	if len(args) != 1 {
+	if f.NArg() < 1 {
 		return subcommands.ExitUsageError
 	}
-	l, ok := args[0].(label.Label)
-	if !ok {
+	if _, ok := query.Formats[q.output]; !ok {
+		fmt.Fprintf(subcommands.DefaultCommander.Error, "%q is not an output format\n", q.output)
+		return subcommands.ExitUsageError
+	}
+	expr, err := query.Parse(strings.Join(f.Args(), " "))
+	if err != nil {
+		fmt.Println(err.Error())
 		return subcommands.ExitUsageError
 	}
 	wd, err := os.Getwd()
@@ -42,15 +53,20 @@ func (q *QueryCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interfa
 		fmt.Println(err.Error())
 		return 4
 	}
-	g, err := graph.New(wd, string(l))
+	g, err := graph.Load(wd)
 	if err != nil {
 		fmt.Println(err.Error())
 		return 4
 	}
-	if g == nil {
-		io.WriteString(subcommands.DefaultCommander.Error, "we could not construct your graph")
+	nodes, err := query.Eval(g, expr)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 4
+	}
+	if err := query.Write(subcommands.DefaultCommander.Output, q.output, nodes); err != nil {
+		fmt.Println(err.Error())
+		return 4
 	}
-	fmt.Fprintln(subcommands.DefaultCommander.Output, pretty.JSON(g.Root.Target))
 	return subcommands.ExitSuccess
 }
 
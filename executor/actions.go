@@ -0,0 +1,229 @@
+// Copyright 2018 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package executor
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"sync"
+
+	"bldy.build/build/racy"
+	"github.com/pkg/errors"
+)
+
+// Spec describes what an action reads and writes.
+type Spec struct {
+	// Inputs are the files the action reads, relative to the namespace
+	// or absolute for source files.
+	Inputs []string
+	// Outputs are the files the action creates, relative to the namespace.
+	Outputs []string
+	// Key covers everything the action does except the contents of its
+	// inputs, like its command line and environment.
+	Key string
+	// Cache is whether the outputs of the action are worth caching, cheap
+	// actions like writing a file aren't.
+	Cache bool
+}
+
+// Describer is implemented by actions that describe their inputs and
+// outputs. Described actions only wait for the actions that create their
+// inputs, and their outputs can be cached on their own. Actions that
+// aren't described, or don't have any outputs, run after every action that
+// comes before them and before every action that comes after them.
+type Describer interface {
+	Action
+	Describe() Spec
+}
+
+// ActionCache caches the outputs of individual actions.
+type ActionCache interface {
+	// Get copies the outputs recorded under key in to the namespace, it
+	// returns false if nothing was recorded under key.
+	Get(key string) (bool, error)
+	// Put records the outputs under key.
+	Put(key string, outputs []string) error
+}
+
+// SetActionCache sets the cache RunActions looks actions up in.
+func (e *Executor) SetActionCache(c ActionCache) { e.cache = c }
+
+// SetSlots limits how many actions RunActions runs at once to the capacity
+// of slots, which can be shared between executors. Without slots actions
+// run one at a time.
+func (e *Executor) SetSlots(slots chan struct{}) { e.slots = slots }
+
+// RunActions runs the actions, concurrently where they don't depend on
+// each other, calling done after each action that has been run or
+// fetched from the cache. If an action fails the actions that haven't
+// started are skipped, the error of the first failed action is returned.
+func (e *Executor) RunActions(actions []Action, done func(Action) error) error {
+	deps := dependencies(actions)
+	slots := e.slots
+	if slots == nil {
+		slots = make(chan struct{}, 1)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		failed   bool
+		errs     = make([]error, len(actions))
+		finished = make([]chan struct{}, len(actions))
+	)
+	for i := range actions {
+		finished[i] = make(chan struct{})
+	}
+	for i, a := range actions {
+		wg.Add(1)
+		go func(i int, a Action) {
+			defer wg.Done()
+			defer close(finished[i])
+			for _, d := range deps[i] {
+				<-finished[d]
+			}
+			mu.Lock()
+			skip := failed
+			mu.Unlock()
+			if skip {
+				return
+			}
+
+			slots <- struct{}{}
+			err := e.runAction(a)
+			if err == nil && done != nil {
+				err = done(a)
+			}
+			<-slots
+
+			if err != nil {
+				mu.Lock()
+				failed = true
+				errs[i] = err
+				mu.Unlock()
+			}
+		}(i, a)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dependencies returns the indices of the actions each action has to wait
+// for.
+func dependencies(actions []Action) [][]int {
+	deps := make([][]int, len(actions))
+	producers := make(map[string]int)
+	barrier := -1
+	var sinceBarrier []int
+	for i, a := range actions {
+		d, ok := a.(Describer)
+		var spec Spec
+		if ok {
+			spec = d.Describe()
+		}
+		if len(spec.Outputs) == 0 {
+			if barrier >= 0 {
+				deps[i] = append(deps[i], barrier)
+			}
+			deps[i] = append(deps[i], sinceBarrier...)
+			barrier, sinceBarrier = i, nil
+			continue
+		}
+		if barrier >= 0 {
+			deps[i] = append(deps[i], barrier)
+		}
+		for _, in := range spec.Inputs {
+			if p, ok := producers[path.Clean(in)]; ok {
+				deps[i] = append(deps[i], p)
+			}
+		}
+		for _, out := range spec.Outputs {
+			producers[path.Clean(out)] = i
+		}
+		sinceBarrier = append(sinceBarrier, i)
+	}
+	return deps
+}
+
+func (e *Executor) runAction(a Action) error {
+	d, ok := a.(Describer)
+	if !ok || e.cache == nil {
+		return a.Do(e)
+	}
+	spec := d.Describe()
+	if !spec.Cache || len(spec.Outputs) == 0 {
+		return a.Do(e)
+	}
+	key, err := e.digest(spec)
+	if err != nil {
+		return errors.Wrap(err, "action digest")
+	}
+	if hit, err := e.cache.Get(key); err != nil {
+		e.Printf("cached outputs of %v are unusable: %v\n", spec.Outputs, err)
+	} else if hit {
+		e.Printf("cached %v\n", spec.Outputs)
+		return nil
+	}
+	if err := a.Do(e); err != nil {
+		return err
+	}
+	if err := e.cache.Put(key, spec.Outputs); err != nil {
+		e.Printf("caching %v failed: %v\n", spec.Outputs, err)
+	}
+	return nil
+}
+
+// digest returns the hash of what the action does and the contents of its
+// inputs.
+func (e *Executor) digest(spec Spec) (string, error) {
+	h := racy.NewHash()
+	io.WriteString(h, spec.Key)
+	inputs := append([]string{}, spec.Inputs...)
+	sort.Strings(inputs)
+	for _, in := range inputs {
+		fmt.Fprintf(h, "\x00%s\x00", in)
+		if err := e.hashFile(h, in); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// hashFile writes the contents of name to w, directories are hashed with
+// the names and contents of the files in them.
+func (e *Executor) hashFile(w io.Writer, name string) error {
+	f, err := e.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		_, err := io.Copy(w, f)
+		return err
+	}
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		fmt.Fprintf(w, "\x00%s\x00", n)
+		if err := e.hashFile(w, path.Join(name, n)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
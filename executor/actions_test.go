@@ -0,0 +1,154 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"bldy.build/build/namespace/host"
+)
+
+type testAction struct {
+	spec Spec
+	do   func(*Executor) error
+}
+
+func (a *testAction) Describe() Spec { return a.spec }
+func (a *testAction) Do(e *Executor) error {
+	if a.do == nil {
+		return nil
+	}
+	return a.do(e)
+}
+
+type opaqueAction struct{}
+
+func (opaqueAction) Do(*Executor) error { return nil }
+
+func TestDependencies(t *testing.T) {
+	actions := []Action{
+		&testAction{spec: Spec{Inputs: []string{"/src/a.c"}, Outputs: []string{"a.o"}}},
+		&testAction{spec: Spec{Inputs: []string{"/src/b.c"}, Outputs: []string{"b.o"}}},
+		&testAction{spec: Spec{Inputs: []string{"./a.o", "b.o"}, Outputs: []string{"lib.a"}}},
+		opaqueAction{},
+		&testAction{spec: Spec{Inputs: []string{"/src/c.c"}, Outputs: []string{"c.o"}}},
+	}
+	want := [][]int{nil, nil, {0, 1}, {0, 1, 2}, {3}}
+	if got := dependencies(actions); !reflect.DeepEqual(got, want) {
+		t.Logf("was expecting %v got %v instead", want, got)
+		t.Fail()
+	}
+}
+
+func TestRunActionsConcurrently(t *testing.T) {
+	e := New(context.Background(), nil)
+	e.SetSlots(make(chan struct{}, 2))
+
+	// both actions wait for each other to start, which only works if
+	// they run at the same time.
+	var started sync.WaitGroup
+	started.Add(2)
+	wait := func(*Executor) error {
+		started.Done()
+		done := make(chan struct{})
+		go func() { started.Wait(); close(done) }()
+		select {
+		case <-done:
+			return nil
+		case <-time.After(5 * time.Second):
+			return errors.New("timed out waiting for the other action")
+		}
+	}
+	var order []string
+	var mu sync.Mutex
+	link := func(*Executor) error {
+		mu.Lock()
+		order = append(order, "link")
+		mu.Unlock()
+		return nil
+	}
+	err := e.RunActions([]Action{
+		&testAction{spec: Spec{Outputs: []string{"a.o"}}, do: wait},
+		&testAction{spec: Spec{Outputs: []string{"b.o"}}, do: wait},
+		&testAction{spec: Spec{Inputs: []string{"a.o", "b.o"}, Outputs: []string{"out"}}, do: link},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(order) != 1 {
+		t.Log("was expecting the link action to run after the compile actions")
+		t.Fail()
+	}
+}
+
+func TestRunActionsFailure(t *testing.T) {
+	e := New(context.Background(), nil)
+	errBroken := errors.New("broken")
+	ran := false
+	err := e.RunActions([]Action{
+		&testAction{spec: Spec{Outputs: []string{"a.o"}}, do: func(*Executor) error { return errBroken }},
+		&testAction{spec: Spec{Inputs: []string{"a.o"}, Outputs: []string{"out"}}, do: func(*Executor) error { ran = true; return nil }},
+	}, nil)
+	if err != errBroken {
+		t.Logf("was expecting %v got %v instead", errBroken, err)
+		t.Fail()
+	}
+	if ran {
+		t.Log("was expecting actions depending on a failed action to be skipped")
+		t.Fail()
+	}
+}
+
+type mapCache map[string]bool
+
+func (c mapCache) Get(key string) (bool, error)           { return c[key], nil }
+func (c mapCache) Put(key string, outputs []string) error { c[key] = true; return nil }
+
+func TestRunActionsCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bldy_actions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "a.c")
+	ns, err := host.New(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := mapCache{}
+
+	runs := map[string]int{}
+	compile := func(name string) Action {
+		return &testAction{
+			spec: Spec{Inputs: []string{src}, Outputs: []string{name + ".o"}, Key: name, Cache: true},
+			do:   func(*Executor) error { runs[name]++; return nil },
+		}
+	}
+	build := func(content string) {
+		if err := ioutil.WriteFile(src, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		e := New(context.Background(), ns)
+		e.SetActionCache(c)
+		if err := e.RunActions([]Action{compile("a"), compile("b")}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	build("int a;")
+	build("int a;")
+	if runs["a"] != 1 || runs["b"] != 1 {
+		t.Logf("was expecting unchanged actions to be cached got %v runs instead", runs)
+		t.Fail()
+	}
+	build("int b;")
+	if runs["a"] != 2 {
+		t.Logf("was expecting a changed input to rerun the action got %d runs instead", runs["a"])
+		t.Fail()
+	}
+}
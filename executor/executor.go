@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"bldy.build/build/namespace"
@@ -33,8 +34,14 @@ type Action interface {
 type Executor struct {
 	ctx context.Context
 	ns  namespace.Namespace
+
+	// mu guards the logs, actions can run concurrently.
+	mu  sync.Mutex
 	run []*Run
 	log []fmt.Stringer
+
+	cache ActionCache
+	slots chan struct{}
 }
 
 // Context returns the context that's attached to the Executor
@@ -73,11 +80,15 @@ func (m Message) String() string {
 
 // RunCmds commands returns the commands that ran
 func (e *Executor) RunCmds() []*Run {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	return e.run
 }
 
 // Log returns the logs
 func (e *Executor) Log() []fmt.Stringer {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	return e.log
 }
 
@@ -91,12 +102,16 @@ func New(ctx context.Context, ns namespace.Namespace) *Executor {
 
 // Printf wraps sprintf for log items
 func (e *Executor) Printf(format string, v ...interface{}) {
+	e.mu.Lock()
 	e.log = append(e.log, Message(fmt.Sprintf(format, v...)))
+	e.mu.Unlock()
 }
 
 // Println wraps sprintf for log items
 func (e *Executor) Println(v ...interface{}) {
+	e.mu.Lock()
 	e.log = append(e.log, Message(fmt.Sprintln(v...)))
+	e.mu.Unlock()
 }
 
 func (e *Executor) CombinedLog() string {
@@ -108,13 +123,9 @@ func (e *Executor) CombinedLog() string {
 	return buf.String()
 }
 
-func (e *Executor) env() []string {
-	return os.Environ()
-}
-
 // Exec executes a command writing it's outputs to the context
 func (e *Executor) Exec(cmd string, env, args []string) error {
-	env = append(e.env(), env...)
+	x := e.ns.Cmd(e.ctx, env, cmd, args...)
 
 	run := Run{
 		At:   time.Now(),
@@ -123,12 +134,12 @@ func (e *Executor) Exec(cmd string, env, args []string) error {
 		Env:  env,
 	}
 
-	x := e.ns.Cmd(e.ctx, cmd, args...)
-
 	run.Output, run.Err = x.CombinedOutput()
 	envbuf := bytes.NewBufferString(strings.Join(env, "\n"))
+	e.mu.Lock()
 	e.run = append(e.run, &run)
 	e.log = append(e.log, &run)
+	e.mu.Unlock()
 	if run.Err != nil {
 		errbuf := bytes.NewBuffer(run.Output)
 		debug.Indent(errbuf, 2)
@@ -151,7 +162,7 @@ func (e *Executor) Exec(cmd string, env, args []string) error {
 
 // Run executes a command writing it's outputs to the namespace
 func (e *Executor) Run(ctx context.Context, cmd string, args ...string) namespace.Cmd {
-	return e.ns.Cmd(e.ctx, cmd, args...)
+	return e.ns.Cmd(e.ctx, nil, cmd, args...)
 
 }
 
@@ -174,3 +185,13 @@ func (e *Executor) Open(name string) (*os.File, error) {
 func (e *Executor) Mkdir(name string) error {
 	return e.ns.Mkdir(name)
 }
+
+// Symlink creates newname in the namespace as a symbolic link to oldname
+func (e *Executor) Symlink(oldname, newname string) error {
+	return e.ns.Symlink(oldname, newname)
+}
+
+// Rename moves oldname to newname in the namespace
+func (e *Executor) Rename(oldname, newname string) error {
+	return e.ns.Rename(oldname, newname)
+}
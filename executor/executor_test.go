@@ -0,0 +1,45 @@
+package executor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"bldy.build/build/namespace"
+	"bldy.build/build/namespace/host"
+)
+
+// envNamespace records the environments commands are run with.
+type envNamespace struct {
+	namespace.Namespace
+	envs [][]string
+}
+
+func (n *envNamespace) Cmd(ctx context.Context, env []string, cmd string, args ...string) namespace.Cmd {
+	n.envs = append(n.envs, env)
+	return n.Namespace.Cmd(ctx, env, cmd, args...)
+}
+
+func TestExecEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bldy_exec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ns, err := host.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := &envNamespace{Namespace: ns}
+	e := New(context.Background(), recorder)
+	env := []string{"BLDY_TEST=set"}
+	if err := e.Exec("/bin/sh", env, []string{"-c", `test "$BLDY_TEST" = set`}); err != nil {
+		t.Fatalf("was expecting the command to see the environment: %v", err)
+	}
+	if !reflect.DeepEqual(recorder.envs, [][]string{env}) {
+		t.Logf("was expecting the namespace to be given %v got %v instead", env, recorder.envs)
+		t.Fail()
+	}
+}
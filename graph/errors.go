@@ -0,0 +1,83 @@
+// Copyright 2018 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"bldy.build/build/label"
+)
+
+// Error is an error loading a target in to the graph.
+type Error struct {
+	Label label.Label
+	// Chain is the labels of the targets that led to Label, starting
+	// with the root that was asked for and ending with the target that
+	// depends on Label directly.
+	Chain []label.Label
+	// Pos is the BUILD file and line Label was declared on, or, if it
+	// couldn't be loaded, the one the target depending on it was declared on.
+	Pos string
+	Err error
+}
+
+func (e *Error) Error() string {
+	buf := bytes.NewBuffer(nil)
+	if e.Pos != "" {
+		fmt.Fprintf(buf, "%s: ", e.Pos)
+	}
+	fmt.Fprintf(buf, "%s: %v", e.Label, e.Err)
+	for i := len(e.Chain) - 1; i >= 0; i-- {
+		fmt.Fprintf(buf, "\n\tdepended on by %s", e.Chain[i])
+	}
+	return buf.String()
+}
+
+// Cause returns the underlying error, for github.com/pkg/errors.
+func (e *Error) Cause() error { return e.Err }
+
+// Errors is every error that was encountered while loading a graph.
+type Errors []*Error
+
+func (errs Errors) Error() string {
+	buf := bytes.NewBuffer(nil)
+	for i, err := range errs {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		buf.WriteString(err.Error())
+	}
+	if len(errs) > 1 {
+		fmt.Fprintf(buf, "\n%d targets failed to load", len(errs))
+	}
+	return buf.String()
+}
+
+// IncompatibleError is returned for targets that can't be built for the
+// platform of the graph.
+type IncompatibleError struct {
+	Platform label.Label
+	Err      error
+}
+
+func (e *IncompatibleError) Error() string {
+	return fmt.Sprintf("can't be built for %s: %v", e.Platform, e.Err)
+}
+
+// CycleError is returned for targets that depend on themselves.
+type CycleError struct {
+	// Cycle starts and ends with the same label.
+	Cycle []label.Label
+}
+
+func (e *CycleError) Error() string {
+	lbls := make([]string, len(e.Cycle))
+	for i, lbl := range e.Cycle {
+		lbls[i] = lbl.String()
+	}
+	return "dependency cycle: " + strings.Join(lbls, " -> ")
+}
@@ -0,0 +1,86 @@
+// Copyright 2018 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"fmt"
+
+	"bldy.build/build/racy"
+)
+
+// Explainer is implemented by rules that can list the inputs that went in
+// to their hash.
+type Explainer interface {
+	HashInputs() []racy.Input
+}
+
+// HashInputs returns the inputs of the nodes merkle hash, the inputs of the
+// rule itself are included if the rule implements Explainer.
+func (n *Node) HashInputs() []racy.Input {
+	inputs := []racy.Input{
+		{Name: "schema", Value: HashSchema},
+		{Name: "rule hash", Value: fmt.Sprintf("%x", n.Target.Hash())},
+	}
+	if e, ok := n.Target.(Explainer); ok {
+		inputs = append(inputs, e.HashInputs()...)
+	}
+	for _, c := range n.sortedChildren() {
+		inputs = append(inputs, racy.Input{
+			Name:  DepInput(c.Label.String()),
+			Value: fmt.Sprintf("%x", c.HashNode()),
+		})
+	}
+	return inputs
+}
+
+// DepInput is the name of the hash input that holds the hash of the dependency lbl.
+func DepInput(lbl string) string { return "dep " + lbl }
+
+// Change is the difference between the values recorded for an input.
+type Change struct {
+	Name     string
+	Old, New []string
+}
+
+// DiffInputs returns the inputs that are different between old and new.
+// Inputs are compared by name, if an input was recorded more than once the
+// list of its values is compared.
+func DiffInputs(old, new []racy.Input) []Change {
+	names := []string{}
+	oldVals := make(map[string][]string)
+	newVals := make(map[string][]string)
+	for _, in := range new {
+		if _, ok := newVals[in.Name]; !ok {
+			names = append(names, in.Name)
+		}
+		newVals[in.Name] = append(newVals[in.Name], in.Value)
+	}
+	for _, in := range old {
+		_, seen := newVals[in.Name]
+		if _, ok := oldVals[in.Name]; !ok && !seen {
+			names = append(names, in.Name)
+		}
+		oldVals[in.Name] = append(oldVals[in.Name], in.Value)
+	}
+	var changes []Change
+	for _, name := range names {
+		if !equal(oldVals[name], newVals[name]) {
+			changes = append(changes, Change{Name: name, Old: oldVals[name], New: newVals[name]})
+		}
+	}
+	return changes
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+package graph
+
+import (
+	"reflect"
+	"testing"
+
+	"bldy.build/build/racy"
+)
+
+func in(name, value string) racy.Input { return racy.Input{Name: name, Value: value} }
+
+func TestDiffInputs(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new []racy.Input
+		changes  []Change
+	}{
+		{
+			name: "unchanged",
+			old:  []racy.Input{in("attr srcs", `["a.c"]`), in("/ws/a.c", "01")},
+			new:  []racy.Input{in("attr srcs", `["a.c"]`), in("/ws/a.c", "01")},
+		},
+		{
+			name:    "file changed",
+			old:     []racy.Input{in("attr srcs", `["a.c"]`), in("/ws/a.c", "01")},
+			new:     []racy.Input{in("attr srcs", `["a.c"]`), in("/ws/a.c", "02")},
+			changes: []Change{{"/ws/a.c", []string{"01"}, []string{"02"}}},
+		},
+		{
+			name: "file added",
+			old:  []racy.Input{in("/ws/a.c", "01")},
+			new:  []racy.Input{in("/ws/a.c", "01"), in("/ws/b.c", "02")},
+			changes: []Change{
+				{"/ws/b.c", nil, []string{"02"}},
+			},
+		},
+		{
+			name: "dep removed",
+			old:  []racy.Input{in("dep //a:b", "01"), in("dep //a:c", "02")},
+			new:  []racy.Input{in("dep //a:b", "01")},
+			changes: []Change{
+				{"dep //a:c", []string{"02"}, nil},
+			},
+		},
+		{
+			name: "repeated names",
+			old:  []racy.Input{in("copts", "-O2"), in("copts", "-g")},
+			new:  []racy.Input{in("copts", "-g"), in("copts", "-O2")},
+			changes: []Change{
+				{"copts", []string{"-O2", "-g"}, []string{"-g", "-O2"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changes := DiffInputs(test.old, test.new)
+			if !reflect.DeepEqual(changes, test.changes) {
+				t.Logf("was expecting %v got %v instead", test.changes, changes)
+				t.Fail()
+			}
+		})
+	}
+}
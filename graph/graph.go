@@ -6,8 +6,9 @@
 package graph
 
 import (
-	"log"
-	"os"
+	"fmt"
+	"runtime"
+	"sync"
 
 	"bldy.build/build"
 	"bldy.build/build/label"
@@ -19,40 +20,189 @@ import (
 	"bldy.build/build/postprocessor"
 )
 
-var (
-	l = log.New(os.Stdout, "graph: ", 0)
-)
+// New returns a new build graph relatvie to the working directory, rooted at
+// every target matched by the target patterns. If any of the targets fail
+// to load the error is an Errors with every target that couldn't be loaded.
+func New(wd string, patterns ...string) (*Graph, error) {
+	g, err := Load(wd)
+	if err != nil {
+		return nil, errors.Wrap(err, "graph: new")
+	}
+	if err := g.AddRoots(patterns...); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
 
-// New returns a new build graph relatvie to the working directory
-func New(wd, target string) (*Graph, error) {
+// Load returns an empty build graph for the workspace wd is in, targets are
+// added to it as they are expanded.
+func Load(wd string) (*Graph, error) {
 	ws, err := workspace.New(wd)
 	if err != nil {
-		return nil, errors.Wrap(err, "graph: new")
+		return nil, errors.Wrap(err, "graph: load")
 	}
 	vm, err := skylark.New(ws)
 	if err != nil {
-		return nil, errors.Wrap(err, "graph: new")
+		return nil, errors.Wrap(err, "graph: load")
+	}
+	return &Graph{
+		wd:           wd,
+		ws:           ws,
+		vm:           vm,
+		config:       build.DefaultConfig(),
+		Nodes:        make(map[string]*Node),
+		broken:       make(map[string]bool),
+		incompatible: make(map[string]bool),
+	}, nil
+}
+
+// SetConfig sets the configuration the targets are built in and the
+// select()s of the targets are resolved with, it has to be set before any
+// targets are added. The constraints of the configuration are those of its
+// platform, unless it's the host.
+func (g *Graph) SetConfig(c *build.Config) error {
+	if c.Platform != "" && c.Platform != build.HostPlatform {
+		t, err := g.vm.GetTarget(c.Platform)
+		if err != nil {
+			return errors.Wrap(err, "graph: platform")
+		}
+		p, ok := t.(build.Platform)
+		if !ok {
+			return fmt.Errorf("graph: %s isn't a platform", c.Platform)
+		}
+		c.Constraints = p.ConstraintValues()
 	}
-	g := Graph{
-		ws:    ws,
-		vm:    vm,
-		Nodes: make(map[string]*Node),
+	g.config = c
+	return nil
+}
+
+// AddRoots expands the patterns and adds the targets they match to the
+// roots of the graph. Targets that load are added even if others fail, so
+// the caller can keep going with what's there if it wants to.
+func (g *Graph) AddRoots(patterns ...string) error {
+	roots, err := g.Expand(patterns...)
+	for _, root := range roots {
+		root.IsRoot = true
+		g.Roots = append(g.Roots, root)
 	}
-	label, err := label.Parse(target)
+	if len(g.Roots) > 0 {
+		g.Root = g.Roots[0]
+	}
+	if err != nil {
+		return err
+	}
+	if len(g.Roots) == 0 {
+		return fmt.Errorf("graph: new: no targets match %q", patterns)
+	}
+	return nil
+}
+
+// Expand adds the targets matched by patterns, and their dependencies, to
+// the graph and returns the nodes of the matched targets sorted by label.
+// Targets that fail to load, and targets that depend on them, are left
+// out and the error returned is an Errors with every one of them. Targets
+// that are matched by wildcards and can't be built for the platform of the
+// graph, or depend on targets that can't, are skipped.
+func (g *Graph) Expand(patterns ...string) ([]*Node, error) {
+	g.errs = nil
+	lbls, wildcard, err := g.expand(g.wd, patterns)
 	if err != nil {
-		return nil, errors.Wrap(err, "new graph")
+		return nil, err
+	}
+	g.prefetch(lbls)
+	nodes := []*Node{}
+	failed := []label.Label{}
+	skipped := false
+	for _, lbl := range lbls {
+		if n, ok := g.getTarget(lbl, nil); ok {
+			nodes = append(nodes, n)
+		} else if wildcard[lbl] && g.incompatible[lbl.String()] {
+			skipped = true
+		} else {
+			failed = append(failed, lbl)
+		}
 	}
-	g.Root = g.getTarget(label)
-	g.Root.IsRoot = true
-	return &g, nil
+	if skipped {
+		// the errors of the skipped targets may be the only explanation
+		// of why the others failed, so they are loaded again to report
+		// them with their own chains.
+		g.forgetIncompatible()
+		for _, lbl := range failed {
+			g.getTarget(lbl, nil)
+		}
+	}
+	if len(g.errs) > 0 {
+		return nodes, g.errs
+	}
+	return nodes, nil
+}
+
+// prefetch loads lbls and everything they depend on concurrently, so the
+// packages they are in are already evaluated when getTarget asks for them.
+// Errors are ignored here, getTarget runs in to them again and reports them
+// along with how it got there.
+func (g *Graph) prefetch(lbls []label.Label) {
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		seen = make(map[label.Label]bool)
+		sem  = make(chan struct{}, runtime.NumCPU())
+	)
+	var fetch func(lbl label.Label)
+	fetch = func(lbl label.Label) {
+		defer wg.Done()
+		sem <- struct{}{}
+		t, err := g.vm.GetTarget(lbl)
+		<-sem
+		if err != nil {
+			return
+		}
+		for _, d := range t.Dependencies() {
+			mu.Lock()
+			if !seen[d] {
+				seen[d] = true
+				wg.Add(1)
+				go fetch(d)
+			}
+			mu.Unlock()
+		}
+	}
+	mu.Lock()
+	for _, lbl := range lbls {
+		if _, ok := g.Nodes[lbl.String()]; ok || seen[lbl] {
+			continue
+		}
+		seen[lbl] = true
+		wg.Add(1)
+		go fetch(lbl)
+	}
+	mu.Unlock()
+	wg.Wait()
 }
 
 // Graph represents a build graph
 type Graph struct {
+	// Root is the first of the Roots, it's here for the convenience of
+	// tools that only ever ask for a single target.
 	Root  *Node
+	Roots []*Node
+	wd    string
 	vm    build.VM
 	ws    workspace.Workspace
 	Nodes map[string]*Node
+
+	config *build.Config
+	// toolchains are the registered toolchains, they are loaded the first
+	// time a target needs a toolchain.
+	toolchains []build.Toolchain
+
+	// broken are the targets that failed to load, errs are the errors
+	// they failed with during the current expansion. incompatible are the
+	// broken targets that failed only because they, or their dependencies,
+	// can't be built for the platform of the graph.
+	broken       map[string]bool
+	incompatible map[string]bool
+	errs         Errors
 }
 
 // Workspace returns the Workspace in which this graph exists.
@@ -60,25 +210,128 @@ func (g *Graph) Workspace() workspace.Workspace {
 	return g.ws
 }
 
-func (g *Graph) getTarget(lbl label.Label) (n *Node) {
+// forgetIncompatible forgets the targets that failed to load only because
+// they, or their dependencies, can't be built for the platform of the
+// graph, along with their errors.
+func (g *Graph) forgetIncompatible() {
+	errs := Errors{}
+	for _, err := range g.errs {
+		if !g.incompatible[err.Label.String()] {
+			errs = append(errs, err)
+		}
+	}
+	g.errs = errs
+	for lbl := range g.incompatible {
+		delete(g.broken, lbl)
+	}
+	g.incompatible = make(map[string]bool)
+}
+
+// fail records that lbl couldn't be loaded.
+func (g *Graph) fail(lbl label.Label, chain []label.Label, pos string, err error) (*Node, bool) {
+	if _, ok := err.(*IncompatibleError); ok {
+		g.incompatible[lbl.String()] = true
+	}
+	g.broken[lbl.String()] = true
+	g.errs = append(g.errs, &Error{Label: lbl, Chain: chain, Pos: pos, Err: err})
+	return nil, false
+}
+
+// getTarget loads lbl and its dependencies in to the graph, chain is the
+// labels of the targets that led to it. Targets that fail to load are
+// recorded in the graphs errors and getTarget returns false for them and
+// every target that depends on them.
+func (g *Graph) getTarget(lbl label.Label, chain []label.Label) (*Node, bool) {
 	if gnode, ok := g.Nodes[lbl.String()]; ok {
-		return gnode
+		return gnode, true
+	}
+	if g.broken[lbl.String()] {
+		return nil, false
+	}
+	for i, c := range chain {
+		if c == lbl {
+			cycle := append(chain[i:len(chain):len(chain)], lbl)
+			return g.fail(lbl, chain[:i], g.vm.Position(lbl), &CycleError{cycle})
+		}
 	}
 
 	t, err := g.vm.GetTarget(lbl)
 	if err != nil {
-		l.Fatal(err)
+		pos := ""
+		if len(chain) > 0 {
+			pos = g.vm.Position(chain[len(chain)-1])
+		}
+		return g.fail(lbl, chain, pos, err)
+	}
+	pos := g.vm.Position(lbl)
+	if t.Name() != lbl.Name() {
+		return g.fail(lbl, chain, pos, fmt.Errorf("target name %q and url target %q don't match", t.Name(), lbl.Name()))
+	}
+
+	if c, ok := t.(build.Configurable); ok {
+		if err := g.configure(c); err != nil {
+			return g.fail(lbl, chain, pos, errors.Wrap(err, "configuration"))
+		}
+	}
+
+	if r, ok := t.(build.Restricted); ok {
+		if err := r.CompatibleWith(g.config); err != nil {
+			return g.fail(lbl, chain, pos, &IncompatibleError{Platform: g.config.Platform, Err: err})
+		}
+	}
+
+	if u, ok := t.(build.ToolchainUser); ok {
+		if err := g.resolveToolchains(u); err != nil {
+			return g.fail(lbl, chain, pos, errors.Wrap(err, "toolchain resolution"))
+		}
 	}
 
 	nLbl := label.New(lbl.Package(), t.Name())
 
 	node := NewNode(nLbl, t)
+	node.Config = g.config
 
 	post := postprocessor.New(g.ws, nLbl)
 
-	err = post.ProcessDependencies(node.Target)
-	if err != nil {
-		l.Fatal(err)
+	if err := post.ProcessDependencies(node.Target); err != nil {
+		return g.fail(lbl, chain, pos, err)
+	}
+
+	// the chain is copied so siblings don't overwrite each others chains
+	chain = append(chain[:len(chain):len(chain)], nLbl)
+	children := make(map[string]*Node)
+	ok, incompatible := true, true
+	for _, d := range node.Target.Dependencies() {
+		c, loaded := g.getTarget(d, chain)
+		if !loaded {
+			// keep going so every broken dependency is reported
+			ok = false
+			incompatible = incompatible && g.incompatible[d.String()]
+			continue
+		}
+		children[d.String()] = c
+	}
+	if !ok {
+		// the errors of the dependencies explain why
+		g.broken[lbl.String()] = true
+		g.incompatible[lbl.String()] = incompatible
+		return nil, false
+	}
+
+	for _, d := range node.Target.Dependencies() {
+		if err := g.checkVisibility(nLbl, d, children[d.String()].Target); err != nil {
+			return g.fail(lbl, chain[:len(chain)-1], pos, err)
+		}
+	}
+
+	if a, ok := t.(build.Analyzer); ok {
+		analyzed := make(map[label.Label]build.Rule)
+		for _, d := range t.Dependencies() {
+			analyzed[d] = children[d.String()].Target
+		}
+		if err := a.Analyze(analyzed); err != nil {
+			return g.fail(lbl, chain[:len(chain)-1], pos, errors.Wrap(err, "analysis"))
+		}
 	}
 
 	var deps []build.Rule
@@ -93,32 +346,96 @@ func (g *Graph) getTarget(lbl label.Label) (n *Node) {
 	}
 
 	for _, d := range node.Target.Dependencies() {
-		c := g.getTarget(d)
-		if err != nil {
-			l.Printf("%q is not a valid label", d.String())
-			continue
-		}
-		node.WG.Add(1)
+		c := children[d.String()]
 		if group != nil {
 			for _, output := range c.Target.Outputs() {
 				group.AddOutput(output)
 			}
 		}
 		deps = append(deps, c.Target)
-
-		node.Children[d.String()] = c
-		c.Parents[nLbl.String()] = &node
 	}
 
 	if err := post.ProcessPaths(t, deps); err != nil {
-		l.Fatalf("path processing: %s", err.Error())
+		return g.fail(lbl, chain[:len(chain)-1], pos, errors.Wrap(err, "path processing"))
+	}
+
+	for d, c := range children {
+		node.WG.Add(1)
+		node.Children[d] = c
+		c.Parents[nLbl.String()] = &node
 	}
 
 	g.Nodes[nLbl.String()] = &node
-	if t.Name() == lbl.Name() {
-		n = &node
-	} else {
-		l.Fatalf("target name %q and url target %q don't match", t.Name(), lbl.Name())
+	return &node, true
+}
+
+// configure resolves the select()s of c with the conditions they depend on.
+func (g *Graph) configure(c build.Configurable) error {
+	matches := make(map[label.Label]bool)
+	for _, cond := range c.Conditions() {
+		t, err := g.vm.GetTarget(cond)
+		if err != nil {
+			return err
+		}
+		m, ok := t.(build.Condition)
+		if !ok {
+			return fmt.Errorf("%s can't be used as a condition", cond)
+		}
+		matches[cond] = m.Matches(g.config)
+	}
+	return c.Configure(g.config, matches)
+}
+
+// resolveToolchains picks the first registered toolchain of every type u
+// needs that runs on the host and builds for the platform of the graph.
+func (g *Graph) resolveToolchains(u build.ToolchainUser) error {
+	types := u.ToolchainTypes()
+	if len(types) == 0 {
+		return nil
+	}
+	toolchains, err := g.registeredToolchains()
+	if err != nil {
+		return err
+	}
+	resolved := make(map[label.Label]label.Label)
+	for _, typ := range types {
+		for _, tc := range toolchains {
+			if tc.ToolchainType() == typ && tc.Matches(build.HostConstraints, g.config.Constraints) {
+				resolved[typ] = tc.Toolchain()
+				break
+			}
+		}
+		if _, ok := resolved[typ]; !ok {
+			return fmt.Errorf("none of the registered toolchains of type %s can build for %v", typ, g.config.Constraints)
+		}
+	}
+	return u.SetToolchains(resolved)
+}
+
+func (g *Graph) registeredToolchains() ([]build.Toolchain, error) {
+	if g.toolchains != nil {
+		return g.toolchains, nil
+	}
+	r, ok := g.vm.(build.ToolchainRegistry)
+	if !ok {
+		return nil, fmt.Errorf("%T doesn't know of any toolchains", g.vm)
+	}
+	lbls, err := r.RegisteredToolchains()
+	if err != nil {
+		return nil, err
+	}
+	toolchains := []build.Toolchain{}
+	for _, lbl := range lbls {
+		t, err := g.vm.GetTarget(lbl)
+		if err != nil {
+			return nil, err
+		}
+		tc, ok := t.(build.Toolchain)
+		if !ok {
+			return nil, fmt.Errorf("%s is registered as a toolchain but it isn't one", lbl)
+		}
+		toolchains = append(toolchains, tc)
 	}
-	return n
+	g.toolchains = toolchains
+	return toolchains, nil
 }
@@ -1,7 +1,476 @@
 package graph
 
-import "testing"
+import (
+	"fmt"
+	"strings"
+	"testing"
 
-func TestNew(t *testing.T) {
+	"bldy.build/build"
+	"bldy.build/build/label"
+)
 
+type testWorkspace struct{}
+
+func (testWorkspace) AbsPath() string                           { return "/ws" }
+func (testWorkspace) Buildfile(l label.Label) string            { return "/ws/" + l.Package() + "/BUILD" }
+func (testWorkspace) File(l label.Label) string                 { return "/ws/" + l.Package() + "/" + l.Name() }
+func (testWorkspace) PackageDir(l label.Label) string           { return "/ws/" + l.Package() }
+func (testWorkspace) LoadBuildfile(label.Label) ([]byte, error) { return nil, nil }
+
+// testVM declares a target for every key in it, with the values as its
+// dependencies.
+type testVM map[string][]string
+
+func (vm testVM) GetTarget(l label.Label) (build.Rule, error) {
+	deps, ok := vm[l.String()]
+	if !ok {
+		return nil, fmt.Errorf("couldn't find %s", l)
+	}
+	r := &testRule{name: l.Name()}
+	for _, d := range deps {
+		r.deps = append(r.deps, label.Label(d))
+	}
+	return r, nil
+}
+
+func (vm testVM) Targets(pkg string) ([]label.Label, error) {
+	var lbls []label.Label
+	for k := range vm {
+		if l := label.Label(k); l.Package() == pkg {
+			lbls = append(lbls, l)
+		}
+	}
+	return lbls, nil
+}
+
+func (vm testVM) Position(l label.Label) string {
+	if _, ok := vm[l.String()]; !ok {
+		return ""
+	}
+	return fmt.Sprintf("/ws/%s/BUILD:1", l.Package())
+}
+
+func testGraph(vm testVM) *Graph {
+	return &Graph{
+		wd:           "/ws",
+		ws:           testWorkspace{},
+		vm:           vm,
+		config:       build.DefaultConfig(),
+		Nodes:        make(map[string]*Node),
+		broken:       make(map[string]bool),
+		incompatible: make(map[string]bool),
+	}
+}
+
+func TestAddRoots(t *testing.T) {
+	g := testGraph(testVM{
+		"//app:bin":  {"//lib:a"},
+		"//lib:a":    {"//lib:b"},
+		"//lib:b":    nil,
+		"//app:tool": {"//lib:b"},
+	})
+	if err := g.AddRoots("//app:all"); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Roots) != 2 || g.Root.Label != "//app:bin" {
+		t.Logf("was expecting //app:bin and //app:tool as roots got %v instead", g.Roots)
+		t.Fail()
+	}
+	if b := g.Nodes["//lib:b"]; b == nil || len(b.Parents) != 2 {
+		t.Log("//lib:b should be shared by //lib:a and //app:tool")
+		t.Fail()
+	}
+}
+
+func TestAddRootsErrors(t *testing.T) {
+	g := testGraph(testVM{
+		"//app:bin":   {"//lib:a", "//lib:missing"},
+		"//app:other": {"//nope:x"},
+		"//app:fine":  nil,
+		"//lib:a":     {"//lib:b"},
+	})
+	err := g.AddRoots("//app:all")
+	errs, ok := err.(Errors)
+	if !ok {
+		t.Fatalf("was expecting Errors got %v instead", err)
+	}
+	broken := map[string]string{}
+	for _, e := range errs {
+		broken[e.Label.String()] = e.Error()
+	}
+	want := map[string][]string{
+		"//lib:b":       {"/ws/lib/BUILD:1: //lib:b:", "depended on by //lib:a\n\tdepended on by //app:bin"},
+		"//lib:missing": {"/ws/app/BUILD:1: //lib:missing:", "depended on by //app:bin"},
+		"//nope:x":      {"depended on by //app:other"},
+	}
+	if len(broken) != len(want) {
+		t.Logf("was expecting %d errors got %d instead:\n%v", len(want), len(broken), err)
+		t.Fail()
+	}
+	for lbl, parts := range want {
+		for _, part := range parts {
+			if !strings.Contains(broken[lbl], part) {
+				t.Logf("was expecting %q in the error for %s got %q instead", part, lbl, broken[lbl])
+				t.Fail()
+			}
+		}
+	}
+	if len(g.Roots) != 1 || g.Root.Label != "//app:fine" {
+		t.Logf("only //app:fine should have loaded, got %v", g.Roots)
+		t.Fail()
+	}
+	if _, ok := g.Nodes["//lib:a"]; ok {
+		t.Log("//lib:a depends on a broken target, it shouldn't be in the graph")
+		t.Fail()
+	}
+}
+
+func TestCycle(t *testing.T) {
+	tests := []struct {
+		name  string
+		vm    testVM
+		cycle string
+	}{
+		{
+			name: "self",
+			vm: testVM{
+				"//a:x": {"//a:x"},
+			},
+			cycle: "//a:x -> //a:x",
+		},
+		{
+			name: "indirect",
+			vm: testVM{
+				"//app:bin": {"//a:x"},
+				"//a:x":     {"//b:y"},
+				"//b:y":     {"//c:z"},
+				"//c:z":     {"//a:x"},
+			},
+			cycle: "//a:x -> //b:y -> //c:z -> //a:x",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := testGraph(test.vm)
+			roots := []string{}
+			for lbl := range test.vm {
+				roots = append(roots, lbl)
+			}
+			err := g.AddRoots(roots...)
+			errs, ok := err.(Errors)
+			if !ok || len(errs) != 1 {
+				t.Fatalf("was expecting a single cycle error got %v instead", err)
+			}
+			cerr, ok := errs[0].Err.(*CycleError)
+			if !ok {
+				t.Fatalf("was expecting a CycleError got %v instead", errs[0].Err)
+			}
+			if !strings.HasSuffix(cerr.Error(), test.cycle) {
+				t.Logf("was expecting %q got %q instead", test.cycle, cerr)
+				t.Fail()
+			}
+			if len(g.Roots) != 0 {
+				t.Logf("targets in a cycle shouldn't be built, got %v", g.Roots)
+				t.Fail()
+			}
+		})
+	}
+}
+
+// selectRule depends on //lib:debug when //cond:debug matches.
+type selectRule struct{ testRule }
+
+func (r *selectRule) Conditions() []label.Label { return []label.Label{"//cond:debug"} }
+func (r *selectRule) Configure(_ *build.Config, matches map[label.Label]bool) error {
+	r.deps = nil
+	if matches["//cond:debug"] {
+		r.deps = []label.Label{"//lib:debug"}
+	}
+	return nil
+}
+
+type debugCondition struct{ testRule }
+
+func (debugCondition) Matches(c *build.Config) bool { return c.Defines["mode"] == "debug" }
+
+type selectVM struct{ testVM }
+
+func (vm selectVM) GetTarget(l label.Label) (build.Rule, error) {
+	switch l {
+	case "//app:bin":
+		return &selectRule{testRule{name: "bin"}}, nil
+	case "//cond:debug":
+		return &debugCondition{testRule{name: "debug"}}, nil
+	}
+	return vm.testVM.GetTarget(l)
+}
+
+func TestConfigure(t *testing.T) {
+	for _, mode := range []string{"debug", "release"} {
+		g := testGraph(nil)
+		g.vm = selectVM{testVM{"//lib:debug": nil}}
+		g.SetConfig(&build.Config{Defines: map[string]string{"mode": mode}})
+		if err := g.AddRoots("//app:bin"); err != nil {
+			t.Fatal(err)
+		}
+		_, ok := g.Nodes["//lib:debug"]
+		if want := mode == "debug"; ok != want {
+			t.Logf("was expecting //lib:debug to be in the graph to be %v in %s mode", want, mode)
+			t.Fail()
+		}
+	}
+}
+
+// ccRule needs a toolchain of type //tc:cc.
+type ccRule struct{ testRule }
+
+func (r *ccRule) ToolchainTypes() []label.Label { return []label.Label{"//tc:cc"} }
+func (r *ccRule) SetToolchains(toolchains map[label.Label]label.Label) error {
+	r.deps = append(r.deps, toolchains["//tc:cc"])
+	return nil
+}
+
+type testToolchain struct {
+	testRule
+	impl   label.Label
+	target label.Label
+}
+
+func (t *testToolchain) ToolchainType() label.Label { return "//tc:cc" }
+func (t *testToolchain) Toolchain() label.Label     { return t.impl }
+func (t *testToolchain) Matches(exec, target []label.Label) bool {
+	for _, c := range target {
+		if c == t.target {
+			return true
+		}
+	}
+	return false
+}
+
+type testPlatform struct {
+	testRule
+	constraints []label.Label
+}
+
+func (p *testPlatform) ConstraintValues() []label.Label { return p.constraints }
+
+type toolchainVM struct{ testVM }
+
+func (vm toolchainVM) GetTarget(l label.Label) (build.Rule, error) {
+	switch l {
+	case "//app:bin":
+		return &ccRule{testRule{name: "bin"}}, nil
+	case "//tc:gcc":
+		return &testToolchain{testRule{name: "gcc"}, "//tc:gcc_impl", build.HostConstraints[0]}, nil
+	case "//tc:9c":
+		return &testToolchain{testRule{name: "9c"}, "//tc:9c_impl", "//os:plan9"}, nil
+	case "//platforms:p9":
+		return &testPlatform{testRule{name: "p9"}, []label.Label{"//os:plan9"}}, nil
+	}
+	return vm.testVM.GetTarget(l)
+}
+
+func (vm toolchainVM) RegisteredToolchains() ([]label.Label, error) {
+	return []label.Label{"//tc:9c", "//tc:gcc"}, nil
+}
+
+func TestToolchainResolution(t *testing.T) {
+	tests := []struct {
+		platform label.Label
+		impl     string
+		err      string
+	}{
+		{platform: build.HostPlatform, impl: "//tc:gcc_impl"},
+		{platform: "//platforms:p9", impl: "//tc:9c_impl"},
+		{platform: "//lib:a", err: "graph: //lib:a isn't a platform"},
+	}
+	for _, test := range tests {
+		t.Run(test.platform.String(), func(t *testing.T) {
+			g := testGraph(nil)
+			g.vm = toolchainVM{testVM{"//tc:gcc_impl": nil, "//tc:9c_impl": nil, "//lib:a": nil}}
+			config := build.DefaultConfig()
+			config.Platform = test.platform
+			if err := g.SetConfig(config); err != nil {
+				if err.Error() != test.err {
+					t.Logf("was expecting %q got %q instead", test.err, err)
+					t.Fail()
+				}
+				return
+			}
+			if err := g.AddRoots("//app:bin"); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := g.Nodes["//app:bin"].Children[test.impl]; !ok {
+				t.Logf("was expecting //app:bin to depend on %s", test.impl)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestToolchainResolutionFails(t *testing.T) {
+	g := testGraph(nil)
+	g.vm = toolchainVM{testVM{}}
+	g.SetConfig(&build.Config{Constraints: []label.Label{"//os:linux"}})
+	err := g.AddRoots("//app:bin")
+	if err == nil || !strings.Contains(err.Error(), "none of the registered toolchains of type //tc:cc can build for [//os:linux]") {
+		t.Logf("was expecting toolchain resolution to fail got %v instead", err)
+		t.Fail()
+	}
+}
+
+// linuxRule can only be built for //os:linux.
+type linuxRule struct{ testRule }
+
+func (r *linuxRule) CompatibleWith(c *build.Config) error {
+	for _, lbl := range c.Constraints {
+		if lbl == "//os:linux" {
+			return nil
+		}
+	}
+	return fmt.Errorf("it's only compatible with //os:linux")
+}
+
+type restrictedVM struct{ testVM }
+
+func (vm restrictedVM) GetTarget(l label.Label) (build.Rule, error) {
+	if l == "//linux:only" {
+		return &linuxRule{testRule{name: "only"}}, nil
+	}
+	return vm.testVM.GetTarget(l)
+}
+
+func TestIncompatible(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		deps     map[string][]string
+		roots    int
+		err      string
+	}{
+		{name: "wildcard", patterns: []string{"//linux:all"}, roots: 0},
+		{name: "all", patterns: []string{"//linux:all", "//app:all"}, roots: 1},
+		{name: "label", patterns: []string{"//linux:only"}, err: "//linux:only: can't be built for //platforms:p9: it's only compatible with //os:linux"},
+		{
+			name:     "dependency",
+			patterns: []string{"//app:all"},
+			deps:     map[string][]string{"//app:uses": {"//linux:only"}},
+			roots:    1,
+		},
+		{
+			name:     "transitive dependency",
+			patterns: []string{"//app:all"},
+			deps:     map[string][]string{"//app:uses": {"//app:lib"}, "//app:lib": {"//linux:only"}},
+			roots:    1,
+		},
+		{
+			name:     "label dependency",
+			patterns: []string{"//app:uses"},
+			deps:     map[string][]string{"//app:uses": {"//linux:only"}},
+			err:      "can't be built for //platforms:p9: it's only compatible with //os:linux\n\tdepended on by //app:uses",
+		},
+		{
+			name:     "wildcard and label dependency",
+			patterns: []string{"//app:all", "//cmd:uses"},
+			deps:     map[string][]string{"//app:uses": {"//linux:only"}, "//cmd:uses": {"//linux:only"}},
+			err:      "can't be built for //platforms:p9: it's only compatible with //os:linux\n\tdepended on by //cmd:uses",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vm := testVM{"//linux:only": nil, "//app:bin": nil}
+			for lbl, deps := range test.deps {
+				vm[lbl] = deps
+			}
+			g := testGraph(nil)
+			g.vm = restrictedVM{vm}
+			g.config = &build.Config{Platform: "//platforms:p9", Constraints: []label.Label{"//os:plan9"}}
+			roots, err := g.Expand(test.patterns...)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Logf("was expecting %q got %v instead", test.err, err)
+					t.Fail()
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(roots) != test.roots {
+				t.Logf("was expecting %d roots got %v instead", test.roots, roots)
+				t.Fail()
+			}
+		})
+	}
+}
+
+type visibleRule struct {
+	testRule
+	visibility []label.Label
+}
+
+func (r *visibleRule) Visibility() []label.Label { return r.visibility }
+
+type friends struct{ testRule }
+
+func (friends) Contains(pkg string) bool { return pkg == "friend" }
+func (friends) Includes() []label.Label  { return nil }
+
+type visibilityVM struct {
+	testVM
+	visibility []label.Label
+}
+
+func (vm visibilityVM) GetTarget(l label.Label) (build.Rule, error) {
+	switch l {
+	case "//lib:a", "//lib:b":
+		r := &visibleRule{testRule{name: l.Name()}, vm.visibility}
+		if l == "//lib:a" {
+			r.deps = []label.Label{"//lib:b"}
+		}
+		return r, nil
+	case "//groups:friends":
+		return &friends{testRule{name: "friends"}}, nil
+	}
+	return vm.testVM.GetTarget(l)
+}
+
+func TestVisibility(t *testing.T) {
+	tests := []struct {
+		name       string
+		root       string
+		visibility []label.Label
+		err        string
+	}{
+		{name: "private", root: "//app:bin", visibility: []label.Label{build.PrivateVisibility}, err: "//app:bin: //lib:a isn't visible to it, it's only visible to [//visibility:private]"},
+		{name: "same package", root: "//lib:a", visibility: []label.Label{build.PrivateVisibility}},
+		{name: "public", root: "//app:bin", visibility: []label.Label{build.PublicVisibility}},
+		{name: "pkg", root: "//app:bin", visibility: []label.Label{"//app:__pkg__"}},
+		{name: "other pkg", root: "//app/sub:bin", visibility: []label.Label{"//app:__pkg__"}, err: "isn't visible to it"},
+		{name: "subpackages", root: "//app/sub:bin", visibility: []label.Label{"//app:__subpackages__"}},
+		{name: "package group", root: "//friend:bin", visibility: []label.Label{"//groups:friends"}},
+		{name: "not in group", root: "//app:bin", visibility: []label.Label{"//groups:friends"}, err: "isn't visible to it"},
+		{name: "not a group", root: "//app:bin", visibility: []label.Label{"//lib:b"}, err: "visibility of //lib:a: //lib:b isn't a package group"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := testGraph(nil)
+			g.vm = visibilityVM{testVM{
+				"//app:bin":     {"//lib:a"},
+				"//app/sub:bin": {"//lib:a"},
+				"//friend:bin":  {"//lib:a"},
+			}, test.visibility}
+			err := g.AddRoots(test.root)
+			if test.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Logf("was expecting %q got %v instead", test.err, err)
+				t.Fail()
+			}
+		})
+	}
 }
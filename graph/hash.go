@@ -5,38 +5,79 @@
 package graph
 
 import (
+	"encoding/binary"
 	"fmt"
+	"hash"
 	"sort"
-	"strings"
 
 	"bldy.build/build/racy"
 )
 
-// HashNode calculates the hash of a node
+// HashSchema identifies the way node hashes are computed. It is the first
+// thing that goes in to every node hash, so it has to be bumped whenever
+// the encoding below changes. That way hashes computed by different
+// versions of bldy can never be mistaken for each other.
+const HashSchema = "bldy.node.v2"
+
+// HashNode calculates the merkle hash of a node.
+//
+// The hash of a node is racy.NewHash over
+//
+//	schema, configuration, rule hash, number of children,
+//	label of child 1, hash of child 1,
+//	...
+//	label of child n, hash of child n
+//
+// where children are sorted by their labels and every field is prefixed
+// with its length. Because children are ordered and keyed by label,
+// swapping two dependencies or depending on two targets that happen to
+// hash the same yields a different hash. Nodes that aren't in a graph
+// hash their configuration as empty.
 func (n *Node) HashNode() []byte {
 	// node hashes should not change after a build,
 	// they should be deterministic, therefore they can and should be cached.
 	if len(n.hash) > 0 {
 		return n.hash
 	}
-	n.hash = n.Target.Hash()
-	var bn ByName
-	for _, e := range n.Children {
-		bn = append(bn, e)
+	h := racy.NewHash()
+	writeField(h, []byte(HashSchema))
+	config := ""
+	if n.Config != nil {
+		config = n.Config.String()
 	}
-	sort.Sort(bn)
-	for _, e := range bn {
-		n.hash = racy.XOR(e.HashNode(), n.hash)
+	writeField(h, []byte(config))
+	writeField(h, n.Target.Hash())
+
+	children := n.sortedChildren()
+	binary.Write(h, binary.BigEndian, uint64(len(children)))
+	for _, c := range children {
+		writeField(h, []byte(c.Label.String()))
+		writeField(h, c.HashNode())
 	}
+	n.hash = h.Sum(nil)
 	n.Hash = fmt.Sprintf("%x", n.hash)
 	return n.hash
 }
 
-// ByName sorts dependencies by name so we can have reproduceable builds.
-type ByName []*Node
+func (n *Node) sortedChildren() []*Node {
+	var children ByLabel
+	for _, c := range n.Children {
+		children = append(children, c)
+	}
+	sort.Sort(children)
+	return children
+}
 
-func (a ByName) Len() int      { return len(a) }
-func (a ByName) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
-func (a ByName) Less(i, j int) bool {
-	return strings.Compare(a[i].Target.Name(), a[j].Target.Name()) > 0
+// writeField writes b to h prefixed with its length, so the boundaries
+// between fields are unambiguous.
+func writeField(h hash.Hash, b []byte) {
+	binary.Write(h, binary.BigEndian, uint64(len(b)))
+	h.Write(b)
 }
+
+// ByLabel sorts dependencies by label so we can have reproduceable builds.
+type ByLabel []*Node
+
+func (a ByLabel) Len() int           { return len(a) }
+func (a ByLabel) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByLabel) Less(i, j int) bool { return a[i].Label < a[j].Label }
@@ -0,0 +1,98 @@
+package graph
+
+import (
+	"bytes"
+	"testing"
+
+	"bldy.build/build"
+	"bldy.build/build/executor"
+	"bldy.build/build/label"
+	"bldy.build/build/workspace"
+)
+
+type testRule struct {
+	name string
+	hash string
+	deps []label.Label
+}
+
+func (t *testRule) Name() string                   { return t.name }
+func (t *testRule) Dependencies() []label.Label    { return t.deps }
+func (t *testRule) Outputs() []string              { return nil }
+func (t *testRule) Hash() []byte                   { return []byte(t.hash) }
+func (t *testRule) Build(*executor.Executor) error { return nil }
+func (t *testRule) Platform() label.Label          { return build.DefaultPlatform }
+func (t *testRule) Workspace() workspace.Workspace { return nil }
+
+func testNode(name, hash string, children ...*Node) *Node {
+	lbl := label.New("test", name)
+	n := NewNode(lbl, &testRule{name: name, hash: hash})
+	for _, c := range children {
+		n.Children[c.Label.String()] = c
+	}
+	return &n
+}
+
+func TestHashNode(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b *Node
+	}{
+		{
+			name: "swapped deps",
+			a:    testNode("root", "r", testNode("x", "1"), testNode("y", "2")),
+			b:    testNode("root", "r", testNode("x", "2"), testNode("y", "1")),
+		},
+		{
+			name: "identical children",
+			a:    testNode("root", "r", testNode("x", "1"), testNode("y", "1")),
+			b:    testNode("root", "r"),
+		},
+		{
+			name: "renamed dep",
+			a:    testNode("root", "r", testNode("x", "1")),
+			b:    testNode("root", "r", testNode("z", "1")),
+		},
+		{
+			name: "field boundaries",
+			a:    testNode("root", "ab", testNode("x", "c")),
+			b:    testNode("root", "a", testNode("x", "bc")),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if bytes.Equal(test.a.HashNode(), test.b.HashNode()) {
+				t.Logf("%s and %s shouldn't hash the same", test.a.Label, test.b.Label)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestHashNodeConfig(t *testing.T) {
+	opt := build.DefaultConfig()
+	opt.CompilationMode = build.Opt
+	copts := build.DefaultConfig()
+	copts.Copts = []string{"-Wall"}
+	configs := []*build.Config{nil, build.DefaultConfig(), opt, copts}
+	seen := make(map[string]int)
+	for i, config := range configs {
+		n := testNode("root", "r", testNode("x", "1"))
+		n.Config = config
+		h := string(n.HashNode())
+		if j, ok := seen[h]; ok {
+			t.Logf("configurations %d and %d shouldn't hash the same", j, i)
+			t.Fail()
+		}
+		seen[h] = i
+	}
+}
+
+func TestHashNodeDeterministic(t *testing.T) {
+	a := testNode("root", "r", testNode("x", "1"), testNode("y", "2"), testNode("z", "3"))
+	b := testNode("root", "r", testNode("z", "3"), testNode("y", "2"), testNode("x", "1"))
+	if !bytes.Equal(a.HashNode(), b.HashNode()) {
+		t.Log("hashes shouldn't depend on the order children were added in")
+		t.Fail()
+	}
+}
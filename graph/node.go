@@ -25,8 +25,9 @@ func NewNode(l label.Label, t build.Rule) Node {
 
 // Node encapsulates a target and represents a node in the build graph.
 type Node struct {
-	IsRoot        bool       `json:"-"`
-	Target        build.Rule `json:"-"`
+	IsRoot        bool          `json:"-"`
+	Target        build.Rule    `json:"-"`
+	Config        *build.Config `json:"-"`
 	Type          string
 	Parents       map[string]*Node `json:"-"`
 	Label         label.Label
@@ -0,0 +1,112 @@
+// Copyright 2018 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+
+	"bldy.build/build/label"
+	"bldy.build/build/workspace"
+	"github.com/pkg/errors"
+)
+
+// expand returns the labels of the targets matched by patterns. Patterns
+// are applied in order, negative patterns remove the targets they match
+// from the ones matched so far. Patterns that aren't absolute are relative
+// to the package wd is in. wildcard has the targets that were only matched
+// by patterns like //pkg:all and //pkg/... and not by their labels.
+func (g *Graph) expand(wd string, patterns []string) (lbls []label.Label, wildcard map[label.Label]bool, err error) {
+	wdpkg, err := filepath.Rel(g.ws.AbsPath(), wd)
+	if err != nil {
+		return nil, nil, err
+	}
+	wdpkg = filepath.ToSlash(wdpkg)
+
+	// matched is whether the targets were matched by their labels
+	matched := make(map[label.Label]bool)
+	for _, s := range patterns {
+		p, err := label.ParsePattern(s, wdpkg)
+		if err != nil {
+			return nil, nil, err
+		}
+		if p.Negative {
+			for lbl := range matched {
+				if p.Matches(lbl) {
+					delete(matched, lbl)
+				}
+			}
+			continue
+		}
+		found, err := g.match(p)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "expanding %s", s)
+		}
+		explicit := !p.Recursive && p.Target != ""
+		for _, lbl := range found {
+			matched[lbl] = matched[lbl] || explicit
+		}
+	}
+	lbls = []label.Label{}
+	wildcard = make(map[label.Label]bool)
+	for lbl, explicit := range matched {
+		lbls = append(lbls, lbl)
+		if !explicit {
+			wildcard[lbl] = true
+		}
+	}
+	sort.Slice(lbls, func(i, j int) bool { return lbls[i] < lbls[j] })
+	return lbls, wildcard, nil
+}
+
+func (g *Graph) match(p label.Pattern) ([]label.Label, error) {
+	if !p.Recursive && p.Target != "" {
+		return []label.Label{label.New(p.Package, p.Target)}, nil
+	}
+	pkgs := []string{p.Package}
+	if p.Recursive {
+		root := p.Package
+		if root == "." {
+			root = ""
+		}
+		var err error
+		if pkgs, err = workspace.Packages(g.ws, root); err != nil {
+			return nil, err
+		}
+	}
+	// packages are evaluated concurrently, but the results are collected
+	// in the order the packages were found in.
+	targets := make([][]label.Label, len(pkgs))
+	errs := make([]error, len(pkgs))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, runtime.NumCPU())
+	for i, pkg := range pkgs {
+		wg.Add(1)
+		go func(i int, pkg string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			targets[i], errs[i] = g.vm.Targets(pkg)
+			<-sem
+		}(i, pkg)
+	}
+	wg.Wait()
+
+	var lbls []label.Label
+	for i, pkg := range pkgs {
+		if errs[i] != nil {
+			// the other packages can still be loaded
+			g.errs = append(g.errs, &Error{Label: label.New(pkg, workspace.BUILDFILE), Err: errs[i]})
+			continue
+		}
+		for _, lbl := range targets[i] {
+			if p.Matches(lbl) {
+				lbls = append(lbls, lbl)
+			}
+		}
+	}
+	return lbls, nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2018 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graph
+
+import (
+	"fmt"
+
+	"bldy.build/build"
+	"bldy.build/build/label"
+)
+
+// checkVisibility checks dep is visible to the target lbl. Targets are
+// always visible to the targets in their own package.
+func (g *Graph) checkVisibility(lbl, dep label.Label, t build.Rule) error {
+	v, ok := t.(build.Visible)
+	if !ok || dep.Package() == lbl.Package() {
+		return nil
+	}
+	seen := make(map[label.Label]bool)
+	for _, vis := range v.Visibility() {
+		visible, err := g.visibleTo(lbl.Package(), vis, seen)
+		if err != nil {
+			return fmt.Errorf("visibility of %s: %v", dep, err)
+		}
+		if visible {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s isn't visible to it, it's only visible to %v", dep, v.Visibility())
+}
+
+// visibleTo reports whether the package pkg is one of the packages the
+// visibility label vis makes targets visible to.
+func (g *Graph) visibleTo(pkg string, vis label.Label, seen map[label.Label]bool) (bool, error) {
+	switch {
+	case vis == build.PublicVisibility:
+		return true, nil
+	case vis == build.PrivateVisibility:
+		return false, nil
+	case vis.Name() == "__pkg__":
+		return vis.Package() == pkg, nil
+	case vis.Name() == "__subpackages__":
+		p := label.Pattern{Package: vis.Package(), Recursive: true}
+		return p.Matches(label.New(pkg, "all")), nil
+	}
+	if seen[vis] {
+		return false, nil
+	}
+	seen[vis] = true
+	t, err := g.vm.GetTarget(vis)
+	if err != nil {
+		return false, err
+	}
+	group, ok := t.(build.PackageGroup)
+	if !ok {
+		return false, fmt.Errorf("%s isn't a package group", vis)
+	}
+	if group.Contains(pkg) {
+		return true, nil
+	}
+	for _, include := range group.Includes() {
+		if visible, err := g.visibleTo(pkg, include, seen); err != nil || visible {
+			return visible, err
+		}
+	}
+	return false, nil
+}
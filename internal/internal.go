@@ -8,6 +8,7 @@ package internal
 
 import (
 	"fmt"
+	"sort"
 
 	"reflect"
 )
@@ -62,3 +63,18 @@ func Rules() []string {
 	}
 	return s
 }
+
+// Kind returns the name the type t was registered with.
+func Kind(t reflect.Type) (string, bool) {
+	names := []string{}
+	for name, ty := range rules {
+		if ty == t {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", false
+	}
+	sort.Strings(names)
+	return names[0], true
+}
@@ -0,0 +1,116 @@
+// Copyright 2018 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package label
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+const recursive = "..."
+
+// Pattern is a target pattern, unlike a label it can match more than one
+// target.
+//
+//	//foo:bar	the target bar in the package foo
+//	//foo		the target foo in the package foo
+//	//foo:all	every target in the package foo, //foo:* is the same
+//	//foo/...	every target in foo and all the packages beneath it
+//	//...		every target in the workspace
+//	-//foo/...	removes the targets matched by //foo/... from the set
+//
+// https://docs.bazel.build/versions/master/user-manual.html#target-patterns
+type Pattern struct {
+	Negative  bool
+	Package   string
+	Recursive bool
+	// Target is empty if the pattern matches every target in the package.
+	Target string
+}
+
+// ParsePattern parses a target pattern. Patterns that don't start with //
+// are relative to the package pkg.
+func ParsePattern(s, pkg string) (Pattern, error) {
+	p := Pattern{}
+	orig := s
+	if strings.HasPrefix(s, "-") {
+		p.Negative = true
+		s = s[1:]
+	}
+	switch {
+	case strings.HasPrefix(s, "//"):
+		s = s[2:]
+	case strings.HasPrefix(s, ":"):
+		s = pkg + s
+	default:
+		s = path.Join(pkg, s)
+	}
+
+	pkgPart, target := s, ""
+	hasTarget := false
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		pkgPart, target, hasTarget = s[:i], s[i+1:], true
+	}
+	if pkgPart == recursive || strings.HasSuffix(pkgPart, "/"+recursive) {
+		p.Recursive = true
+		pkgPart = strings.TrimSuffix(strings.TrimSuffix(pkgPart, recursive), "/")
+	}
+	if strings.Contains(pkgPart, recursive) || strings.HasSuffix(pkgPart, "/") || strings.HasPrefix(pkgPart, "/") {
+		return p, fmt.Errorf("label: %q is not a valid target pattern", orig)
+	}
+	if pkgPart == "" {
+		pkgPart = "."
+	}
+	p.Package = pkgPart
+
+	switch {
+	case target == "all", target == "*":
+	case p.Recursive && hasTarget:
+		return p, fmt.Errorf("label: %q is not a valid target pattern, recursive patterns can only match all targets", orig)
+	case p.Recursive:
+	case !hasTarget:
+		_, p.Target = path.Split(pkgPart)
+	default:
+		p.Target = target
+	}
+	if !p.Recursive && p.Target != "" {
+		if err := New(p.Package, p.Target).Valid(); err != nil {
+			return p, fmt.Errorf("label: %q is not a valid target pattern: %v", orig, err)
+		}
+	}
+	return p, nil
+}
+
+// Matches checks if the pattern matches lbl, the sign of the pattern is
+// not taken in to account.
+func (p Pattern) Matches(lbl Label) bool {
+	pkg := lbl.Package()
+	switch {
+	case p.Recursive:
+		if p.Package != "." && pkg != p.Package && !strings.HasPrefix(pkg, p.Package+"/") {
+			return false
+		}
+	case pkg != p.Package:
+		return false
+	}
+	return p.Target == "" || p.Target == lbl.Name()
+}
+
+func (p Pattern) String() string {
+	s := "//"
+	if p.Negative {
+		s = "-" + s
+	}
+	switch {
+	case p.Recursive && p.Package == ".":
+		return s + recursive
+	case p.Recursive:
+		return s + p.Package + "/" + recursive
+	case p.Target == "":
+		return s + p.Package + ":all"
+	}
+	return s + p.Package + ":" + p.Target
+}
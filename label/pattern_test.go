@@ -0,0 +1,86 @@
+// Copyright 2018 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package label
+
+import (
+	"testing"
+)
+
+func TestParsePattern(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		pkg     string
+		want    Pattern
+	}{
+		{"target", "//foo/bar:baz", "", Pattern{Package: "foo/bar", Target: "baz"}},
+		{"omit name", "//foo/bar", "", Pattern{Package: "foo/bar", Target: "bar"}},
+		{"all", "//foo:all", "", Pattern{Package: "foo"}},
+		{"star", "//foo:*", "", Pattern{Package: "foo"}},
+		{"recursive", "//foo/...", "", Pattern{Package: "foo", Recursive: true}},
+		{"recursive all", "//foo/...:all", "", Pattern{Package: "foo", Recursive: true}},
+		{"everything", "//...", "", Pattern{Package: ".", Recursive: true}},
+		{"negative", "-//foo/...", "", Pattern{Negative: true, Package: "foo", Recursive: true}},
+		{"relative target", ":baz", "foo", Pattern{Package: "foo", Target: "baz"}},
+		{"relative recursive", "...", "foo", Pattern{Package: "foo", Recursive: true}},
+		{"relative package", "bar:all", "foo", Pattern{Package: "foo/bar"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := ParsePattern(test.pattern, test.pkg)
+			if err != nil {
+				t.Log(err)
+				t.FailNow()
+			}
+			if p != test.want {
+				t.Logf("parsing %q: was expecting %+v got %+v instead", test.pattern, test.want, p)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestParsePatternInvalid(t *testing.T) {
+	for _, pattern := range []string{
+		"//foo/...:bar",
+		"//foo/.../bar",
+		"//foo/",
+	} {
+		if _, err := ParsePattern(pattern, ""); err == nil {
+			t.Logf("%q should not be a valid pattern", pattern)
+			t.Fail()
+		}
+	}
+}
+
+func TestPatternMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		label   Label
+		matches bool
+	}{
+		{"//foo:bar", "//foo:bar", true},
+		{"//foo:bar", "//foo:baz", false},
+		{"//foo:all", "//foo:baz", true},
+		{"//foo:all", "//foo/bar:baz", false},
+		{"//foo/...", "//foo:baz", true},
+		{"//foo/...", "//foo/bar:baz", true},
+		{"//foo/...", "//foobar:baz", false},
+		{"//...", "//foobar:baz", true},
+	}
+	for _, test := range tests {
+		t.Run(test.pattern, func(t *testing.T) {
+			p, err := ParsePattern(test.pattern, "")
+			if err != nil {
+				t.Log(err)
+				t.FailNow()
+			}
+			if got := p.Matches(test.label); got != test.matches {
+				t.Logf("%s matching %s: was expecting %v got %v instead", p, test.label, test.matches, got)
+				t.Fail()
+			}
+		})
+	}
+}
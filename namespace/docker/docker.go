@@ -63,7 +63,7 @@ func (n *Namespace) MountWorkspace(s string) {
 
 }
 
-func (n *Namespace) Cmd(ctx context.Context, cmd string, args ...string) namespace.Cmd {
+func (n *Namespace) Cmd(ctx context.Context, env []string, cmd string, args ...string) namespace.Cmd {
 	binds := []string{}
 	for _, s := range n.mounts {
 		binds = append(binds, fmt.Sprintf("%s:%s", s, s))
@@ -77,5 +77,7 @@ func (n *Namespace) Cmd(ctx context.Context, cmd string, args ...string) namespa
 	}
 	debug.Println(binds)
 
-	return d.Command(n.e, cmd, args...)
+	x := d.Command(n.e, cmd, args...)
+	x.Env = env
+	return x
 }
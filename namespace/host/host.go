@@ -53,9 +53,9 @@ func (n Namespace) Bind(new, old string, flags int) {
 }
 func (ns Namespace) Mount(new, old string, flags int) {}
 
-func (n Namespace) Cmd(ctx context.Context, cmd string, args ...string) namespace.Cmd {
+func (n Namespace) Cmd(ctx context.Context, env []string, cmd string, args ...string) namespace.Cmd {
 	x := exec.CommandContext(ctx, cmd, args...)
-	x.Env = n.environ()
+	x.Env = append(n.environ(), env...)
 	x.Dir = n.dir
 	return x
 }
@@ -78,3 +78,15 @@ func (n Namespace) OpenFile(name string, flag int, perm os.FileMode) (*os.File,
 func (n Namespace) Create(name string) (*os.File, error) {
 	return os.Create(filepath.Join(n.dir, name))
 }
+
+// Symlink creates newname in the namespace as a symbolic link to oldname,
+// relative oldnames are relative to the directory of newname.
+func (n Namespace) Symlink(oldname, newname string) error {
+	return os.Symlink(oldname, filepath.Join(n.dir, newname))
+}
+
+// Rename moves oldname to newname in the namespace, replacing newname if it
+// exists.
+func (n Namespace) Rename(oldname, newname string) error {
+	return os.Rename(filepath.Join(n.dir, oldname), filepath.Join(n.dir, newname))
+}
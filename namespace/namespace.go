@@ -14,11 +14,15 @@ const (
 type Namespace interface {
 	Bind(new, old string, flags int)
 	Mount(new, old string, flags int)
-	Cmd(ctx context.Context, cmd string, args ...string) Cmd
+	// Cmd returns cmd to run in the namespace, env is set on top of the
+	// environment of the namespace.
+	Cmd(ctx context.Context, env []string, cmd string, args ...string) Cmd
 	Mkdir(name string) error
 	Open(name string) (*os.File, error)
 	OpenFile(name string, flag int, perm os.FileMode) (*os.File, error)
 	Create(name string) (*os.File, error)
+	Symlink(oldname, newname string) error
+	Rename(oldname, newname string) error
 }
 
 type Workspace interface {
@@ -0,0 +1,363 @@
+// Copyright 2018 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package query evaluates queries over build graphs.
+//
+//	deps(x)			x and everything it depends on
+//	deps(x, depth)		same as deps(x) but stops depth edges away from x
+//	rdeps(u, x)		everything in the transitive closure of u that depends on x
+//	rdeps(u, x, depth)	same as rdeps(u, x) but stops depth edges away from x
+//	somepath(from, to)	a path from one of from to one of to
+//	allpaths(from, to)	every target on a path from one of from to one of to
+//	kind(regex, x)		the targets in x whose kind matches regex
+//	attr(name, regex, x)	the targets in x whose attribute name matches regex
+//	filter(regex, x)	the targets in x whose label matches regex
+//	x + y, x union y	the targets in either x or y
+//	x - y, x except y	the targets in x that aren't in y
+//	x ^ y, x intersect y	the targets in both x and y
+//
+// Every other word is a target pattern.
+package query
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"bldy.build/build/graph"
+	"bldy.build/build/internal"
+	"github.com/pkg/errors"
+)
+
+const (
+	exprArg = iota
+	wordArg
+	intArg
+)
+
+type function struct {
+	args     []int
+	required int
+}
+
+func (f function) arity() string {
+	if f.required == len(f.args) {
+		return strconv.Itoa(f.required)
+	}
+	return fmt.Sprintf("%d or %d", f.required, len(f.args))
+}
+
+var functions = map[string]function{
+	"deps":     {[]int{exprArg, intArg}, 1},
+	"rdeps":    {[]int{exprArg, exprArg, intArg}, 2},
+	"somepath": {[]int{exprArg, exprArg}, 2},
+	"allpaths": {[]int{exprArg, exprArg}, 2},
+	"kind":     {[]int{wordArg, exprArg}, 2},
+	"attr":     {[]int{wordArg, wordArg, exprArg}, 3},
+	"filter":   {[]int{wordArg, exprArg}, 2},
+}
+
+// Expander expands target patterns to the nodes they match, graph.Graph
+// is the Expander queries are usually evaluated against.
+type Expander interface {
+	Expand(patterns ...string) ([]*graph.Node, error)
+}
+
+// Eval evaluates the query e and returns the matching nodes sorted by label.
+func Eval(x Expander, e Expr) ([]*graph.Node, error) {
+	s, err := (&evaluator{x}).eval(e)
+	if err != nil {
+		return nil, err
+	}
+	return s.sorted(), nil
+}
+
+type set map[*graph.Node]bool
+
+func newSet(nodes ...*graph.Node) set {
+	s := make(set)
+	for _, n := range nodes {
+		s[n] = true
+	}
+	return s
+}
+
+func (s set) sorted() []*graph.Node {
+	nodes := []*graph.Node{}
+	for n := range s {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Label < nodes[j].Label })
+	return nodes
+}
+
+// children returns the children of n sorted by label so walks over the
+// graph always take the same path.
+func children(n *graph.Node) []*graph.Node {
+	s := make(set)
+	for _, c := range n.Children {
+		s[c] = true
+	}
+	return s.sorted()
+}
+
+type evaluator struct {
+	x Expander
+}
+
+func (ev *evaluator) eval(e Expr) (set, error) {
+	switch e := e.(type) {
+	case Word:
+		nodes, err := ev.x.Expand(string(e))
+		if err != nil {
+			return nil, errors.Wrapf(err, "query: %s", e)
+		}
+		return newSet(nodes...), nil
+	case *Binary:
+		return ev.binary(e)
+	case *Call:
+		return ev.call(e)
+	}
+	return nil, fmt.Errorf("query: can't evaluate %T", e)
+}
+
+func (ev *evaluator) binary(b *Binary) (set, error) {
+	x, err := ev.eval(b.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := ev.eval(b.Y)
+	if err != nil {
+		return nil, err
+	}
+	s := make(set)
+	switch b.Op {
+	case "+":
+		for n := range x {
+			s[n] = true
+		}
+		for n := range y {
+			s[n] = true
+		}
+	case "-":
+		for n := range x {
+			if !y[n] {
+				s[n] = true
+			}
+		}
+	case "^":
+		for n := range x {
+			if y[n] {
+				s[n] = true
+			}
+		}
+	default:
+		return nil, fmt.Errorf("query: unknown operator %q", b.Op)
+	}
+	return s, nil
+}
+
+func (ev *evaluator) call(c *Call) (set, error) {
+	fn := functions[c.Func]
+	args := make([]set, len(c.Args))
+	for i, arg := range c.Args {
+		if fn.args[i] != exprArg {
+			continue
+		}
+		s, err := ev.eval(arg)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = s
+	}
+	word := func(i int) string { return string(c.Args[i].(Word)) }
+	depth := func(i int) int {
+		if i >= len(c.Args) {
+			return -1
+		}
+		d, _ := strconv.Atoi(word(i))
+		return d
+	}
+
+	switch c.Func {
+	case "deps":
+		return deps(args[0], depth(1)), nil
+	case "rdeps":
+		return rdeps(args[0], args[1], depth(2)), nil
+	case "somepath":
+		return somepath(args[0], args[1]), nil
+	case "allpaths":
+		return allpaths(args[0], args[1]), nil
+	case "kind":
+		return match(c.Func, word(0), args[1], func(n *graph.Node) (string, bool) { return Kind(n), true })
+	case "attr":
+		name := word(0)
+		return match(c.Func, word(1), args[2], func(n *graph.Node) (string, bool) { return Attr(n, name) })
+	case "filter":
+		return match(c.Func, word(0), args[1], func(n *graph.Node) (string, bool) { return n.Label.String(), true })
+	}
+	return nil, fmt.Errorf("query: %s is not a query function", c.Func)
+}
+
+// deps returns the nodes that are at most depth edges away from x, if
+// depth is negative there is no limit.
+func deps(x set, depth int) set {
+	s := make(set)
+	frontier := x
+	for d := 0; len(frontier) > 0 && (depth < 0 || d <= depth); d++ {
+		next := make(set)
+		for n := range frontier {
+			if s[n] {
+				continue
+			}
+			s[n] = true
+			for _, c := range n.Children {
+				next[c] = true
+			}
+		}
+		frontier = next
+	}
+	return s
+}
+
+func rdeps(universe, x set, depth int) set {
+	u := deps(universe, -1)
+	parents := make(map[*graph.Node][]*graph.Node)
+	for n := range u {
+		for _, c := range n.Children {
+			parents[c] = append(parents[c], n)
+		}
+	}
+	s := make(set)
+	frontier := make(set)
+	for n := range x {
+		if u[n] {
+			frontier[n] = true
+		}
+	}
+	for d := 0; len(frontier) > 0 && (depth < 0 || d <= depth); d++ {
+		next := make(set)
+		for n := range frontier {
+			if s[n] {
+				continue
+			}
+			s[n] = true
+			for _, p := range parents[n] {
+				next[p] = true
+			}
+		}
+		frontier = next
+	}
+	return s
+}
+
+func somepath(from, to set) set {
+	visited := make(set)
+	var path []*graph.Node
+	var walk func(n *graph.Node) bool
+	walk = func(n *graph.Node) bool {
+		if visited[n] {
+			return false
+		}
+		visited[n] = true
+		path = append(path, n)
+		if to[n] {
+			return true
+		}
+		for _, c := range children(n) {
+			if walk(c) {
+				return true
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	for _, n := range from.sorted() {
+		if walk(n) {
+			return newSet(path...)
+		}
+	}
+	return make(set)
+}
+
+func allpaths(from, to set) set {
+	reaches := make(map[*graph.Node]bool)
+	var walk func(n *graph.Node) bool
+	walk = func(n *graph.Node) bool {
+		if r, ok := reaches[n]; ok {
+			return r
+		}
+		// nodes that are being walked don't reach to until proven otherwise
+		reaches[n] = false
+		r := to[n]
+		for _, c := range n.Children {
+			if walk(c) {
+				r = true
+			}
+		}
+		reaches[n] = r
+		return r
+	}
+	s := make(set)
+	for n := range deps(from, -1) {
+		if walk(n) {
+			s[n] = true
+		}
+	}
+	return s
+}
+
+func match(fn, expr string, x set, value func(*graph.Node) (string, bool)) (set, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "query: %s", fn)
+	}
+	s := make(set)
+	for n := range x {
+		if v, ok := value(n); ok && re.MatchString(v) {
+			s[n] = true
+		}
+	}
+	return s, nil
+}
+
+// Kind returns the kind of the rule a node was declared with, for skylark
+// rules that's the name the rule was exported as and for native rules it's
+// the name they were registered with.
+func Kind(n *graph.Node) string {
+	if k, ok := n.Target.(interface{ Kind() string }); ok {
+		return k.Kind()
+	}
+	if t := reflect.TypeOf(n.Target); t != nil && t.Kind() == reflect.Ptr {
+		if kind, ok := internal.Kind(t.Elem()); ok {
+			return kind
+		}
+	}
+	return n.Type
+}
+
+// Attr returns the value of the attribute name of the node's rule.
+func Attr(n *graph.Node, name string) (string, bool) {
+	if a, ok := n.Target.(interface {
+		Attr(string) (string, bool)
+	}); ok {
+		return a.Attr(name)
+	}
+	t := reflect.TypeOf(n.Target)
+	if t == nil || t.Kind() != reflect.Ptr {
+		return "", false
+	}
+	f, err := internal.GetFieldByTag(Kind(n), name, t.Elem())
+	if err != nil {
+		return "", false
+	}
+	v := reflect.ValueOf(n.Target).Elem().FieldByIndex(f.Index).Interface()
+	if strs, ok := v.([]string); ok {
+		return strings.Join(strs, " "), true
+	}
+	return fmt.Sprint(v), true
+}
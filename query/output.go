@@ -0,0 +1,149 @@
+// Copyright 2018 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package query
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+
+	"bldy.build/build/graph"
+)
+
+// Formats are the output formats Write supports.
+var Formats = map[string]func(io.Writer, []*graph.Node) error{
+	"label":   writeLabels,
+	"json":    writeJSON,
+	"dot":     writeDot,
+	"graphml": writeGraphML,
+}
+
+// Write writes the result of a query in format.
+func Write(w io.Writer, format string, nodes []*graph.Node) error {
+	f, ok := Formats[format]
+	if !ok {
+		return fmt.Errorf("query: unknown output format %q", format)
+	}
+	return f(w, nodes)
+}
+
+// edges returns the labels of the children of n that are in the result.
+func edges(n *graph.Node, in set) []string {
+	lbls := []string{}
+	for _, c := range n.Children {
+		if in[c] {
+			lbls = append(lbls, c.Label.String())
+		}
+	}
+	sort.Strings(lbls)
+	return lbls
+}
+
+func writeLabels(w io.Writer, nodes []*graph.Node) error {
+	for _, n := range nodes {
+		if _, err := fmt.Fprintln(w, n.Label); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type jsonNode struct {
+	Label string   `json:"label"`
+	Kind  string   `json:"kind"`
+	Deps  []string `json:"deps"`
+}
+
+// writeJSON writes the nodes with all of their direct dependencies, not
+// just the ones in the result.
+func writeJSON(w io.Writer, nodes []*graph.Node) error {
+	out := []jsonNode{}
+	for _, n := range nodes {
+		deps := []string{}
+		for _, c := range children(n) {
+			deps = append(deps, c.Label.String())
+		}
+		out = append(out, jsonNode{n.Label.String(), Kind(n), deps})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func writeDot(w io.Writer, nodes []*graph.Node) error {
+	in := newSet(nodes...)
+	fmt.Fprintln(w, "digraph query {")
+	for _, n := range nodes {
+		fmt.Fprintf(w, "\t%q [label=%q];\n", n.Label, fmt.Sprintf("%s\n%s", n.Label, Kind(n)))
+		for _, c := range edges(n, in) {
+			fmt.Fprintf(w, "\t%q -> %q;\n", n.Label, c)
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   struct {
+		ID          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLNode struct {
+	ID   string `xml:"id,attr"`
+	Data struct {
+		Key   string `xml:"key,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+}
+
+func writeGraphML(w io.Writer, nodes []*graph.Node) error {
+	g := graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys:  []graphMLKey{{ID: "kind", For: "node", Name: "kind", Type: "string"}},
+	}
+	g.Graph.ID = "query"
+	g.Graph.EdgeDefault = "directed"
+	in := newSet(nodes...)
+	for _, n := range nodes {
+		gn := graphMLNode{ID: n.Label.String()}
+		gn.Data.Key = "kind"
+		gn.Data.Value = Kind(n)
+		g.Graph.Nodes = append(g.Graph.Nodes, gn)
+		for _, c := range edges(n, in) {
+			g.Graph.Edges = append(g.Graph.Edges, graphMLEdge{Source: n.Label.String(), Target: c})
+		}
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(g); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
@@ -0,0 +1,272 @@
+// Copyright 2018 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokLParen
+	tokRParen
+	tokComma
+	tokPlus
+	tokMinus
+	tokCaret
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+	// quoted words are never keywords or function names
+	quoted bool
+}
+
+func isWordChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("*/@.-_:$~[]", r)
+}
+
+// lex splits a query in to tokens. Words are made of letters, digits and
+// */@.-_:$~[] anything else has to be quoted. A lone - is the except
+// operator, so it has to be surrounded by spaces.
+func lex(s string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(s); {
+		r := rune(s[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			toks = append(toks, token{kind: tokLParen, text: "(", pos: i})
+			i++
+		case r == ')':
+			toks = append(toks, token{kind: tokRParen, text: ")", pos: i})
+			i++
+		case r == ',':
+			toks = append(toks, token{kind: tokComma, text: ",", pos: i})
+			i++
+		case r == '+':
+			toks = append(toks, token{kind: tokPlus, text: "+", pos: i})
+			i++
+		case r == '^':
+			toks = append(toks, token{kind: tokCaret, text: "^", pos: i})
+			i++
+		case r == '"' || r == '\'':
+			end := strings.IndexRune(s[i+1:], r)
+			if end < 0 {
+				return nil, fmt.Errorf("query: unterminated quote at %d", i)
+			}
+			toks = append(toks, token{kind: tokWord, text: s[i+1 : i+1+end], pos: i, quoted: true})
+			i += end + 2
+		case isWordChar(r):
+			j := i
+			for j < len(s) && isWordChar(rune(s[j])) {
+				j++
+			}
+			word := s[i:j]
+			switch word {
+			case "-", "except":
+				toks = append(toks, token{kind: tokMinus, text: word, pos: i})
+			case "union":
+				toks = append(toks, token{kind: tokPlus, text: word, pos: i})
+			case "intersect":
+				toks = append(toks, token{kind: tokCaret, text: word, pos: i})
+			default:
+				toks = append(toks, token{kind: tokWord, text: word, pos: i})
+			}
+			i = j
+		default:
+			return nil, fmt.Errorf("query: unexpected %q at %d", r, i)
+		}
+	}
+	return append(toks, token{kind: tokEOF, text: "", pos: len(s)}), nil
+}
+
+// Expr is a parsed query expression.
+type Expr interface {
+	String() string
+}
+
+// Word is a target pattern, or a plain argument to a function like the
+// regular expression of filter.
+type Word string
+
+func (w Word) String() string { return string(w) }
+
+// Binary is a set operation, + is the union, - is the difference and ^ is
+// the intersection of the sets.
+type Binary struct {
+	Op   string
+	X, Y Expr
+}
+
+func (b *Binary) String() string { return fmt.Sprintf("(%s %s %s)", b.X, b.Op, b.Y) }
+
+// Call is a call to one of the query functions.
+type Call struct {
+	Func string
+	Args []Expr
+}
+
+func (c *Call) String() string {
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = arg.String()
+	}
+	return fmt.Sprintf("%s(%s)", c.Func, strings.Join(args, ", "))
+}
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+// Parse parses a query expression.
+//
+//	expr := word
+//	      | func '(' arg (',' arg)* ')'
+//	      | '(' expr ')'
+//	      | expr ('+' | 'union' | '-' | 'except' | '^' | 'intersect') expr
+//
+// The set operators have the same precedence and are left associative.
+func Parse(s string) (Expr, error) {
+	toks, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %q", t.text)
+	}
+	return e, nil
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return fmt.Errorf("query: %d: %s", t.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) expect(kind tokenKind, text string) error {
+	if t := p.next(); t.kind != kind {
+		if t.kind == tokEOF {
+			return p.errorf(t, "expected %q, got the end of the query", text)
+		}
+		return p.errorf(t, "expected %q, got %q", text, t.text)
+	}
+	return nil
+}
+
+func (p *parser) expr() (Expr, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op string
+		switch p.peek().kind {
+		case tokPlus:
+			op = "+"
+		case tokMinus:
+			op = "-"
+		case tokCaret:
+			op = "^"
+		default:
+			return x, nil
+		}
+		p.next()
+		y, err := p.primary()
+		if err != nil {
+			return nil, err
+		}
+		x = &Binary{Op: op, X: x, Y: y}
+	}
+}
+
+func (p *parser) primary() (Expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokLParen:
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokRParen, ")"); err != nil {
+			return nil, err
+		}
+		return e, nil
+	case tokWord:
+		if p.peek().kind != tokLParen || t.quoted {
+			return Word(t.text), nil
+		}
+		return p.call(t)
+	case tokEOF:
+		return nil, p.errorf(t, "unexpected end of the query")
+	}
+	return nil, p.errorf(t, "unexpected %q", t.text)
+}
+
+func (p *parser) call(name token) (Expr, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, p.errorf(name, "%s is not a query function", name.text)
+	}
+	p.next() // (
+	c := &Call{Func: name.text}
+	for {
+		arg, err := p.arg(fn, len(c.Args))
+		if err != nil {
+			return nil, err
+		}
+		c.Args = append(c.Args, arg)
+		if p.peek().kind != tokComma {
+			break
+		}
+		p.next()
+	}
+	if err := p.expect(tokRParen, ")"); err != nil {
+		return nil, err
+	}
+	if n := len(c.Args); n < fn.required || n > len(fn.args) {
+		return nil, p.errorf(name, "%s takes %s arguments, got %d", name.text, fn.arity(), n)
+	}
+	return c, nil
+}
+
+func (p *parser) arg(fn function, i int) (Expr, error) {
+	if i >= len(fn.args) || fn.args[i] == exprArg {
+		return p.expr()
+	}
+	t := p.next()
+	if t.kind != tokWord {
+		return nil, p.errorf(t, "expected a word, got %q", t.text)
+	}
+	if fn.args[i] == intArg {
+		if _, err := strconv.Atoi(t.text); err != nil {
+			return nil, p.errorf(t, "%q is not an integer", t.text)
+		}
+	}
+	return Word(t.text), nil
+}
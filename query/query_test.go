@@ -0,0 +1,175 @@
+package query
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"bldy.build/build"
+	"bldy.build/build/executor"
+	"bldy.build/build/graph"
+	"bldy.build/build/label"
+	"bldy.build/build/workspace"
+)
+
+type testRule struct {
+	name string
+	kind string
+	srcs string
+}
+
+func (t *testRule) Name() string                   { return t.name }
+func (t *testRule) Dependencies() []label.Label    { return nil }
+func (t *testRule) Outputs() []string              { return nil }
+func (t *testRule) Hash() []byte                   { return nil }
+func (t *testRule) Build(*executor.Executor) error { return nil }
+func (t *testRule) Platform() label.Label          { return build.DefaultPlatform }
+func (t *testRule) Workspace() workspace.Workspace { return nil }
+func (t *testRule) Kind() string                   { return t.kind }
+func (t *testRule) Attr(name string) (string, bool) {
+	if name == "srcs" {
+		return t.srcs, true
+	}
+	return "", false
+}
+
+// testGraph expands patterns by matching them against the nodes.
+type testGraph map[string]*graph.Node
+
+func (g testGraph) Expand(patterns ...string) ([]*graph.Node, error) {
+	var nodes []*graph.Node
+	for _, s := range patterns {
+		p, err := label.ParsePattern(s, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range g {
+			if p.Matches(n.Label) {
+				nodes = append(nodes, n)
+			}
+		}
+	}
+	return nodes, nil
+}
+
+func (g testGraph) add(lbl, kind, srcs string, deps ...string) {
+	l := label.Label(lbl)
+	n := graph.NewNode(l, &testRule{name: l.Name(), kind: kind, srcs: srcs})
+	for _, d := range deps {
+		c := g[d]
+		n.Children[d] = c
+		c.Parents[lbl] = &n
+	}
+	g[lbl] = &n
+}
+
+// newTestGraph returns
+//
+//	//cmd:app -> //lib:a -> //lib:c
+//	        \-> //lib:b -/
+//	//cmd:tool -> //lib:b
+func newTestGraph() testGraph {
+	g := testGraph{}
+	g.add("//lib:c", "cc_library", "c.c")
+	g.add("//lib:a", "cc_library", "a.c", "//lib:c")
+	g.add("//lib:b", "go_library", "b.go", "//lib:c")
+	g.add("//cmd:app", "cc_binary", "main.c", "//lib:a", "//lib:b")
+	g.add("//cmd:tool", "go_binary", "main.go", "//lib:b")
+	return g
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"//cmd:app", []string{"//cmd:app"}},
+		{"deps(//cmd:app)", []string{"//cmd:app", "//lib:a", "//lib:b", "//lib:c"}},
+		{"deps(//cmd:app, 1)", []string{"//cmd:app", "//lib:a", "//lib:b"}},
+		{"deps(//cmd:app, 0)", []string{"//cmd:app"}},
+		{"rdeps(//..., //lib:b)", []string{"//cmd:app", "//cmd:tool", "//lib:b"}},
+		{"rdeps(//cmd:tool, //lib:c)", []string{"//cmd:tool", "//lib:b", "//lib:c"}},
+		{"rdeps(//..., //lib:c, 1)", []string{"//lib:a", "//lib:b", "//lib:c"}},
+		{"somepath(//cmd:app, //lib:c)", []string{"//cmd:app", "//lib:a", "//lib:c"}},
+		{"somepath(//cmd:tool, //lib:a)", []string{}},
+		{"allpaths(//cmd:app, //lib:c)", []string{"//cmd:app", "//lib:a", "//lib:b", "//lib:c"}},
+		{"allpaths(//cmd/..., //lib:a)", []string{"//cmd:app", "//lib:a"}},
+		{"kind(cc_.*, //...)", []string{"//cmd:app", "//lib:a", "//lib:c"}},
+		{"kind(binary, deps(//cmd:app))", []string{"//cmd:app"}},
+		{`attr(srcs, "\.go$", //...)`, []string{"//cmd:tool", "//lib:b"}},
+		{"filter(lib, deps(//cmd:tool))", []string{"//lib:b", "//lib:c"}},
+		{"//lib:all - //lib:c", []string{"//lib:a", "//lib:b"}},
+		{"//lib:a except //lib:a + //lib:c", []string{"//lib:c"}},
+		{"//lib:a except (//lib:a + //lib:c)", []string{}},
+		{"deps(//cmd:app) ^ deps(//cmd:tool)", []string{"//lib:b", "//lib:c"}},
+		{"//cmd:app union //cmd:tool intersect //cmd:tool", []string{"//cmd:tool"}},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			e, err := Parse(test.query)
+			if err != nil {
+				t.Log(err)
+				t.FailNow()
+			}
+			nodes, err := Eval(newTestGraph(), e)
+			if err != nil {
+				t.Log(err)
+				t.FailNow()
+			}
+			got := []string{}
+			for _, n := range nodes {
+				got = append(got, n.Label.String())
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Logf("was expecting %q got %q instead", test.want, got)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, query := range []string{
+		"deps(//a:b",
+		"deps(//a:b, x)",
+		"deps()",
+		"rdeps(//a:b)",
+		"nope(//a:b)",
+		"//a:b +",
+		"//a:b //a:c",
+		`filter("//a:b)`,
+	} {
+		if _, err := Parse(query); err == nil {
+			t.Logf("%q should not parse", query)
+			t.Fail()
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	nodes, err := Eval(newTestGraph(), &Call{Func: "deps", Args: []Expr{Word("//cmd:tool"), Word("1")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"label": "//cmd:tool\n//lib:b\n",
+		"dot":   "\t\"//cmd:tool\" -> \"//lib:b\";\n",
+		"json": `"deps": [
+      "//lib:c"
+    ]`,
+		"graphml": `<edge source="//cmd:tool" target="//lib:b"></edge>`,
+	}
+	for format, want := range tests {
+		t.Run(format, func(t *testing.T) {
+			buf := bytes.NewBuffer(nil)
+			if err := Write(buf, format, nodes); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(buf.String(), want) {
+				t.Logf("was expecting to find %q in:\n%s", want, buf)
+				t.Fail()
+			}
+		})
+	}
+}
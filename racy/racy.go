@@ -4,6 +4,7 @@ package racy
 import (
 	"crypto/sha512"
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"hash"
 	"io"
@@ -29,12 +30,20 @@ var (
 type Racy struct {
 	hash.Hash
 
-	exts []string
+	exts   []string
+	inputs []Input
 
 	mu *sync.Mutex
 	m  map[string][]byte
 }
 
+// Input is a named value that went in to a hash. Inputs are recorded so
+// we can explain why a hash changed.
+type Input struct {
+	Name  string
+	Value string
+}
+
 type Option func(*Racy)
 
 // New takes nothing and returns a new Racy
@@ -43,6 +52,7 @@ func New(options ...Option) *Racy {
 		NewHash(),
 
 		[]string{}, // by default we'll hash everything checkout `AllowExtension` option to limit the files hashed
+		nil,
 		&sync.Mutex{},
 		make(map[string][]byte),
 	}
@@ -57,12 +67,6 @@ func AllowExtension(ext string) Option {
 	return func(r *Racy) { r.exts = append(r.exts, ext) }
 }
 
-func hashString(s string) []byte {
-	h := NewHash()
-	io.WriteString(h, s)
-	return h.Sum(nil)
-}
-
 func hashFile(file string) []byte {
 CHECK:
 	if sum, ok := hashCache[file]; ok {
@@ -112,10 +116,15 @@ func (r *Racy) hashFile(file string) {
 	if !r.allowedExt(file) {
 		return
 	}
-	r.Write(hashFile(file))
+	sum := hashFile(file)
+	r.record(file, hex.EncodeToString(sum))
+	r.Write(sum)
 }
 
 func (r *Racy) allowedExt(file string) bool {
+	if len(r.exts) == 0 {
+		return true
+	}
 	ext := strings.TrimLeft(filepath.Ext(file), ".")
 	for _, allowedExt := range r.exts {
 		if ext == allowedExt {
@@ -143,10 +152,29 @@ func (r *Racy) HashFiles(files ...string) error {
 // HashStrings hashes strings written to Racy
 func (r *Racy) HashStrings(strs ...string) {
 	for _, str := range strs {
+		r.record("string", str)
+		io.WriteString(r, str)
+	}
+}
+
+// HashNamed hashes the strings written to Racy and records them under name
+func (r *Racy) HashNamed(name string, strs ...string) {
+	for _, str := range strs {
+		r.record(name, str)
 		io.WriteString(r, str)
 	}
 }
 
+// Inputs returns the inputs that were hashed so far in the order they
+// were written.
+func (r *Racy) Inputs() []Input {
+	return r.inputs
+}
+
+func (r *Racy) record(name, value string) {
+	r.inputs = append(r.inputs, Input{Name: name, Value: value})
+}
+
 func (r *Racy) HashSkylarkValues(vals ...skylark.Value) {
 	for _, v := range vals {
 		r.HashSkylarkValue(v)
@@ -154,14 +182,19 @@ func (r *Racy) HashSkylarkValues(vals ...skylark.Value) {
 }
 
 func (r *Racy) HashSkylarkValue(v skylark.Value) {
+	r.record("value", v.String())
+	r.hashSkylarkValue(v)
+}
+
+func (r *Racy) hashSkylarkValue(v skylark.Value) {
 	if iterable, ok := v.(skylark.Iterable); ok {
 		i := iterable.Iterate()
 		var p skylark.Value
 		for i.Next(&p) {
-			r.HashSkylarkValue(p)
+			r.hashSkylarkValue(p)
 		}
 	} else {
-		if h, err := v.Hash(); err != nil {
+		if h, err := v.Hash(); err == nil {
 			b := make([]byte, 4)
 			binary.LittleEndian.PutUint32(b, h)
 			r.Write(b)
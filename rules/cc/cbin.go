@@ -37,20 +37,30 @@ func split(s string, c string) string {
 	return s[i+1:]
 }
 func (cb *CBin) Hash() []byte {
+	return cb.hasher().Sum(nil)
+}
+
+// HashInputs returns the inputs that went in to the hash of the binary.
+func (cb *CBin) HashInputs() []racy.Input {
+	return cb.hasher().Inputs()
+}
+
+func (cb *CBin) hasher() *racy.Racy {
 	r := racy.New(
 		racy.AllowExtension(".h"),
 		racy.AllowExtension(".S"),
 		racy.AllowExtension(".c"),
 	)
 
-	r.HashStrings(CCVersion, cb.Name)
-	r.HashStrings(cb.CCParams()...)
-	r.HashStrings(cb.LDParams()...)
+	r.HashNamed("toolchain", CCVersion)
+	r.HashNamed("name", cb.Name)
+	r.HashNamed("ccparams", cb.CCParams()...)
+	r.HashNamed("ldparams", cb.LDParams()...)
 
 	r.HashFiles(cb.Sources...)
 	r.HashFiles([]string(cb.Includes)...)
 
-	return r.Sum(nil)
+	return r
 }
 
 func (cb *CBin) Build(e *executor.Executor) error {
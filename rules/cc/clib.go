@@ -27,25 +27,35 @@ type CLib struct {
 }
 
 func (cl *CLib) Hash() []byte {
+	return cl.hasher().Sum(nil)
+}
+
+// HashInputs returns the inputs that went in to the hash of the library.
+func (cl *CLib) HashInputs() []racy.Input {
+	return cl.hasher().Inputs()
+}
+
+func (cl *CLib) hasher() *racy.Racy {
 	r := racy.New(
 		racy.AllowExtension(".h"),
 		racy.AllowExtension(".S"),
 		racy.AllowExtension(".c"),
 	)
 
-	r.HashStrings(CCVersion, cl.Name, "clib")
+	r.HashNamed("toolchain", CCVersion)
+	r.HashNamed("name", cl.Name, "clib")
 
 	if cl.LinkStatic {
-		r.HashStrings("static")
+		r.HashNamed("linkstatic", "static")
 	}
 
-	r.HashStrings(cl.CompilerOptions...)
-	r.HashStrings(cl.LinkerOptions...)
+	r.HashNamed("copts", cl.CompilerOptions...)
+	r.HashNamed("linkopts", cl.LinkerOptions...)
 
 	r.HashFiles(cl.Sources...)
 	r.HashFiles([]string(cl.Includes)...)
 
-	return r.Sum(nil)
+	return r
 }
 
 func (cl *CLib) Build(e *executor.Executor) error {
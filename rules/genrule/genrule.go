@@ -0,0 +1,320 @@
+// Copyright 2018 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package genrule implements genrule, a rule that generates files with a
+// shell command.
+// https://docs.bazel.build/versions/master/be/general.html#genrule
+package genrule
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"bldy.build/build"
+	"bldy.build/build/executor"
+	"bldy.build/build/internal"
+	"bldy.build/build/label"
+	"bldy.build/build/racy"
+	"bldy.build/build/workspace"
+)
+
+// shell is the shell cmd is run with.
+const shell = "/bin/sh"
+
+// Genrule runs Cmd in the namespace of the rule to create Outs. Cmd can
+// refer to the files of Srcs and Tools with make variables, which are
+// expanded when the rule is analyzed:
+//
+//	$(location <label>)	the file of a label in srcs, tools or outs
+//	$(locations <label>)	the files of a label in srcs, tools or outs
+//	$(SRCS)	the files of srcs
+//	$(OUTS)	the outputs
+//	$(RULEDIR)	the directory the outputs are created in
+//	$<	the file of srcs if there is only one
+//	$@	the output if there is only one
+//	$$	a $
+type Genrule struct {
+	RuleName string   `genrule:"name"`
+	Srcs     []string `genrule:"srcs"`
+	Outs     []string `genrule:"outs"`
+	Cmd      string   `genrule:"cmd"`
+	Tools    []string `genrule:"tools"`
+	// VisibleTo is the visibility of the rule, it's the default visibility
+	// of the package if it isn't set.
+	VisibleTo []string `genrule:"visibility"`
+
+	label      label.Label
+	ws         workspace.Workspace
+	srcs       []label.Label
+	tools      []label.Label
+	visibility []label.Label
+	// command is Cmd with its make variables expanded, it's set when the
+	// rule is analyzed.
+	command string
+	// links are the files in the workspace cmd refers to, by their paths
+	// in the build directory. They are linked in to it before cmd runs so
+	// cmd doesn't depend on where the workspace is.
+	links map[string]string
+}
+
+func init() {
+	if err := internal.Register("genrule", Genrule{}); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Declare checks the attributes of the rule and resolves the labels in
+// srcs and tools relative to the package it's declared in.
+func (g *Genrule) Declare(lbl label.Label, ws workspace.Workspace) error {
+	g.label, g.ws = lbl, ws
+	if g.Cmd == "" {
+		return fmt.Errorf("%s: cmd can't be empty", lbl)
+	}
+	if len(g.Outs) == 0 {
+		return fmt.Errorf("%s: outs can't be empty", lbl)
+	}
+	seen := make(map[string]bool)
+	for _, o := range g.Outs {
+		if path.IsAbs(o) || o != path.Clean(o) || o == ".." || strings.HasPrefix(o, "../") {
+			return fmt.Errorf("%s: outs: %q has to be a clean path in the output directory", lbl, o)
+		}
+		if seen[o] {
+			return fmt.Errorf("%s: outs: %q is already an output of the rule", lbl, o)
+		}
+		seen[o] = true
+	}
+	var err error
+	if g.srcs, err = g.labels(g.Srcs); err != nil {
+		return fmt.Errorf("%s: srcs: %v", lbl, err)
+	}
+	if g.tools, err = g.labels(g.Tools); err != nil {
+		return fmt.Errorf("%s: tools: %v", lbl, err)
+	}
+	if g.visibility, err = g.labels(g.VisibleTo); err != nil {
+		return fmt.Errorf("%s: visibility: %v", lbl, err)
+	}
+	return nil
+}
+
+// labels parses strs, labels that aren't absolute are in the package of
+// the rule.
+func (g *Genrule) labels(strs []string) ([]label.Label, error) {
+	lbls := []label.Label{}
+	for _, s := range strs {
+		lbl, err := g.parse(s)
+		if err != nil {
+			return nil, err
+		}
+		lbls = append(lbls, lbl)
+	}
+	return lbls, nil
+}
+
+func (g *Genrule) parse(s string) (label.Label, error) {
+	lbl, err := label.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	if err := lbl.Valid(); err != nil {
+		return "", err
+	}
+	if !lbl.IsAbs() && lbl.Repo() == "" {
+		lbl = label.New(g.label.Package(), lbl.Name())
+	}
+	return lbl, nil
+}
+
+// Analyze expands the make variables of cmd with the files of deps.
+func (g *Genrule) Analyze(deps map[label.Label]build.Rule) error {
+	locations := make(map[label.Label][]string)
+	g.links = make(map[string]string)
+	for _, lbl := range g.Dependencies() {
+		dep, ok := deps[lbl]
+		if !ok {
+			return fmt.Errorf("%s: %s wasn't analyzed", g.label, lbl)
+		}
+		for _, f := range files(dep) {
+			locations[lbl] = append(locations[lbl], g.relative(f))
+		}
+	}
+	for _, o := range g.Outs {
+		locations[label.New(g.label.Package(), o)] = []string{o}
+	}
+	command, err := g.expand(locations)
+	if err != nil {
+		return fmt.Errorf("%s: cmd: %v", g.label, err)
+	}
+	g.command = command
+	return nil
+}
+
+// relative returns the path of the file f in the build directory, files in
+// the workspace are linked in to it at their path in the workspace.
+func (g *Genrule) relative(f string) string {
+	if g.ws == nil || !filepath.IsAbs(f) {
+		return f
+	}
+	rel, err := filepath.Rel(g.ws.AbsPath(), f)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return f
+	}
+	g.links[rel] = f
+	return rel
+}
+
+// files returns the files of dep, for most rules they are their outputs.
+func files(dep build.Rule) []string {
+	if f, ok := dep.(build.Files); ok {
+		return f.Files()
+	}
+	return dep.Outputs()
+}
+
+// expand expands the make variables of cmd, locations are the files of
+// the labels cmd can refer to.
+func (g *Genrule) expand(locations map[label.Label][]string) (string, error) {
+	var srcs []string
+	for _, lbl := range g.srcs {
+		srcs = append(srcs, locations[lbl]...)
+	}
+	buf := bytes.NewBuffer(nil)
+	cmd := g.Cmd
+	for i := 0; i < len(cmd); i++ {
+		if cmd[i] != '$' {
+			buf.WriteByte(cmd[i])
+			continue
+		}
+		if i++; i == len(cmd) {
+			return "", fmt.Errorf("it ends with a $, use $$ for a $")
+		}
+		switch cmd[i] {
+		case '$':
+			buf.WriteByte('$')
+		case '@':
+			if len(g.Outs) != 1 {
+				return "", fmt.Errorf("$@ can only be used with one output, there are %d, use $(OUTS)", len(g.Outs))
+			}
+			buf.WriteString(g.Outs[0])
+		case '<':
+			if len(srcs) != 1 {
+				return "", fmt.Errorf("$< can only be used with one source, there are %d, use $(SRCS)", len(srcs))
+			}
+			buf.WriteString(srcs[0])
+		case '(':
+			end := strings.IndexByte(cmd[i:], ')')
+			if end < 0 {
+				return "", fmt.Errorf("$( isn't closed")
+			}
+			v, err := g.variable(cmd[i+1:i+end], srcs, locations)
+			if err != nil {
+				return "", err
+			}
+			buf.WriteString(v)
+			i += end
+		default:
+			return "", fmt.Errorf("$%c isn't a make variable, use $$ for a $", cmd[i])
+		}
+	}
+	return buf.String(), nil
+}
+
+// variable returns the value of the make variable name.
+func (g *Genrule) variable(name string, srcs []string, locations map[label.Label][]string) (string, error) {
+	switch name {
+	case "SRCS":
+		return strings.Join(srcs, " "), nil
+	case "OUTS":
+		return strings.Join(g.Outs, " "), nil
+	case "RULEDIR":
+		// cmd runs in the output directory
+		return ".", nil
+	}
+	fields := strings.Fields(name)
+	if len(fields) != 2 || fields[0] != "location" && fields[0] != "locations" {
+		return "", fmt.Errorf("$(%s) isn't a make variable", name)
+	}
+	lbl, err := g.parse(fields[1])
+	if err != nil {
+		return "", fmt.Errorf("$(%s): %v", name, err)
+	}
+	files, ok := locations[lbl]
+	if !ok {
+		return "", fmt.Errorf("$(%s): %s isn't in srcs, tools or outs", name, lbl)
+	}
+	if fields[0] == "location" && len(files) != 1 {
+		return "", fmt.Errorf("$(%s): %s has %d files, use $(locations)", name, lbl, len(files))
+	}
+	return strings.Join(files, " "), nil
+}
+
+// Build runs the expanded cmd in the namespace of the executor.
+func (g *Genrule) Build(e *executor.Executor) error {
+	if g.command == "" {
+		return fmt.Errorf("%s: hasn't been analyzed", g.label)
+	}
+	for _, o := range g.Outs {
+		if err := e.Mkdir(filepath.Dir(o)); err != nil {
+			return err
+		}
+	}
+	links := []string{}
+	for rel := range g.links {
+		links = append(links, rel)
+	}
+	sort.Strings(links)
+	for _, rel := range links {
+		if err := e.Mkdir(filepath.Dir(rel)); err != nil {
+			return err
+		}
+		if err := e.Symlink(g.links[rel], rel); err != nil {
+			return err
+		}
+	}
+	e.Println(g.command)
+	return e.Exec(shell, nil, []string{"-c", g.command})
+}
+
+func (g *Genrule) Hash() []byte {
+	h := racy.New()
+	h.HashNamed("genrule", g.RuleName)
+	h.HashNamed("cmd", g.Cmd)
+	h.HashNamed("srcs", g.Srcs...)
+	h.HashNamed("outs", g.Outs...)
+	h.HashNamed("tools", g.Tools...)
+	h.HashNamed("visibility", g.VisibleTo...)
+	return h.Sum(nil)
+}
+
+func (g *Genrule) Name() string { return g.RuleName }
+
+// Dependencies returns srcs and tools.
+func (g *Genrule) Dependencies() []label.Label {
+	deps := []label.Label{}
+	seen := make(map[label.Label]bool)
+	for _, lbl := range append(append([]label.Label{}, g.srcs...), g.tools...) {
+		if !seen[lbl] {
+			seen[lbl] = true
+			deps = append(deps, lbl)
+		}
+	}
+	return deps
+}
+
+// Visibility returns the labels the rule is visible to, rules are private
+// unless they or their package say otherwise.
+func (g *Genrule) Visibility() []label.Label {
+	if len(g.visibility) == 0 {
+		return []label.Label{build.PrivateVisibility}
+	}
+	return g.visibility
+}
+
+func (g *Genrule) Outputs() []string              { return g.Outs }
+func (g *Genrule) Platform() label.Label          { return build.DefaultPlatform }
+func (g *Genrule) Workspace() workspace.Workspace { return g.ws }
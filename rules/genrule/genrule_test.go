@@ -0,0 +1,242 @@
+package genrule
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bldy.build/build"
+	"bldy.build/build/executor"
+	"bldy.build/build/label"
+	"bldy.build/build/namespace/host"
+	"bldy.build/build/skylark"
+	"bldy.build/build/workspace"
+)
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  string
+		srcs []string
+		outs []string
+		want string
+		err  string
+	}{
+		{"outs", "echo $(OUTS) > $@", nil, []string{"a.out"}, "echo a.out > a.out", ""},
+		{"srcs", "cat $(SRCS) $< > $(RULEDIR)/a.out", []string{"a.c"}, []string{"a.out"}, "cat /ws/pkg/a.c /ws/pkg/a.c > ./a.out", ""},
+		{"location", "$(location :tool) $(location //pkg:a.c) $(locations :gen)", []string{"a.c", ":gen"}, []string{"a.out"}, "/ws/pkg/tool.sh /ws/pkg/a.c gen.h gen.c", ""},
+		{"location of an output", "touch $(location a.out)", nil, []string{"a.out"}, "touch a.out", ""},
+		{"dollar", "echo $$HOME > $@", nil, []string{"a.out"}, "echo $HOME > a.out", ""},
+		{"many outputs", "touch $@", nil, []string{"a.out", "b.out"}, "", "$@ can only be used with one output"},
+		{"many sources", "cat $<", []string{"a.c", ":gen"}, []string{"a.out"}, "", "$< can only be used with one source"},
+		{"many files", "cat $(location :gen)", []string{":gen"}, []string{"a.out"}, "", "//pkg:gen has 2 files, use $(locations)"},
+		{"not a dependency", "cat $(location :other)", nil, []string{"a.out"}, "", "//pkg:other isn't in srcs, tools or outs"},
+		{"unknown", "echo $(CC)", nil, []string{"a.out"}, "", "$(CC) isn't a make variable"},
+		{"shell variable", "echo $HOME", nil, []string{"a.out"}, "", "$H isn't a make variable, use $$ for a $"},
+		{"unclosed", "echo $(OUTS", nil, []string{"a.out"}, "", "$( isn't closed"},
+	}
+	locations := map[label.Label][]string{
+		"//pkg:a.c":  {"/ws/pkg/a.c"},
+		"//pkg:gen":  {"gen.h", "gen.c"},
+		"//pkg:tool": {"/ws/pkg/tool.sh"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := &Genrule{RuleName: "x", Cmd: test.cmd, Srcs: test.srcs, Outs: test.outs, Tools: []string{":tool"}}
+			if err := g.Declare("//pkg:x", nil); err != nil {
+				t.Fatal(err)
+			}
+			for _, o := range test.outs {
+				locations[label.New("pkg", o)] = []string{o}
+			}
+			got, err := g.expand(locations)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Logf("was expecting %q got %v instead", test.err, err)
+					t.Fail()
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Logf("was expecting %q got %q instead", test.want, got)
+				t.Fail()
+			}
+		})
+	}
+}
+
+// testWorkspace writes files to a new workspace and returns a VM for it.
+func testWorkspace(t *testing.T, files map[string]string) (build.VM, string) {
+	dir, err := ioutil.TempDir("", "bldy_genrule")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files["WORKSPACE"] = ""
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ws, err := workspace.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := skylark.New(ws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vm, dir
+}
+
+func TestGenrule(t *testing.T) {
+	vm, dir := testWorkspace(t, map[string]string{
+		"in.txt": "hello",
+		"BUILD": `genrule(
+    name = "upper",
+    srcs = ["in.txt"],
+    outs = ["upper.txt"],
+    cmd = "tr a-z A-Z < $< > $@",
+)
+`,
+	})
+	defer os.RemoveAll(dir)
+	r, err := vm.GetTarget("//.:upper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps := make(map[label.Label]build.Rule)
+	for _, d := range r.Dependencies() {
+		if deps[d], err = vm.GetTarget(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.(build.Analyzer).Analyze(deps); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.(*Genrule).command; got != "tr a-z A-Z < in.txt > upper.txt" {
+		t.Logf("was expecting the source to be relative to the build directory got %q instead", got)
+		t.Fail()
+	}
+
+	out := filepath.Join(dir, "out")
+	ns, err := host.New(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Build(executor.New(context.Background(), ns)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(out, "upper.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "HELLO" {
+		t.Logf("was expecting HELLO got %q instead", got)
+		t.Fail()
+	}
+}
+
+func TestGenruleVisibility(t *testing.T) {
+	vm, dir := testWorkspace(t, map[string]string{
+		"private/BUILD": `genrule(name = "x", outs = ["x.out"], cmd = "touch $@")`,
+		"explicit/BUILD": `package(default_visibility = ["//visibility:public"])
+genrule(name = "x", outs = ["x.out"], cmd = "touch $@", visibility = [":__pkg__", "//app:__subpackages__"])
+`,
+		"default/BUILD": `package(default_visibility = ["//visibility:public"])
+genrule(name = "x", outs = ["x.out"], cmd = "touch $@")
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		label      label.Label
+		visibility string
+	}{
+		{"//private:x", "[//visibility:private]"},
+		{"//explicit:x", "[//explicit:__pkg__ //app:__subpackages__]"},
+		{"//default:x", "[//visibility:public]"},
+	}
+	for _, test := range tests {
+		t.Run(test.label.Package(), func(t *testing.T) {
+			r, err := vm.GetTarget(test.label)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, ok := r.(build.Visible)
+			if !ok {
+				t.Fatalf("was expecting genrule to have a visibility")
+			}
+			if got := fmt.Sprint(v.Visibility()); got != test.visibility {
+				t.Logf("was expecting %s got %s instead", test.visibility, got)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestGeneratedSrcs(t *testing.T) {
+	vm, dir := testWorkspace(t, map[string]string{
+		"BUILD": `genrule(name = "g", outs = ["g.h"], cmd = "touch $@")
+genrule(name = "u", srcs = ["g.h"], outs = ["u.h"], cmd = "cp $< $@")
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	r, err := vm.GetTarget("//.:u")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps := make(map[label.Label]build.Rule)
+	for _, d := range r.Dependencies() {
+		if deps[d], err = vm.GetTarget(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := fmt.Sprint(deps["//.:g.h"].Dependencies()); got != "[//.:g]" {
+		t.Logf("was expecting g.h to depend on the rule that generates it got %s instead", got)
+		t.Fail()
+	}
+	if err := r.(build.Analyzer).Analyze(deps); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.(*Genrule).command; got != "cp g.h u.h" {
+		t.Logf("was expecting %q got %q instead", "cp g.h u.h", got)
+		t.Fail()
+	}
+}
+
+func TestGenruleAttrs(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs string
+		err   string
+	}{
+		{"select", `srcs = select({"//conditions:default": ["a.txt"]})`, `genrule: attribute "srcs": native rules can't select() their attributes`},
+		{"string list", `srcs = "a.txt"`, `genrule: attribute "srcs": has to be a list of strings, not string`},
+		{"list of strings", `srcs = ["a.txt", 1]`, `genrule: attribute "srcs": has to be a list of strings, not a list with a int in it`},
+		{"string", `cmd = 5`, `genrule: attribute "cmd": has to be a string, not int`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vm, dir := testWorkspace(t, map[string]string{
+				"BUILD": `genrule(name = "x", outs = ["x.out"], ` + test.attrs + `)`,
+			})
+			defer os.RemoveAll(dir)
+			_, err := vm.GetTarget("//.:x")
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Logf("was expecting %q got %v instead", test.err, err)
+				t.Fail()
+			}
+		})
+	}
+}
@@ -2,6 +2,9 @@ package skylark
 
 import (
 	"fmt"
+	"os"
+	"sort"
+	"strings"
 
 	"bldy.build/build/executor"
 	"github.com/google/skylark"
@@ -12,9 +15,9 @@ import (
 type BldyFunc func(thread *skylark.Thread, args skylark.Tuple, kwargs []skylark.Tuple) (skylark.Value, error)
 
 type action struct {
-	name           string
-	a              BldyFunc
-	actionRecorder *actionRecorder
+	name string
+	a    BldyFunc
+	ctx  *context
 }
 
 func (a *action) Name() string          { return a.name }
@@ -30,26 +33,103 @@ func (a *action) Call(thread *skylark.Thread, args skylark.Tuple, kwargs []skyla
 	switch a.name {
 	case "run":
 		i = &run{}
+	case "run_shell":
+		i = &runShell{}
 	case "do_nothing":
 		i = &doNothing{}
+	case "write":
+		i = &write{}
+	case "expand_template":
+		i = &expandTemplate{}
+	case "symlink":
+		i = &symlink{}
+	}
+	// arguments can have Args in them which unpackStruct doesn't know
+	// about, they are expanded here.
+	var cmdArgs []string
+	var paramFiles []paramFile
+	if arguments, ok := findArg(skylark.String("arguments"), kwargs); ok {
+		var err error
+		if cmdArgs, paramFiles, err = commandLine(arguments); err != nil {
+			return skylark.None, errors.Wrap(err, "action.call")
+		}
+		kwargs = withoutArg("arguments", kwargs)
 	}
 	if err := unpackStruct(i, kwargs); err != nil {
 		return skylark.None, errors.Wrap(err, "action.call")
 	}
-	a.actionRecorder.Record(i)
+	switch x := i.(type) {
+	case *run:
+		x.Arguments, x.paramFiles = cmdArgs, paramFiles
+		x.actionEnv = a.ctx.buildConfig().ActionEnv
+	case *runShell:
+		x.Arguments, x.paramFiles = cmdArgs, paramFiles
+		x.actionEnv = a.ctx.buildConfig().ActionEnv
+	default:
+		if cmdArgs != nil {
+			return skylark.None, fmt.Errorf("action.call: %s doesn't take arguments", a.name)
+		}
+	}
+	a.ctx.actionRecorder.Record(i)
 	return skylark.None, nil
 }
 
+// actionKey covers everything an action does, it's what actions are
+// hashed with.
+func actionKey(a executor.Action) string {
+	return fmt.Sprintf("%T %+v", a, a)
+}
+
+// shellEnv returns the environment of an action that runs a command. Actions
+// that use the default shell environment get the environment of the host
+// and the variables set with --action_env, env is set on top of it. It's
+// sorted so it can be part of the key of the action.
+func shellEnv(useDefault bool, actionEnv, env map[string]string) []string {
+	merged := make(map[string]string)
+	if useDefault {
+		for _, kv := range os.Environ() {
+			if i := strings.IndexByte(kv, '='); i > 0 {
+				merged[kv[:i]] = kv[i+1:]
+			}
+		}
+		for k, v := range actionEnv {
+			merged[k] = v
+		}
+	}
+	for k, v := range env {
+		merged[k] = v
+	}
+	vars := []string{}
+	for k, v := range merged {
+		vars = append(vars, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(vars)
+	return vars
+}
+
+// withoutArg returns kwargs without the argument name.
+func withoutArg(name string, kwargs []skylark.Tuple) []skylark.Tuple {
+	without := []skylark.Tuple{}
+	for _, kwarg := range kwargs {
+		if s, ok := skylark.AsString(kwarg[0]); ok && s == name {
+			continue
+		}
+		without = append(without, kwarg)
+	}
+	return without
+}
+
 type actionRecorder struct{ calls []executor.Action }
 
 func (ar *actionRecorder) Record(a executor.Action) {
 	ar.calls = append(ar.calls, a)
 }
 
-// newAction retunrs a new action that will be called during building
-func newAction(name string, ac *actionRecorder) *action {
+// newAction retunrs a new action that will be called during building, it's
+// recorded by the action recorder of ctx.
+func newAction(name string, ctx *context) *action {
 	return &action{
-		name:           name,
-		actionRecorder: ac,
+		name: name,
+		ctx:  ctx,
 	}
 }
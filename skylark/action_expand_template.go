@@ -0,0 +1,59 @@
+package skylark
+
+import (
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"bldy.build/build/executor"
+)
+
+// expandTemplate represents a ctx.actions.expand_template functions in bazel land.
+// https://docs.bazel.build/versions/master/skylark/lib/actions.html#expand_template
+type expandTemplate struct {
+	Template      string            // The template file.
+	Output        string            // The output file.
+	Substitutions map[string]string // Substitutions to make when expanding the template.
+	IsExecutable  bool              // Whether the output file should be executable.
+}
+
+func (t *expandTemplate) Describe() executor.Spec {
+	return executor.Spec{
+		Inputs:  []string{t.Template},
+		Outputs: []string{t.Output},
+		Key:     actionKey(t),
+	}
+}
+
+func (t *expandTemplate) Do(e *executor.Executor) error {
+	f, err := e.Open(t.Template)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tmpl, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	return writeOutput(e, t.Output, t.expand(string(tmpl)), t.IsExecutable)
+}
+
+// expand makes the substitutions in tmpl, longer keys are replaced first so
+// a key that's a prefix of another doesn't replace part of it.
+func (t *expandTemplate) expand(tmpl string) string {
+	keys := []string{}
+	for k := range t.Substitutions {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	oldnew := []string{}
+	for _, k := range keys {
+		oldnew = append(oldnew, k, t.Substitutions[k])
+	}
+	return strings.NewReplacer(oldnew...).Replace(tmpl)
+}
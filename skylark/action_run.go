@@ -2,7 +2,8 @@ package skylark
 
 import (
 	"fmt"
-	"os"
+	"path/filepath"
+	"strings"
 
 	"bldy.build/build/executor"
 )
@@ -11,6 +12,7 @@ import (
 // https://docs.bazel.build/versions/master/skylark/lib/actions.html#run
 type run struct {
 	Outputs               []string          // List of the output files of the action.
+	Inputs                []string          // List of the input files of the action.
 	Files                 []string          // List of the input files of the action.
 	Executable            string            // The executable file to be called by the action.
 	Arguments             []string          // Command line arguments of the action. Must be a list of strings or actions.args() objects.
@@ -19,16 +21,34 @@ type run struct {
 	UseDefaultShellEnv    bool              // Whether the action should use the built in shell environment or not.
 	Env                   map[string]string // Sets the dictionary of environment variables.
 	ExecutionRequirements map[string]string // Information for scheduling the action. See tags for useful keys.
+
+	paramFiles []paramFile
+	// actionEnv is the environment set with --action_env.
+	actionEnv map[string]string
 }
 
-func (r *run) Do(e *executor.Executor) error {
-	env := os.Environ()
-	if !r.UseDefaultShellEnv {
-		env = []string{}
+func (r *run) Describe() executor.Spec {
+	inputs := append(append([]string{}, r.Inputs...), r.Files...)
+	if !filepath.IsAbs(r.Executable) && strings.Contains(r.Executable, "/") {
+		// the executable was built, executables on the host aren't
+		// hashed.
+		inputs = append(inputs, r.Executable)
 	}
-	for k, v := range r.Env {
-		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	_, noCache := r.ExecutionRequirements["no-cache"]
+	return executor.Spec{
+		Inputs:  inputs,
+		Outputs: r.Outputs,
+		Key:     fmt.Sprintf("%s %q", actionKey(r), r.env()),
+		Cache:   !noCache,
+	}
+}
+
+func (r *run) Do(e *executor.Executor) error {
+	if err := writeParamFiles(e, r.paramFiles); err != nil {
+		return err
 	}
 	e.Println(r.ProgressMessage)
-	return e.Exec(r.Executable, env, r.Arguments)
+	return e.Exec(r.Executable, r.env(), r.Arguments)
 }
+
+func (r *run) env() []string { return shellEnv(r.UseDefaultShellEnv, r.actionEnv, r.Env) }
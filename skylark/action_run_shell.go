@@ -0,0 +1,71 @@
+package skylark
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"bldy.build/build/executor"
+)
+
+// runShell represents a ctx.actions.run_shell functions in bazel land.
+// https://docs.bazel.build/versions/master/skylark/lib/actions.html#run_shell
+type runShell struct {
+	Outputs               []string          // List of the output files of the action.
+	Inputs                []string          // List of the input files of the action.
+	Command               string            // Shell command to execute, arguments are available to it as $1, $2...
+	Arguments             []string          // Command line arguments of the action. Must be a list of strings or actions.args() objects.
+	Mnemonic              string            // A one-word description of the action, for example, CppCompile or GoLink.
+	ProgressMessage       string            // Progress message to show to the user during the build, for example, "Compiling foo.cc to create foo.o".
+	UseDefaultShellEnv    bool              // Whether the action should use the built in shell environment or not.
+	Env                   map[string]string // Sets the dictionary of environment variables.
+	ExecutionRequirements map[string]string // Information for scheduling the action. See tags for useful keys.
+
+	paramFiles []paramFile
+	// actionEnv is the environment set with --action_env.
+	actionEnv map[string]string
+}
+
+// shell is the shell run_shell commands are run with.
+const shell = "/bin/sh"
+
+func (r *runShell) Describe() executor.Spec {
+	_, noCache := r.ExecutionRequirements["no-cache"]
+	return executor.Spec{
+		Inputs:  r.Inputs,
+		Outputs: r.Outputs,
+		Key:     fmt.Sprintf("%s %q", actionKey(r), r.env()),
+		Cache:   !noCache,
+	}
+}
+
+func (r *runShell) Do(e *executor.Executor) error {
+	if err := writeParamFiles(e, r.paramFiles); err != nil {
+		return err
+	}
+	e.Println(r.ProgressMessage)
+	// the shell is $0 so the arguments start at $1
+	return e.Exec(shell, r.env(), append([]string{"-c", r.Command, shell}, r.Arguments...))
+}
+
+func (r *runShell) env() []string { return shellEnv(r.UseDefaultShellEnv, r.actionEnv, r.Env) }
+
+// paramFileSeq numbers the temporary files param files are written to.
+var paramFileSeq uint64
+
+// writeParamFiles writes the param files of an action. Param files are named
+// after their contents and actions that share them can run at the same
+// time, so they are written to a temporary file first and renamed in to
+// place, the action reading a param file never sees it half written.
+func writeParamFiles(e *executor.Executor, paramFiles []paramFile) error {
+	for _, pf := range paramFiles {
+		tmp := fmt.Sprintf("%s.%d.%d.tmp", pf.Path, os.Getpid(), atomic.AddUint64(&paramFileSeq, 1))
+		if err := writeOutput(e, tmp, pf.Content, false); err != nil {
+			return err
+		}
+		if err := e.Rename(tmp, pf.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
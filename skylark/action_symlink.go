@@ -0,0 +1,66 @@
+package skylark
+
+import (
+	"errors"
+	"path/filepath"
+
+	"bldy.build/build/executor"
+)
+
+// symlink represents a ctx.actions.symlink functions in bazel land.
+// https://docs.bazel.build/versions/master/skylark/lib/actions.html#symlink
+type symlink struct {
+	Output          string // The output of this action.
+	TargetFile      string // The file that the output symlink will point to.
+	TargetPath      string // The exact path that the output symlink will point to, it isn't normalized.
+	IsExecutable    bool   // Whether the target file has to be executable.
+	ProgressMessage string // Progress message to show to the user during the build.
+}
+
+func (s *symlink) Describe() executor.Spec {
+	spec := executor.Spec{Outputs: []string{s.Output}, Key: actionKey(s)}
+	if s.TargetFile != "" {
+		spec.Inputs = []string{s.TargetFile}
+	}
+	return spec
+}
+
+func (s *symlink) Do(e *executor.Executor) error {
+	if (s.TargetFile == "") == (s.TargetPath == "") {
+		return errors.New("symlink: exactly one of target_file or target_path has to be set")
+	}
+	if s.ProgressMessage != "" {
+		e.Println(s.ProgressMessage)
+	}
+	target := s.TargetPath
+	if s.TargetFile != "" {
+		target = s.TargetFile
+		if s.IsExecutable {
+			f, err := e.Open(target)
+			if err != nil {
+				return err
+			}
+			fi, err := f.Stat()
+			f.Close()
+			if err != nil {
+				return err
+			}
+			if fi.Mode()&0111 == 0 {
+				return errors.New("symlink: " + target + " isn't executable")
+			}
+		}
+		if !filepath.IsAbs(target) {
+			// outputs are relative to the output directory, the link
+			// is relative to the directory it's in.
+			rel, err := filepath.Rel(filepath.Dir(s.Output), target)
+			if err != nil {
+				return err
+			}
+			target = rel
+		}
+	}
+	if err := e.Mkdir(filepath.Dir(s.Output)); err != nil {
+		return err
+	}
+	return e.Symlink(target, s.Output)
+}
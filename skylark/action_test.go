@@ -0,0 +1,256 @@
+package skylark
+
+import (
+	"bytes"
+	gocontext "context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"bldy.build/build"
+	"bldy.build/build/executor"
+	"bldy.build/build/label"
+	"bldy.build/build/namespace/host"
+)
+
+const testFileActions = `
+def _impl(ctx):
+    ctx.actions.write(
+        output = ctx.outputs.header,
+        content = "#define VERSION \"{}\"\n".format(ctx.attrs.version),
+    )
+    ctx.actions.write(
+        output = ctx.outputs.script,
+        content = "#!/bin/sh\necho VERSION\n",
+        is_executable = True,
+    )
+    ctx.actions.expand_template(
+        template = ctx.outputs.script,
+        output = ctx.outputs.expanded,
+        substitutions = {"VERSION": str(ctx.attrs.version)},
+        is_executable = True,
+    )
+    ctx.actions.symlink(
+        output = ctx.outputs.link,
+        target_file = ctx.outputs.expanded,
+        is_executable = True,
+    )
+
+version = rule(
+    attrs = {"version": attr.int()},
+    outputs = {
+        "header": "include/%{name}.h",
+        "script": "bin/%{name}.sh.in",
+        "expanded": "bin/%{name}.sh",
+        "link": "%{name}",
+    },
+    implementation = _impl,
+)
+`
+
+func TestFileActions(t *testing.T) {
+	build := func(version string) (string, []byte) {
+		vm := testWorkspace(t, map[string]string{
+			"defs.sky": testFileActions,
+			"BUILD":    `load("defs.sky", "version")` + "\n" + `version(name = "v", version = ` + version + `)`,
+		})
+		defer os.RemoveAll(vm.ws.AbsPath())
+		r, err := analyze(vm, "//.:v")
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir, err := ioutil.TempDir("", "bldy_actions")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ns, err := host.New(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Build(executor.New(gocontext.Background(), ns)); err != nil {
+			t.Fatal(err)
+		}
+		return dir, r.Hash()
+	}
+
+	dir, hash := build("1")
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		path       string
+		content    string
+		executable bool
+	}{
+		{"include/v.h", "#define VERSION \"1\"\n", false},
+		{"bin/v.sh.in", "#!/bin/sh\necho VERSION\n", true},
+		{"bin/v.sh", "#!/bin/sh\necho 1\n", true},
+		{"v", "#!/bin/sh\necho 1\n", true},
+	}
+	for _, test := range tests {
+		bytz, err := ioutil.ReadFile(filepath.Join(dir, test.path))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(bytz) != test.content {
+			t.Logf("was expecting %s to be %q got %q instead", test.path, test.content, bytz)
+			t.Fail()
+		}
+		fi, _ := os.Stat(filepath.Join(dir, test.path))
+		if executable := fi.Mode()&0100 != 0; executable != test.executable {
+			t.Logf("was expecting %s to be executable=%t got %t instead", test.path, test.executable, executable)
+			t.Fail()
+		}
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "v")); err != nil || target != "bin/v.sh" {
+		t.Logf("was expecting v to link to bin/v.sh got %q instead", target)
+		t.Fail()
+	}
+
+	otherDir, otherHash := build("2")
+	defer os.RemoveAll(otherDir)
+	if bytes.Equal(hash, otherHash) {
+		t.Log("was expecting a different version to change the hash of the rule")
+		t.Fail()
+	}
+}
+
+func TestParamFilesConcurrently(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bldy_params")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ns, err := host.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := executor.New(gocontext.Background(), ns)
+	pf := paramFile{Path: "params/shared.params", Content: strings.Repeat("an argument\n", 1<<12)}
+
+	// actions that share the param file write it while others read it
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if err := writeParamFiles(e, []paramFile{pf}); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				bytz, err := ioutil.ReadFile(filepath.Join(dir, pf.Path))
+				if os.IsNotExist(err) {
+					continue
+				}
+				if string(bytz) != pf.Content {
+					t.Errorf("was expecting the whole param file got %d bytes instead", len(bytz))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "params"))
+	if len(files) != 1 {
+		t.Logf("was expecting only the param file got %d files instead", len(files))
+		t.Fail()
+	}
+}
+
+func TestShellEnvKey(t *testing.T) {
+	defer os.Unsetenv("BLDY_TEST_ENV")
+	key := func(r *runShell) string {
+		os.Setenv("BLDY_TEST_ENV", "1")
+		before := r.Describe().Key
+		os.Setenv("BLDY_TEST_ENV", "2")
+		if after := r.Describe().Key; after != before {
+			return "changed"
+		}
+		return "same"
+	}
+	if got := key(&runShell{Command: "true", UseDefaultShellEnv: true}); got != "changed" {
+		t.Log("was expecting the host environment to be part of the key of actions that use it")
+		t.Fail()
+	}
+	if got := key(&runShell{Command: "true"}); got != "same" {
+		t.Log("was expecting the host environment not to be part of the key of actions that don't use it")
+		t.Fail()
+	}
+}
+
+const testActionEnv = `
+def _impl(ctx):
+    out = ctx.actions.declare_file(ctx.attrs.name + ".txt")
+    ctx.actions.run_shell(
+        outputs = [out],
+        command = "echo \"$BLDY_ACTION_ENV $BLDY_ENV\" > $1",
+        arguments = [out.path],
+        use_default_shell_env = ctx.attrs.default_env,
+        env = {"BLDY_ENV": "set"},
+    )
+    return [DefaultInfo(files = depset([out]))]
+
+show_env = rule(
+    attrs = {"default_env": attr.bool()},
+    implementation = _impl,
+)
+`
+
+func TestActionEnv(t *testing.T) {
+	config := build.DefaultConfig()
+	config.ActionEnv["BLDY_ACTION_ENV"] = "from-flag"
+	tests := []struct {
+		name string
+		out  string
+	}{
+		{"default", "from-flag set\n"},
+		{"own", " set\n"},
+	}
+	vm := testWorkspace(t, map[string]string{
+		"defs.sky": testActionEnv,
+		"BUILD": `load("defs.sky", "show_env")
+show_env(name = "default", default_env = True)
+show_env(name = "own", default_env = False)
+`,
+	})
+	defer os.RemoveAll(vm.ws.AbsPath())
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := configure(vm, label.New(".", test.name), config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Analyze(nil); err != nil {
+				t.Fatal(err)
+			}
+			dir, err := ioutil.TempDir("", "bldy_env")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			ns, err := host.New(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Build(executor.New(gocontext.Background(), ns)); err != nil {
+				t.Fatal(err)
+			}
+			bytz, err := ioutil.ReadFile(filepath.Join(dir, test.name+".txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(bytz) != test.out {
+				t.Logf("was expecting %q got %q instead", test.out, bytz)
+				t.Fail()
+			}
+		})
+	}
+}
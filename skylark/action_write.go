@@ -0,0 +1,46 @@
+package skylark
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"bldy.build/build/executor"
+)
+
+// write represents a ctx.actions.write functions in bazel land.
+// https://docs.bazel.build/versions/master/skylark/lib/actions.html#write
+type write struct {
+	Output       string // The output file.
+	Content      string // The contents of the file.
+	IsExecutable bool   // Whether the output file should be executable.
+}
+
+func (w *write) Describe() executor.Spec {
+	return executor.Spec{Outputs: []string{w.Output}, Key: actionKey(w)}
+}
+
+func (w *write) Do(e *executor.Executor) error {
+	return writeOutput(e, w.Output, w.Content, w.IsExecutable)
+}
+
+// writeOutput creates the file name in the executors namespace with
+// content.
+func writeOutput(e *executor.Executor, name, content string, executable bool) error {
+	if err := e.Mkdir(filepath.Dir(name)); err != nil {
+		return err
+	}
+	perm := os.FileMode(0644)
+	if executable {
+		perm = 0755
+	}
+	f, err := e.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,377 @@
+package skylark
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/google/skylark"
+)
+
+// paramFileThreshold is how long a command line can get before args that
+// use param files are written to one. It's well below ARG_MAX, and below
+// the length linux allows for a single argument.
+var paramFileThreshold = 64 << 10
+
+// Args is a command line that's built up by rule implementations.
+// Values are expanded as they are added, map_each is called right away.
+// https://docs.bazel.build/versions/master/skylark/lib/Args.html
+type Args struct {
+	args []string
+
+	paramFileArg    string
+	paramFileFormat string
+	useAlways       bool
+	frozen          bool
+}
+
+// paramFile is a file the arguments of an action are written to when they
+// are too long to be passed on the command line.
+type paramFile struct {
+	Path    string
+	Content string
+}
+
+func newArgs(thread *skylark.Thread, fn *skylark.Builtin, args skylark.Tuple, kwargs []skylark.Tuple) (skylark.Value, error) {
+	if err := skylark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	return &Args{paramFileFormat: "shell"}, nil
+}
+
+func (a *Args) Freeze()               { a.frozen = true }
+func (a *Args) Truth() skylark.Bool   { return true }
+func (a *Args) Type() string          { return "Args" }
+func (a *Args) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: Args") }
+func (a *Args) String() string        { return fmt.Sprintf("Args(%q)", a.args) }
+
+var argsMethods = map[string]func(*Args, *skylark.Thread, *skylark.Builtin, skylark.Tuple, []skylark.Tuple) error{
+	"add":                   (*Args).add,
+	"add_all":               (*Args).addAll,
+	"add_joined":            (*Args).addJoined,
+	"use_param_file":        (*Args).useParamFile,
+	"set_param_file_format": (*Args).setParamFileFormat,
+}
+
+func (a *Args) Attr(name string) (skylark.Value, error) {
+	method, ok := argsMethods[name]
+	if !ok {
+		return nil, nil
+	}
+	return skylark.NewBuiltin(name, func(thread *skylark.Thread, fn *skylark.Builtin, args skylark.Tuple, kwargs []skylark.Tuple) (skylark.Value, error) {
+		if a.frozen {
+			return nil, fmt.Errorf("%s: can't change frozen Args", fn.Name())
+		}
+		if err := method(a, thread, fn, args, kwargs); err != nil {
+			return nil, err
+		}
+		// methods return the args so calls can be chained
+		return a, nil
+	}).BindReceiver(a), nil
+}
+
+func (a *Args) AttrNames() []string {
+	return []string{"add", "add_all", "add_joined", "set_param_file_format", "use_param_file"}
+}
+
+// https://docs.bazel.build/versions/master/skylark/lib/Args.html#add
+func (a *Args) add(thread *skylark.Thread, fn *skylark.Builtin, args skylark.Tuple, kwargs []skylark.Tuple) error {
+	var nameOrValue, value skylark.Value
+	var format string
+	if err := skylark.UnpackArgs(fn.Name(), args, kwargs, "arg_name_or_value", &nameOrValue, "value?", &value, "format?", &format); err != nil {
+		return err
+	}
+	if value != nil {
+		a.args = append(a.args, argString(nameOrValue))
+	} else {
+		value = nameOrValue
+	}
+	arg := argString(value)
+	if format != "" {
+		var err error
+		if arg, err = formatArg(fn.Name(), format, arg); err != nil {
+			return err
+		}
+	}
+	a.args = append(a.args, arg)
+	return nil
+}
+
+// https://docs.bazel.build/versions/master/skylark/lib/Args.html#add_all
+func (a *Args) addAll(thread *skylark.Thread, fn *skylark.Builtin, args skylark.Tuple, kwargs []skylark.Tuple) error {
+	var nameOrValues, values, mapEach skylark.Value
+	var formatEach, beforeEach, terminateWith string
+	omitIfEmpty, uniquify := true, false
+	if err := skylark.UnpackArgs(fn.Name(), args, kwargs,
+		"arg_name_or_values", &nameOrValues,
+		"values?", &values,
+		"map_each?", &mapEach,
+		"format_each?", &formatEach,
+		"before_each?", &beforeEach,
+		"omit_if_empty?", &omitIfEmpty,
+		"uniquify?", &uniquify,
+		"terminate_with?", &terminateWith,
+	); err != nil {
+		return err
+	}
+	name, list, err := expandValues(thread, fn, nameOrValues, values, mapEach, formatEach, uniquify)
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 && omitIfEmpty {
+		return nil
+	}
+	if name != "" {
+		a.args = append(a.args, name)
+	}
+	for _, arg := range list {
+		if beforeEach != "" {
+			a.args = append(a.args, beforeEach)
+		}
+		a.args = append(a.args, arg)
+	}
+	if terminateWith != "" {
+		a.args = append(a.args, terminateWith)
+	}
+	return nil
+}
+
+// https://docs.bazel.build/versions/master/skylark/lib/Args.html#add_joined
+func (a *Args) addJoined(thread *skylark.Thread, fn *skylark.Builtin, args skylark.Tuple, kwargs []skylark.Tuple) error {
+	var nameOrValues, values, mapEach skylark.Value
+	var formatEach, formatJoined string
+	joinWith := skylark.Value(nil)
+	omitIfEmpty, uniquify := true, false
+	// join_with is required but parameters after an optional one are
+	// optional too, so it's checked below.
+	if err := skylark.UnpackArgs(fn.Name(), args, kwargs,
+		"arg_name_or_values", &nameOrValues,
+		"values?", &values,
+		"join_with?", &joinWith,
+		"map_each?", &mapEach,
+		"format_each?", &formatEach,
+		"format_joined?", &formatJoined,
+		"omit_if_empty?", &omitIfEmpty,
+		"uniquify?", &uniquify,
+	); err != nil {
+		return err
+	}
+	sep, ok := skylark.AsString(joinWith)
+	if !ok {
+		return fmt.Errorf("%s: join_with has to be a string", fn.Name())
+	}
+	name, list, err := expandValues(thread, fn, nameOrValues, values, mapEach, formatEach, uniquify)
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 && omitIfEmpty {
+		return nil
+	}
+	joined := strings.Join(list, sep)
+	if formatJoined != "" {
+		if joined, err = formatArg(fn.Name(), formatJoined, joined); err != nil {
+			return err
+		}
+	}
+	if name != "" {
+		a.args = append(a.args, name)
+	}
+	a.args = append(a.args, joined)
+	return nil
+}
+
+// https://docs.bazel.build/versions/master/skylark/lib/Args.html#use_param_file
+func (a *Args) useParamFile(thread *skylark.Thread, fn *skylark.Builtin, args skylark.Tuple, kwargs []skylark.Tuple) error {
+	var paramFileArg string
+	useAlways := false
+	if err := skylark.UnpackArgs(fn.Name(), args, kwargs, "param_file_arg", &paramFileArg, "use_always?", &useAlways); err != nil {
+		return err
+	}
+	if strings.Count(paramFileArg, "%s") != 1 {
+		return fmt.Errorf("%s: param_file_arg has to contain exactly one %%s, got %q", fn.Name(), paramFileArg)
+	}
+	a.paramFileArg = paramFileArg
+	a.useAlways = useAlways
+	return nil
+}
+
+// https://docs.bazel.build/versions/master/skylark/lib/Args.html#set_param_file_format
+func (a *Args) setParamFileFormat(thread *skylark.Thread, fn *skylark.Builtin, args skylark.Tuple, kwargs []skylark.Tuple) error {
+	var format string
+	if err := skylark.UnpackArgs(fn.Name(), args, kwargs, "format", &format); err != nil {
+		return err
+	}
+	switch format {
+	case "shell", "multiline":
+	default:
+		return fmt.Errorf("%s: unknown param file format %q", fn.Name(), format)
+	}
+	a.paramFileFormat = format
+	return nil
+}
+
+// expandValues returns the argument name, if there's one, and the
+// formatted values of the arguments of add_all and add_joined.
+func expandValues(thread *skylark.Thread, fn *skylark.Builtin, nameOrValues, values, mapEach skylark.Value, formatEach string, uniquify bool) (string, []string, error) {
+	name := ""
+	if values != nil {
+		name = argString(nameOrValues)
+	} else {
+		values = nameOrValues
+	}
+	var elems []skylark.Value
+	switch v := values.(type) {
+	case *Depset:
+		elems = v.ToList()
+	case skylark.Sequence:
+		iter := v.Iterate()
+		var x skylark.Value
+		for iter.Next(&x) {
+			elems = append(elems, x)
+		}
+		iter.Done()
+	default:
+		return "", nil, fmt.Errorf("%s: values have to be a list or a depset, not %s", fn.Name(), values.Type())
+	}
+	var mapFn skylark.Callable
+	if mapEach != nil && mapEach != skylark.None {
+		var ok bool
+		if mapFn, ok = mapEach.(skylark.Callable); !ok {
+			return "", nil, fmt.Errorf("%s: map_each has to be a function, not %s", fn.Name(), mapEach.Type())
+		}
+	}
+	list := []string{}
+	seen := make(map[string]bool)
+	for _, elem := range elems {
+		mapped := []skylark.Value{elem}
+		if mapFn != nil {
+			ret, err := mapFn.Call(thread, skylark.Tuple{elem}, nil)
+			if err != nil {
+				return "", nil, fmt.Errorf("%s: map_each: %v", fn.Name(), err)
+			}
+			// map_each can return None to skip a value, or a list to
+			// expand it to many.
+			switch ret := ret.(type) {
+			case skylark.NoneType:
+				mapped = nil
+			case *skylark.List:
+				mapped = mapped[:0]
+				for i := 0; i < ret.Len(); i++ {
+					mapped = append(mapped, ret.Index(i))
+				}
+			default:
+				mapped = []skylark.Value{ret}
+			}
+		}
+		for _, m := range mapped {
+			arg := argString(m)
+			if formatEach != "" {
+				var err error
+				if arg, err = formatArg(fn.Name(), formatEach, arg); err != nil {
+					return "", nil, err
+				}
+			}
+			if uniquify {
+				if seen[arg] {
+					continue
+				}
+				seen[arg] = true
+			}
+			list = append(list, arg)
+		}
+	}
+	return name, list, nil
+}
+
+// argString returns the command line argument for v, files are passed as
+// their paths.
+func argString(v skylark.Value) string {
+	switch v := v.(type) {
+	case skylark.String:
+		return string(v)
+	case interface{ Path() string }:
+		return v.Path()
+	}
+	return v.String()
+}
+
+func formatArg(fn, format, arg string) (string, error) {
+	if strings.Count(format, "%s") != 1 {
+		return "", fmt.Errorf("%s: format has to contain exactly one %%s, got %q", fn, format)
+	}
+	return strings.Replace(format, "%s", arg, 1), nil
+}
+
+// commandLine expands the arguments of an action, which can be strings or
+// Args. Args that use param files and are too long, or always use them,
+// are written to param files which are returned along with the arguments.
+func commandLine(arguments skylark.Value) ([]string, []paramFile, error) {
+	seq, ok := arguments.(skylark.Sequence)
+	if !ok {
+		return nil, nil, fmt.Errorf("arguments have to be a list, not %s", arguments.Type())
+	}
+	var values []skylark.Value
+	iter := seq.Iterate()
+	var v skylark.Value
+	for iter.Next(&v) {
+		values = append(values, v)
+	}
+	iter.Done()
+
+	length := 0
+	for _, v := range values {
+		if a, ok := v.(*Args); ok {
+			for _, arg := range a.args {
+				length += len(arg) + 1
+			}
+		} else {
+			length += len(argString(v)) + 1
+		}
+	}
+
+	var args []string
+	var paramFiles []paramFile
+	for _, v := range values {
+		a, ok := v.(*Args)
+		switch {
+		case !ok:
+			if _, ok := v.(skylark.String); !ok {
+				return nil, nil, fmt.Errorf("arguments have to be strings or Args, not %s", v.Type())
+			}
+			args = append(args, argString(v))
+		case a.paramFileArg != "" && (a.useAlways || length > paramFileThreshold):
+			pf := a.paramFile()
+			paramFiles = append(paramFiles, pf)
+			args = append(args, strings.Replace(a.paramFileArg, "%s", pf.Path, 1))
+		default:
+			args = append(args, a.args...)
+		}
+	}
+	return args, paramFiles, nil
+}
+
+// paramFile returns the param file for the args, it's named after its
+// contents so actions that share param files don't overwrite each other's.
+func (a *Args) paramFile() paramFile {
+	buf := bytes.NewBuffer(nil)
+	for _, arg := range a.args {
+		if a.paramFileFormat == "shell" {
+			arg = shellQuote(arg)
+		}
+		buf.WriteString(arg)
+		buf.WriteByte('\n')
+	}
+	return paramFile{
+		Path:    path.Join("params", fmt.Sprintf("%x.params", sha1.Sum(buf.Bytes()))),
+		Content: buf.String(),
+	}
+}
+
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789@%_-+=:,./") == "" {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,182 @@
+package skylark
+
+import (
+	gocontext "context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bldy.build/build/executor"
+	"bldy.build/build/namespace/host"
+	"github.com/google/skylark"
+)
+
+func execArgs(src string) (skylark.StringDict, error) {
+	globals := skylark.StringDict{
+		"args":   skylark.NewBuiltin("args", newArgs),
+		"depset": skylark.NewBuiltin("depset", depset),
+	}
+	return skylark.ExecFile(&skylark.Thread{}, "args.sky", src, globals)
+}
+
+func TestArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"add", `a.add("-v").add("-o", "out").add("--level", 2, format = "-O%s")`, "-v -o out --level -O2"},
+		{"add_all", `a.add_all("-I", ["a", "b"], before_each = "-isystem", format_each = "%s/include")`, "-I -isystem a/include -isystem b/include"},
+		{"add_all omit", `a.add_all("-I", [])`, ""},
+		{"add_all keep", `a.add_all("-I", [], omit_if_empty = False, terminate_with = "--")`, "-I --"},
+		{"add_all map_each", `
+def lib(x):
+    if x.startswith("lib"):
+        return "-l" + x[3:-2]
+    return None
+
+a.add_all(["liba.a", "b.o"], map_each = lib)`, "-la"},
+		{"add_all depset", `a.add_all(depset(["a", "b"], transitive = [depset(["a", "c"])]), uniquify = True)`, "a c b"},
+		{"add_joined", `a.add_joined("-L", ["x", "y"], join_with = ":", format_joined = "[%s]")`, "-L [x:y]"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			globals, err := execArgs("a = args()\n" + test.src + "\n")
+			if err != nil {
+				t.Fatal(err)
+			}
+			args, _, err := commandLine(skylark.NewList([]skylark.Value{globals["a"]}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(args, " "); got != test.want {
+				t.Logf("was expecting %q got %q instead", test.want, got)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestArgsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{"format", `a.add("x", format = "%s%s")`, "exactly one %s"},
+		{"param file", `a.use_param_file("@")`, "exactly one %s"},
+		{"values", `a.add_all(1)`, "values have to be a list or a depset"},
+		{"join", `a.add_joined(["x"])`, "join_with has to be a string"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := execArgs("a = args()\n" + test.src + "\n"); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Logf("was expecting %q got %v instead", test.err, err)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestParamFile(t *testing.T) {
+	defer func(threshold int) { paramFileThreshold = threshold }(paramFileThreshold)
+	paramFileThreshold = 32
+
+	globals, err := execArgs(`
+short = args()
+short.use_param_file("@%s")
+short.add("x")
+
+long = args()
+long.use_param_file("@%s")
+long.add_all(["a file with spaces", "it's"] + ["{}.o".format(i) for i in range(10)])
+
+always = args()
+always.use_param_file("--flagfile=%s", use_always = True)
+always.set_param_file_format("multiline")
+always.add("-v")
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args, paramFiles, err := commandLine(skylark.NewList([]skylark.Value{skylark.String("cmd"), globals["short"]}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paramFiles) != 0 || strings.Join(args, " ") != "cmd x" {
+		t.Logf("was expecting short command lines to be passed as is got %q and %d param files instead", args, len(paramFiles))
+		t.Fail()
+	}
+
+	args, paramFiles, err = commandLine(skylark.NewList([]skylark.Value{globals["long"], globals["always"]}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paramFiles) != 2 || len(args) != 2 {
+		t.Fatalf("was expecting 2 param files got %q instead", args)
+	}
+	if args[0] != "@"+paramFiles[0].Path || args[1] != "--flagfile="+paramFiles[1].Path {
+		t.Logf("was expecting the param file args to point to the param files got %q instead", args)
+		t.Fail()
+	}
+	if !strings.HasPrefix(paramFiles[0].Content, "'a file with spaces'\n'it'\\''s'\n0.o\n") {
+		t.Logf("was expecting shell quoted arguments got %q instead", paramFiles[0].Content)
+		t.Fail()
+	}
+	if paramFiles[1].Content != "-v\n" {
+		t.Logf("was expecting one argument per line got %q instead", paramFiles[1].Content)
+		t.Fail()
+	}
+}
+
+const testRunShell = `
+def _impl(ctx):
+    args = ctx.actions.args()
+    args.use_param_file("%s", use_always = True)
+    args.add_all(["a", "b"])
+    ctx.actions.run_shell(
+        outputs = [ctx.outputs.out],
+        command = "mkdir -p $(dirname $1) && cat $2 > $1",
+        arguments = [ctx.outputs.out.path, args],
+    )
+
+shell = rule(
+    attrs = {},
+    outputs = {"out": "out/%{name}.txt"},
+    implementation = _impl,
+)
+`
+
+func TestRunShell(t *testing.T) {
+	vm := testWorkspace(t, map[string]string{
+		"defs.sky": testRunShell,
+		"BUILD":    `load("defs.sky", "shell")` + "\n" + `shell(name = "s")`,
+	})
+	defer os.RemoveAll(vm.ws.AbsPath())
+	r, err := analyze(vm, "//.:s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "bldy_run_shell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ns, err := host.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Build(executor.New(gocontext.Background(), ns)); err != nil {
+		t.Fatal(err)
+	}
+	bytz, err := ioutil.ReadFile(filepath.Join(dir, "out/s.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bytz) != "a\nb\n" {
+		t.Logf("was expecting the param file to be copied got %q instead", bytz)
+		t.Fail()
+	}
+}
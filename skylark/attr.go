@@ -2,6 +2,8 @@ package skylark
 
 import (
 	"fmt"
+	"path"
+	"strings"
 
 	"bldy.build/build/label"
 	"github.com/google/skylark"
@@ -46,7 +48,7 @@ func (a attributer) Call(thread *skylark.Thread, args skylark.Tuple, kwargs []sk
 	x := attr{attrType: a.attrType}
 	switch a.attrType {
 	case "bool":
-		i = &x
+		i = &boolAttr{attr: x}
 	case "int":
 		i = &intAttr{attr: x}
 	case "int_list":
@@ -57,15 +59,20 @@ func (a attributer) Call(thread *skylark.Thread, args skylark.Tuple, kwargs []sk
 		i = &labelKeyedStringDictAttr{attr: x}
 	case "label_list":
 		i = &labelListAttr{attr: x}
+	case "license":
+		i = &licenseAttr{attr: x}
 	case "output":
 		i = &outputAttr{attr: x}
-	case "license",
-		"output_list",
-		"string",
-		"string_dict",
-		"string_list",
-		"string_list_dict":
-		panic(fmt.Sprintf("%s not implemented", a.attrType))
+	case "output_list":
+		i = &outputListAttr{attr: x}
+	case "string":
+		i = &stringAttr{attr: x}
+	case "string_dict":
+		i = &stringDictAttr{attr: x}
+	case "string_list":
+		i = &stringListAttr{attr: x}
+	case "string_list_dict":
+		i = &stringListDict{attr: x}
 	}
 	if err := unpackStruct(i, kwargs); err != nil {
 		return nil, errors.Wrap(err, "attiributor.call")
@@ -82,6 +89,7 @@ type Attribute interface {
 
 	GetDefault() skylark.Value
 	HasDefault() bool
+	IsMandatory() bool
 }
 
 type CanAllowEmpty interface {
@@ -112,25 +120,78 @@ func (a *attr) Truth() skylark.Bool   { return true }
 
 func (a *attr) GetDefault() skylark.Value { return a.Default }
 func (a *attr) HasDefault() bool          { return a.Default != nil }
+func (a *attr) IsMandatory() bool         { return a.Mandatory }
 
 // https://docs.bazel.build/versions/master/skylark/lib/attr.html#bool
 type boolAttr struct {
 	attr
 }
 
+func (b *boolAttr) AllowsEmpty() bool    { return true }
+func (b *boolAttr) Empty() skylark.Value { return skylark.False }
+
+func (b *boolAttr) Convert(arg skylark.Value) (skylark.Value, error) {
+	if _, ok := arg.(skylark.Bool); !ok {
+		return nil, fmt.Errorf("has to be a bool, not %s", arg.Type())
+	}
+	return arg, nil
+}
+
 // https://docs.bazel.build/versions/master/skylark/lib/attr.html#int
 type intAttr struct {
 	attr
 
-	Values []int
+	Values []int64
+}
+
+func (i *intAttr) AllowsEmpty() bool    { return true }
+func (i *intAttr) Empty() skylark.Value { return skylark.MakeInt(0) }
+
+func (i *intAttr) Convert(arg skylark.Value) (skylark.Value, error) {
+	n, err := toInt(arg)
+	if err != nil {
+		return nil, err
+	}
+	if len(i.Values) == 0 {
+		return arg, nil
+	}
+	for _, v := range i.Values {
+		if v == n {
+			return arg, nil
+		}
+	}
+	return nil, fmt.Errorf("has to be one of %v, not %d", i.Values, n)
 }
 
 // https://docs.bazel.build/versions/master/skylark/lib/attr.html#int_list
 type intListAttr struct {
 	attr
 
-	NonEmpty   bool
-	AllowEmpty bool
+	NonEmpty bool
+	// AllowEmpty is nil unless it's set, empty lists are allowed by
+	// default.
+	AllowEmpty skylark.Value
+}
+
+func (i *intListAttr) AllowsEmpty() bool    { return allowsEmpty(i.NonEmpty, i.AllowEmpty) }
+func (i *intListAttr) Empty() skylark.Value { return skylark.NewList([]skylark.Value{}) }
+
+func (i *intListAttr) Convert(arg skylark.Value) (skylark.Value, error) {
+	seq, ok := arg.(skylark.Indexable)
+	if _, isString := arg.(skylark.String); !ok || isString {
+		return nil, fmt.Errorf("has to be a list of ints, not %s", arg.Type())
+	}
+	if seq.Len() == 0 && !allowsEmpty(i.NonEmpty, i.AllowEmpty) {
+		return nil, errEmpty
+	}
+	ints := []skylark.Value{}
+	for j := 0; j < seq.Len(); j++ {
+		if _, err := toInt(seq.Index(j)); err != nil {
+			return nil, fmt.Errorf("has to be a list of ints, not a list with a %s in it", seq.Index(j).Type())
+		}
+		ints = append(ints, seq.Index(j))
+	}
+	return skylark.NewList(ints), nil
 }
 
 type configuration string
@@ -149,23 +210,23 @@ type labelAttr struct {
 	AllowFiles           bool
 	AllowSingleFile      bool
 	AllowdExtensionsList []string
-	Providers            [][]string
+	// Providers is a list of providers, or a list of lists of providers
+	// if the targets can provide one of many sets of providers.
+	Providers *skylark.List
 
 	SingleFile bool
 
 	Cfg configuration
 }
 
+func (l *labelAttr) AllowsEmpty() bool    { return true }
+func (l *labelAttr) Empty() skylark.Value { return skylark.None }
+
 func (l *labelAttr) Convert(arg skylark.Value) (skylark.Value, error) {
-	lblString, ok := arg.(skylark.String)
-	if !ok {
-		return nil, fmt.Errorf("attribute should be of type string")
-	}
-	if lbl, err := label.Parse(string(lblString)); err == nil {
-		return lbl, nil
-	} else {
-		return nil, err
+	if arg == skylark.None {
+		return arg, nil
 	}
+	return toLabel(arg)
 }
 
 // https://docs.bazel.build/versions/master/skylark/lib/attr.html#label_keyed_string_dict
@@ -175,74 +236,158 @@ type labelKeyedStringDictAttr struct {
 	Executable           bool
 	AllowFiles           bool
 	AllowdExtensionsList []string
-	Providers            [][]string
+	Providers            *skylark.List
 
 	SingleFile bool
 
 	Cfg configuration
 }
 
+func (l *labelKeyedStringDictAttr) AllowsEmpty() bool    { return true }
+func (l *labelKeyedStringDictAttr) Empty() skylark.Value { return new(skylark.Dict) }
+
+func (l *labelKeyedStringDictAttr) Convert(arg skylark.Value) (skylark.Value, error) {
+	dict, ok := arg.(*skylark.Dict)
+	if !ok {
+		return nil, fmt.Errorf("has to be a dict of labels to strings, not %s", arg.Type())
+	}
+	converted := new(skylark.Dict)
+	for _, item := range dict.Items() {
+		lbl, err := toLabel(item[0])
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := item[1].(skylark.String); !ok {
+			return nil, fmt.Errorf("dict values have to be strings, not %s", item[1].Type())
+		}
+		converted.Set(lbl, item[1])
+	}
+	return converted, nil
+}
+
 // https://docs.bazel.build/versions/master/skylark/lib/attr.html#label_list
 type labelListAttr struct {
 	attr
 
 	Executable           bool
 	AllowFiles           bool
-	AllowEmpty           bool
 	AllowdExtensionsList []string
-	Providers            [][]string
+	Providers            *skylark.List
 
 	SingleFile bool
 
 	Cfg configuration
-}
 
-func (l *labelListAttr) AllowsEmpty() bool {
-	return l.AllowEmpty
+	NonEmpty bool
+	// AllowEmpty is nil unless it's set, empty lists are allowed by
+	// default.
+	AllowEmpty skylark.Value
 }
 
-func (l *labelListAttr) Empty() skylark.Value {
-	if l.AllowEmpty {
-		return skylark.NewList([]skylark.Value{})
-	} else {
-		return nil
-	}
-}
+func (l *labelListAttr) AllowsEmpty() bool    { return allowsEmpty(l.NonEmpty, l.AllowEmpty) }
+func (l *labelListAttr) Empty() skylark.Value { return skylark.NewList([]skylark.Value{}) }
 
 func (l *labelListAttr) Convert(arg skylark.Value) (skylark.Value, error) {
-	lblList, ok := arg.(*skylark.List)
-	if !ok {
-		return nil, fmt.Errorf("attribute should be of type list consisting of strings")
+	seq, ok := arg.(skylark.Indexable)
+	if _, isString := arg.(skylark.String); !ok || isString {
+		return nil, fmt.Errorf("has to be a list of labels, not %s", arg.Type())
+	}
+	if seq.Len() == 0 && !allowsEmpty(l.NonEmpty, l.AllowEmpty) {
+		return nil, errEmpty
 	}
-	i := lblList.Iterate()
 	list := []skylark.Value{}
-	var p skylark.Value
-	for i.Next(&p) {
-		val, ok := skylark.AsString(p)
-		if !ok {
-			return nil, fmt.Errorf("convert: (type=%T %q) is not a skylark.String", p, val)
-		}
-		if lbl, err := label.Parse(val); err == nil {
-			list = append(list, lbl)
-		} else {
+	for i := 0; i < seq.Len(); i++ {
+		lbl, err := toLabel(seq.Index(i))
+		if err != nil {
 			return nil, err
 		}
+		list = append(list, lbl)
 	}
 	return skylark.NewList(list), nil
 }
 
+// toLabel converts a string or a label to a label.
+func toLabel(v skylark.Value) (label.Label, error) {
+	switch v := v.(type) {
+	case label.Label:
+		return v, nil
+	case skylark.String:
+		lbl, err := label.Parse(string(v))
+		if err != nil {
+			return "", errors.Wrapf(err, "%s isn't a valid label", v)
+		}
+		return lbl, nil
+	default:
+		return "", fmt.Errorf("has to be a label, not %s", v.Type())
+	}
+}
+
+func toInt(v skylark.Value) (int64, error) {
+	i, ok := v.(skylark.Int)
+	if !ok {
+		return 0, fmt.Errorf("has to be an int, not %s", v.Type())
+	}
+	n, ok := i.Int64()
+	if !ok {
+		return 0, fmt.Errorf("%s is too big", i)
+	}
+	return n, nil
+}
+
 // https://docs.bazel.build/versions/master/skylark/lib/attr.html#license
 type licenseAttr struct{ attr }
 
+func (l *licenseAttr) AllowsEmpty() bool    { return true }
+func (l *licenseAttr) Empty() skylark.Value { return skylark.NewList([]skylark.Value{}) }
+
+func (l *licenseAttr) Convert(arg skylark.Value) (skylark.Value, error) {
+	return stringList(arg)
+}
+
 // https://docs.bazel.build/versions/master/skylark/lib/attr.html#output
 type outputAttr struct{ attr }
 
+func (o *outputAttr) AllowsEmpty() bool    { return true }
+func (o *outputAttr) Empty() skylark.Value { return skylark.None }
+
+func (o *outputAttr) Convert(arg skylark.Value) (skylark.Value, error) {
+	s, ok := skylark.AsString(arg)
+	if !ok {
+		return nil, fmt.Errorf("has to be a string, not %s", arg.Type())
+	}
+	return outputName(s)
+}
+
 // https://docs.bazel.build/versions/master/skylark/lib/attr.html#output_list
 type outputListAttr struct {
 	attr
 
-	NonEmpty   bool
-	AllowEmpty bool
+	NonEmpty bool
+	// AllowEmpty is nil unless it's set, empty lists are allowed by
+	// default.
+	AllowEmpty skylark.Value
+}
+
+func (o *outputListAttr) AllowsEmpty() bool    { return allowsEmpty(o.NonEmpty, o.AllowEmpty) }
+func (o *outputListAttr) Empty() skylark.Value { return skylark.NewList([]skylark.Value{}) }
+
+func (o *outputListAttr) Convert(arg skylark.Value) (skylark.Value, error) {
+	list, err := stringList(arg)
+	if err != nil {
+		return nil, err
+	}
+	if list.Len() == 0 && !allowsEmpty(o.NonEmpty, o.AllowEmpty) {
+		return nil, errEmpty
+	}
+	outs := []skylark.Value{}
+	for i := 0; i < list.Len(); i++ {
+		out, err := outputName(string(list.Index(i).(skylark.String)))
+		if err != nil {
+			return nil, err
+		}
+		outs = append(outs, out)
+	}
+	return skylark.NewList(outs), nil
 }
 
 // https://docs.bazel.build/versions/master/skylark/lib/attr.html#string
@@ -252,26 +397,146 @@ type stringAttr struct {
 	Values []string
 }
 
+func (s *stringAttr) AllowsEmpty() bool    { return true }
+func (s *stringAttr) Empty() skylark.Value { return skylark.String("") }
+
+func (s *stringAttr) Convert(arg skylark.Value) (skylark.Value, error) {
+	str, ok := skylark.AsString(arg)
+	if !ok {
+		return nil, fmt.Errorf("has to be a string, not %s", arg.Type())
+	}
+	if len(s.Values) == 0 {
+		return arg, nil
+	}
+	for _, v := range s.Values {
+		if v == str {
+			return arg, nil
+		}
+	}
+	return nil, fmt.Errorf("has to be one of %q, not %q", s.Values, str)
+}
+
 // https://docs.bazel.build/versions/master/skylark/lib/attr.html#string_dict
 type stringDictAttr struct {
 	attr
 
-	NonEmpty   bool
-	AllowEmpty bool
+	NonEmpty bool
+	// AllowEmpty is nil unless it's set, empty dicts are allowed by
+	// default.
+	AllowEmpty skylark.Value
+}
+
+func (s *stringDictAttr) AllowsEmpty() bool    { return allowsEmpty(s.NonEmpty, s.AllowEmpty) }
+func (s *stringDictAttr) Empty() skylark.Value { return new(skylark.Dict) }
+
+func (s *stringDictAttr) Convert(arg skylark.Value) (skylark.Value, error) {
+	dict, ok := arg.(*skylark.Dict)
+	if !ok {
+		return nil, fmt.Errorf("has to be a dict of strings, not %s", arg.Type())
+	}
+	if dict.Len() == 0 && !allowsEmpty(s.NonEmpty, s.AllowEmpty) {
+		return nil, errEmpty
+	}
+	for _, item := range dict.Items() {
+		if _, ok := item[0].(skylark.String); !ok {
+			return nil, fmt.Errorf("dict keys have to be strings, not %s", item[0].Type())
+		}
+		if _, ok := item[1].(skylark.String); !ok {
+			return nil, fmt.Errorf("dict values have to be strings, not %s", item[1].Type())
+		}
+	}
+	return dict, nil
 }
 
 // https://docs.bazel.build/versions/master/skylark/lib/attr.html#string_list
 type stringListAttr struct {
 	attr
 
-	NonEmpty   bool
-	AllowEmpty bool
+	NonEmpty bool
+	// AllowEmpty is nil unless it's set, empty lists are allowed by
+	// default.
+	AllowEmpty skylark.Value
+}
+
+func (s *stringListAttr) AllowsEmpty() bool    { return allowsEmpty(s.NonEmpty, s.AllowEmpty) }
+func (s *stringListAttr) Empty() skylark.Value { return skylark.NewList([]skylark.Value{}) }
+
+func (s *stringListAttr) Convert(arg skylark.Value) (skylark.Value, error) {
+	list, err := stringList(arg)
+	if err != nil {
+		return nil, err
+	}
+	if list.Len() == 0 && !allowsEmpty(s.NonEmpty, s.AllowEmpty) {
+		return nil, errEmpty
+	}
+	return list, nil
 }
 
 // https://docs.bazel.build/versions/master/skylark/lib/attr.html#string_list_dict
 type stringListDict struct {
 	attr
 
-	NonEmpty   bool
-	AllowEmpty bool
+	NonEmpty bool
+	// AllowEmpty is nil unless it's set, empty dicts are allowed by
+	// default.
+	AllowEmpty skylark.Value
+}
+
+func (s *stringListDict) AllowsEmpty() bool    { return allowsEmpty(s.NonEmpty, s.AllowEmpty) }
+func (s *stringListDict) Empty() skylark.Value { return new(skylark.Dict) }
+
+func (s *stringListDict) Convert(arg skylark.Value) (skylark.Value, error) {
+	dict, ok := arg.(*skylark.Dict)
+	if !ok {
+		return nil, fmt.Errorf("has to be a dict of string lists, not %s", arg.Type())
+	}
+	if dict.Len() == 0 && !allowsEmpty(s.NonEmpty, s.AllowEmpty) {
+		return nil, errEmpty
+	}
+	converted := new(skylark.Dict)
+	for _, item := range dict.Items() {
+		if _, ok := item[0].(skylark.String); !ok {
+			return nil, fmt.Errorf("dict keys have to be strings, not %s", item[0].Type())
+		}
+		list, err := stringList(item[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s", item[0])
+		}
+		converted.Set(item[0], list)
+	}
+	return converted, nil
+}
+
+var errEmpty = errors.New("isn't allowed to be empty")
+
+// allowsEmpty reports whether an attribute that has both non_empty and the
+// deprecated allow_empty set allows empty values.
+func allowsEmpty(nonEmpty bool, allowEmpty skylark.Value) bool {
+	return !nonEmpty && (allowEmpty == nil || bool(allowEmpty.Truth()))
+}
+
+// stringList copies a list or a tuple of strings in to a new list.
+func stringList(arg skylark.Value) (*skylark.List, error) {
+	seq, ok := arg.(skylark.Indexable)
+	if _, isString := arg.(skylark.String); !ok || isString {
+		return nil, fmt.Errorf("has to be a list of strings, not %s", arg.Type())
+	}
+	strs := []skylark.Value{}
+	for i := 0; i < seq.Len(); i++ {
+		x := seq.Index(i)
+		if _, ok := x.(skylark.String); !ok {
+			return nil, fmt.Errorf("has to be a list of strings, not a list with a %s in it", x.Type())
+		}
+		strs = append(strs, x)
+	}
+	return skylark.NewList(strs), nil
+}
+
+// outputName returns the output named s, outputs are relative to the output
+// directory and can't escape it.
+func outputName(s string) (output, error) {
+	if s == "" || path.IsAbs(s) || path.Clean(s) != s || strings.HasPrefix(s, "../") || s == ".." {
+		return "", fmt.Errorf("%q isn't a valid output name", s)
+	}
+	return output(s), nil
 }
@@ -0,0 +1,263 @@
+package skylark
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testAttrs = `
+def _impl(ctx):
+    pass
+
+strings = rule(
+    attrs = {
+        "mode": attr.string(values = ["opt", "dbg"], default = "dbg"),
+        "doc": attr.string(),
+        "copts": attr.string_list(),
+        "srcs": attr.string_list(non_empty = True, default = ["a.c"]),
+        "env": attr.string_dict(),
+        "groups": attr.string_list_dict(),
+        "licenses": attr.license(),
+        "outs": attr.output_list(),
+        "deps": attr.label_list(),
+    },
+    implementation = _impl,
+)
+
+needs = rule(
+    attrs = {
+        "out": attr.output(mandatory = True),
+        "tags": attr.string_list(allow_empty = False, default = ["x"]),
+        "libs": attr.label_list(allow_empty = False, default = ["//a:b"]),
+    },
+    implementation = _impl,
+)
+`
+
+func TestStringAttrs(t *testing.T) {
+	tests := []struct {
+		name  string
+		build string
+		attrs map[string]string
+		err   string
+	}{
+		{
+			name:  "defaults",
+			build: `strings(name = "x")`,
+			attrs: map[string]string{
+				"mode":     `"dbg"`,
+				"doc":      `""`,
+				"copts":    `[]`,
+				"srcs":     `["a.c"]`,
+				"env":      `{}`,
+				"groups":   `{}`,
+				"licenses": `[]`,
+				"outs":     `[]`,
+				"deps":     `[]`,
+			},
+		},
+		{
+			name:  "empty label list",
+			build: `strings(name = "x", deps = [])`,
+			attrs: map[string]string{"deps": `[]`},
+		},
+		{
+			name:  "values",
+			build: `strings(name = "x", mode = "opt", copts = ("-O2",), env = {"CC": "gcc"}, groups = {"a": ["b"]}, licenses = ["notice"])`,
+			attrs: map[string]string{
+				"mode":     `"opt"`,
+				"copts":    `["-O2"]`,
+				"env":      `{"CC": "gcc"}`,
+				"groups":   `{"a": ["b"]}`,
+				"licenses": `["notice"]`,
+			},
+		},
+		{
+			name:  "outputs",
+			build: `strings(name = "x", outs = ["a.txt", "b/c.txt"])`,
+			attrs: map[string]string{"outs": `[a.txt, b/c.txt]`},
+		},
+		{
+			name:  "not in values",
+			build: `strings(name = "x", mode = "fast")`,
+			err:   `//.:x: attribute "mode": has to be one of ["opt" "dbg"], not "fast"`,
+		},
+		{
+			name:  "not a string",
+			build: `strings(name = "x", doc = 1)`,
+			err:   `//.:x: attribute "doc": has to be a string, not int`,
+		},
+		{
+			name:  "not a list",
+			build: `strings(name = "x", copts = "-O2")`,
+			err:   `//.:x: attribute "copts": has to be a list of strings, not string`,
+		},
+		{
+			name:  "not a list of strings",
+			build: `strings(name = "x", copts = ["-O", 2])`,
+			err:   `//.:x: attribute "copts": has to be a list of strings, not a list with a int in it`,
+		},
+		{
+			name:  "non empty",
+			build: `strings(name = "x", srcs = [])`,
+			err:   `//.:x: attribute "srcs": isn't allowed to be empty`,
+		},
+		{
+			name:  "dict values",
+			build: `strings(name = "x", env = {"CC": 1})`,
+			err:   `//.:x: attribute "env": dict values have to be strings, not int`,
+		},
+		{
+			name:  "list dict values",
+			build: `strings(name = "x", groups = {"a": "b"})`,
+			err:   `//.:x: attribute "groups": "a": has to be a list of strings, not string`,
+		},
+		{
+			name:  "output name",
+			build: `strings(name = "x", outs = ["../escape"])`,
+			err:   `//.:x: attribute "outs": "../escape" isn't a valid output name`,
+		},
+		{
+			name:  "mandatory",
+			build: `needs(name = "x")`,
+			err:   `//.:x: attribute "out" is mandatory`,
+		},
+		{
+			name:  "allow empty",
+			build: `needs(name = "x", out = "x.txt", tags = [])`,
+			err:   `//.:x: attribute "tags": isn't allowed to be empty`,
+		},
+		{
+			name:  "label list allow empty",
+			build: `needs(name = "x", out = "x.txt", libs = [])`,
+			err:   `//.:x: attribute "libs": isn't allowed to be empty`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vm := testWorkspace(t, map[string]string{
+				"defs.sky": testAttrs,
+				"BUILD":    `load("defs.sky", "strings", "needs")` + "\n" + test.build + "\n",
+			})
+			defer os.RemoveAll(vm.ws.AbsPath())
+			r, err := vm.GetTarget("//.:x")
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Logf("was expecting %q got %v instead", test.err, err)
+					t.Fail()
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			attrs := r.(*Rule).ctx.attrs
+			for name, want := range test.attrs {
+				if got := attrs[name].String(); got != want {
+					t.Logf("was expecting %s to be %s got %s instead", name, want, got)
+					t.Fail()
+				}
+			}
+		})
+	}
+}
+
+func TestOutputAttrs(t *testing.T) {
+	vm := testWorkspace(t, map[string]string{
+		"defs.sky": testAttrs,
+		"BUILD":    `load("defs.sky", "needs")` + "\n" + `needs(name = "x", out = "x.txt")` + "\n",
+	})
+	defer os.RemoveAll(vm.ws.AbsPath())
+	r, err := vm.GetTarget("//.:x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outs := r.Outputs(); len(outs) != 1 || outs[0] != "x.txt" {
+		t.Logf("was expecting the output attribute to be an output got %q instead", outs)
+		t.Fail()
+	}
+	out, err := r.(*Rule).ctx.Attr("outputs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out == nil || !strings.Contains(out.String(), "out = x.txt") {
+		t.Logf("was expecting ctx.outputs.out got %v instead", out)
+		t.Fail()
+	}
+}
+
+const testSchema = `
+Info = provider(fields = ["x"])
+
+def _impl(ctx):
+    return [Info(x = 1)]
+
+def _plain(ctx):
+    pass
+
+lib = rule(attrs = {}, implementation = _impl)
+
+plain = rule(attrs = {}, implementation = _plain)
+
+strict = rule(
+    attrs = {
+        "level": attr.int(values = [1, 2]),
+        "flag": attr.bool(),
+        "src": attr.label(allow_single_file = [".c"]),
+        "tool": attr.label(executable = True, cfg = "host"),
+        "deps": attr.label_list(allow_empty = True, providers = [Info]),
+        "_private": attr.string(default = "x"),
+    },
+    implementation = _plain,
+)
+`
+
+func TestAttrSchema(t *testing.T) {
+	tests := []struct {
+		name  string
+		build string
+		err   string
+	}{
+		{"ok", `strict(name = "x", level = 2, flag = True, src = "a.c", tool = "run.sh", deps = [":lib"], tags = ["manual"])`, ""},
+		{"unknown", `strict(name = "x", levle = 2)`, `BUILD:3: makeskylarkrule: //.:x: no such attribute "levle"`},
+		{"private", `strict(name = "x", _private = "y")`, `//.:x: attribute "_private" is private`},
+		{"values", `strict(name = "x", level = 3)`, `//.:x: attribute "level": has to be one of [1 2], not 3`},
+		{"bool", `strict(name = "x", flag = 1)`, `//.:x: attribute "flag": has to be a bool, not int`},
+		{"label", `strict(name = "x", src = 1)`, `//.:x: attribute "src": has to be a label, not int`},
+		{"extension", `strict(name = "x", src = "a.h")`, `//.:x: attribute "src": a.h doesn't have one of the extensions [".c"]`},
+		{"executable", `strict(name = "x", tool = "a.c")`, `//.:x: attribute "tool": a.c isn't executable`},
+		{"providers", `strict(name = "x", deps = [":plain"])`, `//.:x: attribute "deps": //.:plain doesn't provide Info`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vm := testWorkspace(t, map[string]string{
+				"defs.sky": testSchema,
+				"a.c":      "",
+				"a.h":      "",
+				"BUILD": `load("defs.sky", "lib", "plain", "strict")
+lib(name = "lib")
+` + test.build + `
+plain(name = "plain")
+`,
+			})
+			defer os.RemoveAll(vm.ws.AbsPath())
+			if err := ioutil.WriteFile(filepath.Join(vm.ws.AbsPath(), "run.sh"), nil, 0755); err != nil {
+				t.Fatal(err)
+			}
+			_, err := analyze(vm, "//.:x")
+			if test.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Logf("was expecting %q got %v instead", test.err, err)
+				t.Fail()
+			}
+		})
+	}
+}
@@ -2,24 +2,39 @@ package skylark
 
 import (
 	"fmt"
+	"strings"
 
 	"bldy.build/build"
+	"bldy.build/build/label"
 	"github.com/pkg/errors"
 
 	"github.com/google/skylark"
 )
 
-func processAttrs(ctx *context, name string, ruleAttrs *skylark.Dict, kwargs []skylark.Tuple, wd string) error {
+func processAttrs(ctx *context, lbl label.Label, ruleAttrs *skylark.Dict, kwargs []skylark.Tuple) error {
 	ctx.attrs = skylark.StringDict{}
-	ctx.attrs[skylarkKeyName] = skylark.String(name) // this is added to all attrs https://github.com/bazelbuild/examples/blob/master/rules/attributes/printer.bzl#L20
+	ctx.attrs[skylarkKeyName] = skylark.String(lbl.Name()) // this is added to all attrs https://github.com/bazelbuild/examples/blob/master/rules/attributes/printer.bzl#L20
 
-	ctx.attrs[skylarkKeyCompatibleWith] = skylark.NewList([]skylark.Value{build.DefaultPlatform})
 	ctx.attrs[skylarkKeyHost] = build.DefaultPlatform
 
+	if err := checkKwargs(ruleAttrs, kwargs); err != nil {
+		return errors.Wrapf(err, "%s", lbl)
+	}
+	// compatible_with and restricted_to are empty unless they are set,
+	// rules without them can be built for any platform.
+	for _, name := range []string{skylarkKeyCompatibleWith, skylarkKeyRestrictedTo, skylarkKeyVisibility} {
+		lbls, err := commonLabels(lbl.Package(), kwargs, name)
+		if err != nil {
+			return errors.Wrapf(err, "%s: attribute %q", lbl, name)
+		}
+		ctx.attrs[name] = lbls
+	}
 	err := WalkDict(ruleAttrs, func(kw skylark.Value, attr Attribute) error { // check the attributes
 		arg, ok := findArg(kw, kwargs)
 		name := string(kw.(skylark.String))
 		if ok {
+		} else if attr.IsMandatory() {
+			return fmt.Errorf("attribute %q is mandatory", name)
 		} else if attr.HasDefault() { // if the attribute has a default and it's not in kwargs
 			arg = attr.GetDefault()
 		} else if attr, ok := attr.(CanAllowEmpty); ok && attr.AllowsEmpty() {
@@ -33,14 +48,66 @@ func processAttrs(ctx *context, name string, ruleAttrs *skylark.Dict, kwargs []s
 			var err error
 			ctx.attrs[name], err = converter.Convert(arg)
 			if err != nil {
-				return err
+				return errors.Wrapf(err, "attribute %q", name)
 			}
 		} else {
 			ctx.attrs[name] = arg
 		}
 		return nil
 	})
-	return err
+	return errors.Wrapf(err, "%s", lbl)
+}
+
+// commonLabels returns the labels the common attribute name of a rule in pkg
+// was set to.
+func commonLabels(pkg string, kwargs []skylark.Tuple, name string) (*skylark.List, error) {
+	vals := []skylark.Value{}
+	if arg, ok := findArg(skylark.String(name), kwargs); ok {
+		list, ok := arg.(*skylark.List)
+		if !ok {
+			return nil, fmt.Errorf("has to be a list of labels, not %s", arg.Type())
+		}
+		lbls, err := pkgLabels(pkg, list)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range lbls {
+			vals = append(vals, l)
+		}
+	}
+	return skylark.NewList(vals), nil
+}
+
+// commonAttrs are the attributes every rule has without declaring them.
+var commonAttrs = map[string]bool{
+	skylarkKeyName:           true,
+	skylarkKeyCompatibleWith: true,
+	skylarkKeyRestrictedTo:   true,
+	skylarkKeyTags:           true,
+	skylarkKeyToolChains:     true,
+	"deprecation":            true,
+	"features":               true,
+	"testonly":               true,
+	skylarkKeyVisibility:     true,
+}
+
+// checkKwargs checks the rule was called with attributes it declares, private
+// attributes, the ones that start with an underscore, can't be set.
+func checkKwargs(ruleAttrs *skylark.Dict, kwargs []skylark.Tuple) error {
+	for _, kwarg := range kwargs {
+		kw := kwarg.Index(0)
+		name := string(kw.(skylark.String))
+		if _, ok, _ := ruleAttrs.Get(kw); !ok {
+			if commonAttrs[name] {
+				continue
+			}
+			return fmt.Errorf("no such attribute %q", name)
+		}
+		if strings.HasPrefix(name, "_") {
+			return fmt.Errorf("attribute %q is private", name)
+		}
+	}
+	return nil
 }
 
 // WalkAttrs traverses attributes
@@ -64,4 +131,89 @@ func WalkDict(x *skylark.Dict, wf WalkAttrFunc) error {
 	return nil
 }
 
-type WalkAttrFunc func(skylark.Value, Attribute) error
\ No newline at end of file
+type WalkAttrFunc func(skylark.Value, Attribute) error
+
+// checkTargets checks the targets of the label attributes in attrs provide
+// what the attributes ask for. attrs are the attributes of the rule with
+// their dependencies replaced by their targets.
+func checkTargets(ruleAttrs *skylark.Dict, attrs skylark.StringDict) error {
+	return WalkDict(ruleAttrs, func(kw skylark.Value, attr Attribute) error {
+		name := string(kw.(skylark.String))
+		var (
+			want                   *skylark.List
+			singleFile, executable bool
+		)
+		switch x := attr.(type) {
+		case *labelAttr:
+			want, singleFile, executable = x.Providers, x.AllowSingleFile, x.Executable
+		case *labelListAttr:
+			want, executable = x.Providers, x.Executable
+		default:
+			return nil
+		}
+		groups, err := providerGroups(want)
+		if err != nil {
+			return fmt.Errorf("attribute %q: %v", name, err)
+		}
+		var targets []*target
+		switch v := attrs[name].(type) {
+		case *target:
+			targets = append(targets, v)
+		case *skylark.List:
+			for i := 0; i < v.Len(); i++ {
+				if t, ok := v.Index(i).(*target); ok {
+					targets = append(targets, t)
+				}
+			}
+		}
+		for _, t := range targets {
+			if err := t.satisfies(groups); err != nil {
+				return fmt.Errorf("attribute %q: %v", name, err)
+			}
+			files := t.files()
+			if singleFile && len(files) != 1 {
+				return fmt.Errorf("attribute %q: %s has to provide exactly one file, it provides %d", name, t.label, len(files))
+			}
+			if executable && !t.executable() {
+				return fmt.Errorf("attribute %q: %s isn't executable", name, t.label)
+			}
+		}
+		return nil
+	})
+}
+
+// providerGroups returns the sets of providers a target can provide to be
+// allowed in an attribute, providers is either a list of providers or a
+// list of lists of them.
+func providerGroups(providers *skylark.List) ([][]*Provider, error) {
+	if providers == nil || providers.Len() == 0 {
+		return nil, nil
+	}
+	var groups [][]*Provider
+	var single []*Provider
+	for i := 0; i < providers.Len(); i++ {
+		switch x := providers.Index(i).(type) {
+		case *Provider:
+			single = append(single, x)
+		case *skylark.List:
+			var group []*Provider
+			for j := 0; j < x.Len(); j++ {
+				p, ok := x.Index(j).(*Provider)
+				if !ok {
+					return nil, fmt.Errorf("providers have to be providers, not %s", x.Index(j).Type())
+				}
+				group = append(group, p)
+			}
+			groups = append(groups, group)
+		default:
+			return nil, fmt.Errorf("providers have to be providers or lists of providers, not %s", x.Type())
+		}
+	}
+	if single != nil {
+		if groups != nil {
+			return nil, errors.New("providers can't mix providers and lists of providers")
+		}
+		groups = [][]*Provider{single}
+	}
+	return groups, nil
+}
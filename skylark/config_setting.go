@@ -0,0 +1,135 @@
+package skylark
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"bldy.build/build"
+	"bldy.build/build/executor"
+	"bldy.build/build/label"
+	"bldy.build/build/racy"
+	"bldy.build/build/skylark/skylarkutils"
+	"bldy.build/build/workspace"
+	"github.com/google/skylark"
+)
+
+// configSetting is a condition select()s can pick their branches with, it
+// matches configurations that have every one of its settings.
+// https://docs.bazel.build/versions/master/be/general.html#config_setting
+type configSetting struct {
+	name string
+	ws   workspace.Workspace
+	vm   *skylarkVM
+
+	defines         map[string]string
+	constraints     []label.Label
+	compilationMode string
+}
+
+func (s *skylarkVM) configSetting(thread *skylark.Thread, fn *skylark.Builtin, args skylark.Tuple, kwargs []skylark.Tuple) (skylark.Value, error) {
+	var (
+		name                 string
+		values, defineValues *skylark.Dict
+		constraintValues     *skylark.List
+	)
+	if err := skylark.UnpackArgs(fn.Name(), args, kwargs,
+		"name", &name,
+		"values?", &values,
+		"define_values?", &defineValues,
+		"constraint_values?", &constraintValues,
+	); err != nil {
+		return nil, err
+	}
+	pkg := getPkg(thread)
+	c := &configSetting{
+		name:    name,
+		ws:      s.ws,
+		vm:      s,
+		defines: make(map[string]string),
+	}
+	if values != nil {
+		flags, err := skylarkutils.DictToGo(values)
+		if err != nil {
+			return nil, fmt.Errorf("config_setting: values: %v", err)
+		}
+		for flag, v := range flags {
+			switch flag {
+			case "define":
+				kv := strings.SplitN(v, "=", 2)
+				if len(kv) != 2 {
+					return nil, fmt.Errorf("config_setting: defines have to be in the form name=value, not %q", v)
+				}
+				c.defines[kv[0]] = kv[1]
+			case "compilation_mode":
+				switch v {
+				case build.Fastbuild, build.Dbg, build.Opt:
+				default:
+					return nil, fmt.Errorf("config_setting: %q isn't a compilation mode", v)
+				}
+				c.compilationMode = v
+			default:
+				return nil, fmt.Errorf("config_setting: unknown flag %q", flag)
+			}
+		}
+	}
+	if defineValues != nil {
+		defines, err := skylarkutils.DictToGo(defineValues)
+		if err != nil {
+			return nil, fmt.Errorf("config_setting: define_values: %v", err)
+		}
+		for k, v := range defines {
+			c.defines[k] = v
+		}
+	}
+	var err error
+	if c.constraints, err = pkgLabels(pkg, constraintValues); err != nil {
+		return nil, fmt.Errorf("config_setting: constraint_values: %v", err)
+	}
+	if len(c.defines) == 0 && len(c.constraints) == 0 && c.compilationMode == "" {
+		return nil, fmt.Errorf("config_setting: %s doesn't have any settings", name)
+	}
+	if err := s.declare(thread, label.New(pkg, name), c); err != nil {
+		return nil, err
+	}
+	return skylark.None, nil
+}
+
+// Matches reports whether the configuration has every define and
+// constraint, and the compilation mode, of the setting.
+func (c *configSetting) Matches(config *build.Config) bool {
+	if c.compilationMode != "" && config.CompilationMode != c.compilationMode {
+		return false
+	}
+	for k, v := range c.defines {
+		if config.Defines[k] != v {
+			return false
+		}
+	}
+	return c.vm.hasConstraints(config.Constraints, c.constraints)
+}
+
+func (c *configSetting) Name() string                   { return c.name }
+func (c *configSetting) Dependencies() []label.Label    { return nil }
+func (c *configSetting) Outputs() []string              { return nil }
+func (c *configSetting) Build(*executor.Executor) error { return nil }
+func (c *configSetting) Platform() label.Label          { return build.DefaultPlatform }
+func (c *configSetting) Workspace() workspace.Workspace { return c.ws }
+
+func (c *configSetting) Hash() []byte {
+	h := racy.New()
+	h.HashNamed("config_setting", c.name)
+	h.HashNamed("compilation_mode", c.compilationMode)
+	keys := []string{}
+	for k := range c.defines {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		h.HashNamed("define "+k, c.defines[k])
+	}
+	for _, lbl := range c.constraints {
+		h.HashNamed("constraint", lbl.String())
+	}
+	return h.Sum(nil)
+}
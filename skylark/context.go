@@ -3,7 +3,9 @@ package skylark
 import (
 	"bytes"
 	"fmt"
+	"sort"
 
+	"bldy.build/build"
 	"bldy.build/build/label"
 	"bldy.build/build/workspace"
 	"github.com/google/skylark"
@@ -17,22 +19,26 @@ type skyIO struct {
 
 // newContext returns a new bazel build context.
 func newContext(name string, ruleAttrs *skylark.Dict, ruleOutputs *skylark.Dict, kwargs []skylark.Tuple, lbl label.Label, ws workspace.Workspace) (*context, *skyIO, error) {
-	ac := new(actionRecorder)
-	actionsDict := skylark.StringDict{}
-	for _, actionName := range []string{
-		"run", "do_nothing",
-	} {
-		actionsDict[actionName] = newAction(actionName, ac)
-	}
+	dc := newDeclarer()
 	ctx := &context{
 		label:          name,
 		buf:            bytes.NewBuffer(nil),
-		actions:        skylarkstruct.FromStringDict(skylarkstruct.Default, actionsDict),
-		actionRecorder: ac,
+		actionRecorder: new(actionRecorder),
+		declarer:       dc,
 	}
+	actionsDict := skylark.StringDict{}
+	for _, actionName := range []string{
+		"run", "run_shell", "do_nothing", "write", "expand_template", "symlink",
+	} {
+		actionsDict[actionName] = newAction(actionName, ctx)
+	}
+	actionsDict["args"] = skylark.NewBuiltin("args", newArgs)
+	actionsDict["declare_file"] = skylark.NewBuiltin("declare_file", dc.declareFile)
+	actionsDict["declare_directory"] = skylark.NewBuiltin("declare_directory", dc.declareDirectory)
+	ctx.actions = skylarkstruct.FromStringDict(skylarkstruct.Default, actionsDict)
 	skyio := &skyIO{}
 	var err error
-	if err = processAttrs(ctx, name, ruleAttrs, kwargs, ws.PackageDir(lbl)); err != nil {
+	if err = processAttrs(ctx, lbl, ruleAttrs, kwargs); err != nil {
 		return nil, nil, err
 	}
 
@@ -43,6 +49,9 @@ func newContext(name string, ruleAttrs *skylark.Dict, ruleOutputs *skylark.Dict,
 
 		return nil, nil, err
 	}
+	for _, o := range skyio.outputs {
+		dc.outputs[o] = true
+	}
 	return ctx, skyio, nil
 
 }
@@ -55,8 +64,20 @@ type context struct {
 	files   skylark.StringDict
 	outputs skylark.Value
 
+	// analyzed is attrs with the dependencies replaced by their targets,
+	// it's what the implementation sees.
+	analyzed skylark.StringDict
+
 	actions        *skylarkstruct.Struct
 	actionRecorder *actionRecorder
+	declarer       *declarer
+
+	// config is what the rule is built in, it's set when the rule is
+	// configured.
+	config *build.Config
+	// toolchains are the toolchains the rule asked for, they are set
+	// when it's analyzed.
+	toolchains *toolchainContext
 }
 
 func (ctx *context) Name() string                             { return "ctx" }
@@ -68,6 +89,9 @@ func (ctx *context) Type() string                             { return "ctx" }
 func (ctx *context) AttrNames() []string                      { return nil }
 func (ctx *context) Print(thread *skylark.Thread, msg string) { ctx.buf.WriteString(msg) }
 func (ctx *context) Attrs() *skylarkstruct.Struct {
+	if ctx.analyzed != nil {
+		return skylarkstruct.FromStringDict(skylark.String("attrs"), ctx.analyzed)
+	}
 	return skylarkstruct.FromStringDict(skylark.String("attrs"), ctx.attrs)
 }
 func (ctx *context) Attr(name string) (skylark.Value, error) {
@@ -82,7 +106,67 @@ func (ctx *context) Attr(name string) (skylark.Value, error) {
 		return skylarkstruct.FromStringDict(skylark.String("files"), ctx.files), nil
 	case "outputs":
 		return ctx.outputs, nil
+	case "var":
+		return ctx.vars(), nil
+	case "configuration":
+		return ctx.configuration(), nil
+	case "toolchains":
+		if ctx.toolchains == nil {
+			return &toolchainContext{}, nil
+		}
+		return ctx.toolchains, nil
 	default:
 		return nil, fmt.Errorf("ctx doesn't have field or method %q", name)
 	}
 }
+
+func (ctx *context) buildConfig() *build.Config {
+	if ctx.config == nil {
+		return build.DefaultConfig()
+	}
+	return ctx.config
+}
+
+// vars returns the make variables of the configuration, the variables set
+// with --define and COMPILATION_MODE.
+// https://docs.bazel.build/versions/master/skylark/lib/ctx.html#var
+func (ctx *context) vars() *skylark.Dict {
+	config := ctx.buildConfig()
+	vars := toStringDict(config.Defines)
+	vars.Set(skylark.String("COMPILATION_MODE"), skylark.String(config.CompilationMode))
+	return vars
+}
+
+// configuration returns the configuration the rule is built in.
+// https://docs.bazel.build/versions/master/skylark/lib/configuration.html
+func (ctx *context) configuration() *skylarkstruct.Struct {
+	config := ctx.buildConfig()
+	return skylarkstruct.FromStringDict(skylark.String("configuration"), skylark.StringDict{
+		"compilation_mode":  skylark.String(config.CompilationMode),
+		"copts":             toStringList(config.Copts),
+		"linkopts":          toStringList(config.Linkopts),
+		"default_shell_env": toStringDict(config.ActionEnv),
+	})
+}
+
+func toStringList(strs []string) *skylark.List {
+	list := []skylark.Value{}
+	for _, s := range strs {
+		list = append(list, skylark.String(s))
+	}
+	return skylark.NewList(list)
+}
+
+// toStringDict converts m to a dict sorted by its keys.
+func toStringDict(m map[string]string) *skylark.Dict {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	d := new(skylark.Dict)
+	for _, k := range keys {
+		d.Set(skylark.String(k), skylark.String(m[k]))
+	}
+	return d
+}
@@ -0,0 +1,132 @@
+package skylark
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"bldy.build/build/executor"
+	"github.com/google/skylark"
+	"github.com/pkg/errors"
+)
+
+// directory is an output that's a directory, a tree artifact in bazel land.
+type directory string
+
+func (d directory) String() string        { return string(d) }
+func (d directory) Type() string          { return "File" }
+func (d directory) Freeze()               {}
+func (d directory) Truth() skylark.Bool   { return true }
+func (d directory) Hash() (uint32, error) { return hashString(string(d)), nil }
+
+// Path returns the path of the directory relative to the output directory.
+func (d directory) Path() string { return string(d) }
+
+func (d directory) Attr(name string) (skylark.Value, error) {
+	switch name {
+	case "path":
+		return skylark.String(string(d)), nil
+	case "is_directory":
+		return skylark.True, nil
+	}
+	return nil, nil
+}
+
+func (d directory) AttrNames() []string { return []string{"is_directory", "path"} }
+
+// declarer records the outputs a rule implementation declares.
+type declarer struct {
+	// outputs is every output of the rule, the ones in the outputs of
+	// the rule and the ones that are declared.
+	outputs  map[string]bool
+	declared []skylark.Value
+}
+
+func newDeclarer() *declarer {
+	return &declarer{outputs: make(map[string]bool)}
+}
+
+func (d *declarer) declare(fn *skylark.Builtin, name string) error {
+	if path.IsAbs(name) || name != path.Clean(name) || name == ".." || len(name) > 2 && name[:3] == "../" {
+		return fmt.Errorf("%s: %q has to be a clean path in the output directory", fn.Name(), name)
+	}
+	if d.outputs[name] {
+		return fmt.Errorf("%s: %q is already an output of the rule", fn.Name(), name)
+	}
+	d.outputs[name] = true
+	return nil
+}
+
+// https://docs.bazel.build/versions/master/skylark/lib/actions.html#declare_file
+func (d *declarer) declareFile(thread *skylark.Thread, fn *skylark.Builtin, args skylark.Tuple, kwargs []skylark.Tuple) (skylark.Value, error) {
+	var filename string
+	var sibling skylark.Value = skylark.None
+	if err := skylark.UnpackArgs(fn.Name(), args, kwargs, "filename", &filename, "sibling?", &sibling); err != nil {
+		return nil, err
+	}
+	if sibling != skylark.None {
+		s, ok := sibling.(interface{ Path() string })
+		if !ok {
+			return nil, fmt.Errorf("%s: sibling has to be a file, not %s", fn.Name(), sibling.Type())
+		}
+		filename = path.Join(path.Dir(s.Path()), filename)
+	}
+	if err := d.declare(fn, filename); err != nil {
+		return nil, err
+	}
+	o := output(filename)
+	d.declared = append(d.declared, o)
+	return o, nil
+}
+
+// https://docs.bazel.build/versions/master/skylark/lib/actions.html#declare_directory
+func (d *declarer) declareDirectory(thread *skylark.Thread, fn *skylark.Builtin, args skylark.Tuple, kwargs []skylark.Tuple) (skylark.Value, error) {
+	var filename string
+	if err := skylark.UnpackArgs(fn.Name(), args, kwargs, "filename", &filename); err != nil {
+		return nil, err
+	}
+	if err := d.declare(fn, filename); err != nil {
+		return nil, err
+	}
+	dir := directory(filename)
+	d.declared = append(d.declared, dir)
+	return dir, nil
+}
+
+// prepareOutputs creates the directories the declared outputs go in, and
+// the declared directories, before the actions run.
+func prepareOutputs(e *executor.Executor, declared []skylark.Value) error {
+	for _, v := range declared {
+		dir := filepath.Dir(v.(interface{ Path() string }).Path())
+		if d, ok := v.(directory); ok {
+			dir = string(d)
+		}
+		if err := e.Mkdir(dir); err != nil {
+			return errors.Wrap(err, "prepare outputs")
+		}
+	}
+	return nil
+}
+
+// checkOutput returns an error if name wasn't created, or if it isn't a
+// directory when it's supposed to be one.
+func checkOutput(e *executor.Executor, name string, dir bool) error {
+	f, err := e.Open(name)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s wasn't created", name)
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if dir && !fi.IsDir() {
+		return fmt.Errorf("%s was declared as a directory but a file was created", name)
+	} else if !dir && fi.IsDir() {
+		return fmt.Errorf("%s was declared as a file but a directory was created", name)
+	}
+	return nil
+}
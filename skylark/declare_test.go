@@ -0,0 +1,120 @@
+package skylark
+
+import (
+	gocontext "context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"bldy.build/build/executor"
+	"bldy.build/build/label"
+	"bldy.build/build/namespace/host"
+)
+
+const testDeclare = `
+def _gen(ctx):
+    f = ctx.actions.declare_file("gen/{}.txt".format(ctx.attrs.name))
+    ctx.actions.write(output = f, content = "x")
+    sib = ctx.actions.declare_file("sibling.txt", sibling = f)
+    ctx.actions.write(output = sib, content = "y")
+    d = ctx.actions.declare_directory("tree")
+    ctx.actions.run_shell(
+        outputs = [d],
+        command = "touch $1/a $1/b",
+        arguments = [d.path],
+    )
+
+gen = rule(
+    attrs = {},
+    implementation = _gen,
+)
+
+def _lazy(ctx):
+    ctx.actions.declare_file("never.txt")
+
+lazy = rule(
+    attrs = {},
+    implementation = _lazy,
+)
+
+def _liar(ctx):
+    f = ctx.actions.declare_file("lie.txt")
+    ctx.actions.run_shell(outputs = [f], command = "true")
+
+liar = rule(
+    attrs = {},
+    implementation = _liar,
+)
+
+def _twice(ctx):
+    ctx.actions.declare_file("out.txt")
+
+twice = rule(
+    attrs = {},
+    outputs = {"out": "out.txt"},
+    implementation = _twice,
+)
+`
+
+func TestDeclare(t *testing.T) {
+	vm := testWorkspace(t, map[string]string{
+		"defs.sky": testDeclare,
+		"BUILD": `load("defs.sky", "gen", "lazy", "liar", "twice")
+gen(name = "g")
+lazy(name = "l")
+liar(name = "x")
+twice(name = "t")
+`,
+	})
+	defer os.RemoveAll(vm.ws.AbsPath())
+
+	build := func(lbl label.Label) (*Rule, string, error) {
+		r, err := analyze(vm, lbl)
+		if err != nil {
+			return nil, "", err
+		}
+		dir, err := ioutil.TempDir("", "bldy_declare")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ns, err := host.New(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r, dir, r.Build(executor.New(gocontext.Background(), ns))
+	}
+
+	r, dir, err := build("//.:g")
+	defer os.RemoveAll(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"gen/g.txt", "gen/sibling.txt", "tree"}; !reflect.DeepEqual(r.Outputs(), want) {
+		t.Logf("was expecting %q got %q instead", want, r.Outputs())
+		t.Fail()
+	}
+	if _, err := os.Stat(filepath.Join(dir, "tree", "b")); err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	tests := []struct {
+		label label.Label
+		err   string
+	}{
+		{"//.:l", "//.:l: declared output: never.txt wasn't created"},
+		{"//.:x", "//.:x: action didn't create its outputs: lie.txt wasn't created"},
+		{"//.:t", `"out.txt" is already an output of the rule`},
+	}
+	for _, test := range tests {
+		_, dir, err := build(test.label)
+		os.RemoveAll(dir)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Logf("was expecting %q got %v instead", test.err, err)
+			t.Fail()
+		}
+	}
+}
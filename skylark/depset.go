@@ -0,0 +1,205 @@
+package skylark
+
+import (
+	"fmt"
+
+	"github.com/google/skylark"
+)
+
+// Orders a depset can be traversed in.
+// https://docs.bazel.build/versions/master/skylark/depsets.html#order
+const (
+	orderDefault     = "default"
+	orderPostorder   = "postorder"
+	orderPreorder    = "preorder"
+	orderTopological = "topological"
+)
+
+// Depset is an immutable set that shares the sets it's made from instead of
+// copying them, so sets that are accumulated over the dependencies of a
+// rule don't grow quadratically. It's only flattened by to_list.
+// https://docs.bazel.build/versions/master/skylark/lib/depset.html
+type Depset struct {
+	order      string
+	direct     []skylark.Value
+	transitive []*Depset
+	// elemType is the type of the elements of the depset and the ones it's
+	// made from, it's empty if there aren't any.
+	elemType string
+}
+
+// depset returns a new depset.
+// https://docs.bazel.build/versions/master/skylark/lib/globals.html#depset
+func depset(thread *skylark.Thread, fn *skylark.Builtin, args skylark.Tuple, kwargs []skylark.Tuple) (skylark.Value, error) {
+	var direct, transitive skylark.Value = skylark.None, skylark.None
+	order := orderDefault
+	if err := skylark.UnpackArgs(fn.Name(), args, kwargs, "direct?", &direct, "order?", &order, "transitive?", &transitive); err != nil {
+		return nil, err
+	}
+	d, err := newDepset(order, direct, transitive)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	return d, nil
+}
+
+func newDepset(order string, direct, transitive skylark.Value) (*Depset, error) {
+	switch order {
+	case orderDefault, orderPostorder, orderPreorder, orderTopological:
+	default:
+		return nil, fmt.Errorf("unknown order %q", order)
+	}
+	d := &Depset{order: order}
+	if direct != skylark.None {
+		seq, ok := direct.(skylark.Sequence)
+		if !ok {
+			return nil, fmt.Errorf("direct has to be a list, not %s", direct.Type())
+		}
+		iter := seq.Iterate()
+		defer iter.Done()
+		var v skylark.Value
+		for iter.Next(&v) {
+			if _, err := v.Hash(); err != nil {
+				return nil, fmt.Errorf("depset elements have to be hashable: %v", err)
+			}
+			if err := d.addType(v.Type()); err != nil {
+				return nil, err
+			}
+			d.direct = append(d.direct, v)
+		}
+	}
+	if transitive != skylark.None {
+		seq, ok := transitive.(skylark.Sequence)
+		if !ok {
+			return nil, fmt.Errorf("transitive has to be a list of depsets, not %s", transitive.Type())
+		}
+		iter := seq.Iterate()
+		defer iter.Done()
+		var v skylark.Value
+		for iter.Next(&v) {
+			t, ok := v.(*Depset)
+			if !ok {
+				return nil, fmt.Errorf("transitive has to be a list of depsets, got a %s in it", v.Type())
+			}
+			// default depsets can include depsets of any order
+			if order != orderDefault && t.order != orderDefault && t.order != order {
+				return nil, fmt.Errorf("a %s depset can't include a %s one", order, t.order)
+			}
+			if err := d.addType(t.elemType); err != nil {
+				return nil, err
+			}
+			d.transitive = append(d.transitive, t)
+		}
+	}
+	return d, nil
+}
+
+// addType checks elements of type typ can be added to the depset.
+func (d *Depset) addType(typ string) error {
+	if typ == "" {
+		return nil
+	}
+	if d.elemType != "" && d.elemType != typ {
+		return fmt.Errorf("can't add a %s to a depset of %s", typ, d.elemType)
+	}
+	d.elemType = typ
+	return nil
+}
+
+func (d *Depset) Freeze() {
+	for _, v := range d.direct {
+		v.Freeze()
+	}
+}
+func (d *Depset) Truth() skylark.Bool   { return len(d.direct) > 0 || len(d.transitive) > 0 }
+func (d *Depset) Type() string          { return "depset" }
+func (d *Depset) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: depset") }
+func (d *Depset) String() string {
+	return fmt.Sprintf("depset(%s)", skylark.NewList(d.ToList()))
+}
+
+func (d *Depset) Attr(name string) (skylark.Value, error) {
+	switch name {
+	case "to_list":
+		return skylark.NewBuiltin("to_list", depsetToList).BindReceiver(d), nil
+	}
+	return nil, nil
+}
+
+func (d *Depset) AttrNames() []string { return []string{"to_list"} }
+
+func depsetToList(thread *skylark.Thread, fn *skylark.Builtin, args skylark.Tuple, kwargs []skylark.Tuple) (skylark.Value, error) {
+	if err := skylark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	return skylark.NewList(fn.Receiver().(*Depset).ToList()), nil
+}
+
+// ToList returns the elements of the depset, without duplicates, in the
+// order of the depset. Depsets that are included more than once are only
+// traversed once.
+func (d *Depset) ToList() []skylark.Value {
+	visited := make(map[*Depset]bool)
+	var elems []skylark.Value
+	var walk func(d *Depset)
+	switch d.order {
+	case orderPreorder:
+		walk = func(d *Depset) {
+			if visited[d] {
+				return
+			}
+			visited[d] = true
+			elems = append(elems, d.direct...)
+			for _, t := range d.transitive {
+				walk(t)
+			}
+		}
+	case orderTopological:
+		// reverse postorder, walking right to left, puts every depset
+		// before the ones it includes.
+		walk = func(d *Depset) {
+			if visited[d] {
+				return
+			}
+			visited[d] = true
+			for i := len(d.transitive) - 1; i >= 0; i-- {
+				walk(d.transitive[i])
+			}
+			for i := len(d.direct) - 1; i >= 0; i-- {
+				elems = append(elems, d.direct[i])
+			}
+		}
+	default:
+		walk = func(d *Depset) {
+			if visited[d] {
+				return
+			}
+			visited[d] = true
+			for _, t := range d.transitive {
+				walk(t)
+			}
+			elems = append(elems, d.direct...)
+		}
+	}
+	walk(d)
+	if d.order == orderTopological {
+		for i, j := 0, len(elems)-1; i < j; i, j = i+1, j-1 {
+			elems[i], elems[j] = elems[j], elems[i]
+		}
+	}
+	return dedup(elems)
+}
+
+// dedup removes the duplicates in elems, keeping the first one.
+func dedup(elems []skylark.Value) []skylark.Value {
+	seen := new(skylark.Dict)
+	list := []skylark.Value{}
+	for _, v := range elems {
+		if _, found, _ := seen.Get(v); found {
+			continue
+		}
+		seen.Set(v, skylark.None)
+		list = append(list, v)
+	}
+	return list
+}
@@ -0,0 +1,99 @@
+package skylark
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/skylark"
+)
+
+func TestDepsetOrder(t *testing.T) {
+	// a includes b and c which both include d, like a diamond of libraries.
+	const diamond = `
+d = depset(["d"], order = "%[1]s")
+b = depset(["b1", "b2"], transitive = [d], order = "%[1]s")
+c = depset(["c", "b1"], transitive = [d], order = "%[1]s")
+a = depset(["a"], transitive = [b, c], order = "%[1]s")
+x = a.to_list()
+`
+	tests := []struct {
+		order string
+		want  string
+	}{
+		{"default", `["d", "b1", "b2", "c", "a"]`},
+		{"postorder", `["d", "b1", "b2", "c", "a"]`},
+		{"preorder", `["a", "b1", "b2", "d", "c"]`},
+		{"topological", `["a", "b1", "b2", "c", "d"]`},
+	}
+	for _, test := range tests {
+		t.Run(test.order, func(t *testing.T) {
+			globals, err := execDepset(fmt.Sprintf(diamond, test.order))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := globals["x"].String(); got != test.want {
+				t.Logf("was expecting %s got %s instead", test.want, got)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestDepsetErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{"order", `depset(["a"], order = "random")`, `unknown order "random"`},
+		{"mixed orders", `depset(transitive = [depset(order = "preorder")], order = "postorder")`, "a postorder depset can't include a preorder one"},
+		{"types", `depset([1], transitive = [depset(["a"])])`, "can't add a string to a depset of int"},
+		{"unhashable", `depset([[1]])`, "have to be hashable"},
+		{"transitive", `depset(transitive = [["a"]])`, "transitive has to be a list of depsets"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := execDepset(test.src); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Logf("was expecting %q got %v instead", test.err, err)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestDepsetDefaultOrder(t *testing.T) {
+	globals, err := execDepset(`d = depset(["a"], transitive = [depset(["b"], order = "preorder"), depset(["c"], order = "topological")])`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := skylark.NewList(globals["d"].(*Depset).ToList()).String(); got != `["b", "c", "a"]` {
+		t.Logf("was expecting a default depset to include depsets of other orders got %s instead", got)
+		t.Fail()
+	}
+}
+
+func TestDepsetSharing(t *testing.T) {
+	// every level includes the previous one twice, flattening it naively
+	// would visit 2^n depsets.
+	d, _ := newDepset(orderDefault, skylark.NewList([]skylark.Value{skylark.MakeInt(0)}), skylark.None)
+	for i := 1; i < 64; i++ {
+		var err error
+		d, err = newDepset(orderDefault,
+			skylark.NewList([]skylark.Value{skylark.MakeInt(i)}),
+			skylark.NewList([]skylark.Value{d, d}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(d.ToList()); n != 64 {
+		t.Logf("was expecting 64 elements got %d instead", n)
+		t.Fail()
+	}
+}
+
+func execDepset(src string) (skylark.StringDict, error) {
+	globals := skylark.StringDict{"depset": skylark.NewBuiltin("depset", depset)}
+	return skylark.ExecFile(&skylark.Thread{}, "depset.sky", src, globals)
+}
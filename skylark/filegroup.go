@@ -0,0 +1,152 @@
+package skylark
+
+import (
+	"fmt"
+	"sync"
+
+	"bldy.build/build"
+	"bldy.build/build/label"
+	"bldy.build/build/racy"
+	"github.com/google/skylark"
+)
+
+// filegroup gives a name to a set of targets, its files are the files of
+// srcs and its runfiles the files of data.
+// https://docs.bazel.build/versions/master/be/general.html#filegroup
+type filegroup struct {
+	platformTarget
+	label      label.Label
+	srcs       []label.Label
+	data       []label.Label
+	visibility []label.Label
+
+	analysis   sync.Once
+	analyzeErr error
+	outputs    []string
+	files      []string
+	providers  map[*Provider]*Info
+}
+
+func (s *skylarkVM) filegroup(thread *skylark.Thread, fn *skylark.Builtin, args skylark.Tuple, kwargs []skylark.Tuple) (skylark.Value, error) {
+	var name string
+	var srcs, data, visibility *skylark.List
+	if err := skylark.UnpackArgs(fn.Name(), args, kwargs, "name", &name, "srcs?", &srcs, "data?", &data, "visibility?", &visibility); err != nil {
+		return nil, err
+	}
+	pkg := getPkg(thread)
+	g := &filegroup{
+		platformTarget: platformTarget{name: name, ws: s.ws},
+		label:          label.New(pkg, name),
+	}
+	var err error
+	if g.srcs, err = pkgLabels(pkg, srcs); err != nil {
+		return nil, fmt.Errorf("filegroup: srcs: %v", err)
+	}
+	if g.data, err = pkgLabels(pkg, data); err != nil {
+		return nil, fmt.Errorf("filegroup: data: %v", err)
+	}
+	if g.visibility, err = pkgLabels(pkg, visibility); err != nil {
+		return nil, fmt.Errorf("filegroup: visibility: %v", err)
+	}
+	if visibility == nil {
+		g.visibility = packageDefaults(thread).visibility
+	}
+	return skylark.None, s.declare(thread, g.label, g)
+}
+
+// Dependencies returns srcs and data.
+func (g *filegroup) Dependencies() []label.Label {
+	deps := []label.Label{}
+	seen := make(map[label.Label]bool)
+	for _, lbl := range append(append([]label.Label{}, g.srcs...), g.data...) {
+		if !seen[lbl] {
+			seen[lbl] = true
+			deps = append(deps, lbl)
+		}
+	}
+	return deps
+}
+
+// Outputs returns the outputs of srcs and data, they are what the group
+// passes on to the targets that depend on it.
+func (g *filegroup) Outputs() []string { return g.outputs }
+
+// Files returns the paths of the files of srcs.
+func (g *filegroup) Files() []string { return g.files }
+
+// Visibility returns the labels the group is visible to, it's private if
+// neither it nor its package set a visibility.
+func (g *filegroup) Visibility() []label.Label {
+	if len(g.visibility) == 0 {
+		return []label.Label{build.PrivateVisibility}
+	}
+	return g.visibility
+}
+
+// Analyze collects the files of srcs and data.
+func (g *filegroup) Analyze(deps map[label.Label]build.Rule) error {
+	g.analysis.Do(func() { g.analyzeErr = g.analyze(deps) })
+	return g.analyzeErr
+}
+
+func (g *filegroup) analyze(deps map[label.Label]build.Rule) error {
+	seen := make(map[string]bool)
+	collect := func(lbls []label.Label) (*Depset, error) {
+		var files []skylark.Value
+		for _, lbl := range lbls {
+			dep, ok := deps[lbl]
+			if !ok {
+				return nil, fmt.Errorf("%s: %s wasn't analyzed", g.label, lbl)
+			}
+			t := &target{label: lbl, providers: depProviders(dep)}
+			files = append(files, t.files()...)
+			for _, o := range dep.Outputs() {
+				if !seen[o] {
+					seen[o] = true
+					g.outputs = append(g.outputs, o)
+				}
+			}
+		}
+		d, err := newDepset(orderDefault, skylark.NewList(files), skylark.None)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", g.label, err)
+		}
+		return d, nil
+	}
+	files, err := collect(g.srcs)
+	if err != nil {
+		return err
+	}
+	for _, f := range files.ToList() {
+		if f, ok := f.(interface{ Path() string }); ok {
+			g.files = append(g.files, f.Path())
+		}
+	}
+	runfiles, err := collect(g.data)
+	if err != nil {
+		return err
+	}
+	g.providers = map[*Provider]*Info{
+		DefaultInfo: &Info{provider: DefaultInfo, fields: skylark.StringDict{
+			"files":    files,
+			"runfiles": runfiles,
+		}},
+	}
+	return nil
+}
+
+// Providers returns DefaultInfo with the files of the group, it's nil until
+// the group has been analyzed.
+func (g *filegroup) Providers() map[*Provider]*Info { return g.providers }
+
+func (g *filegroup) Hash() []byte {
+	h := racy.New()
+	h.HashNamed("filegroup", g.name)
+	for _, lbl := range g.srcs {
+		h.HashNamed("src", lbl.String())
+	}
+	for _, lbl := range g.data {
+		h.HashNamed("data", lbl.String())
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,251 @@
+package skylark
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bldy.build/build"
+	"bldy.build/build/label"
+)
+
+const testFiles = `
+def _gen(ctx):
+    out = ctx.actions.declare_file(ctx.attrs.name + ".out")
+    ctx.actions.write(output = out, content = ctx.attrs.name)
+    return [DefaultInfo(files = depset([out]))]
+
+gen = rule(
+    attrs = {},
+    implementation = _gen,
+)
+
+def _use(ctx):
+    return [DefaultInfo(files = depset(ctx.files.srcs))]
+
+use = rule(
+    attrs = {"srcs": attr.label_list(allow_files = True)},
+    implementation = _use,
+)
+`
+
+func TestSourceFiles(t *testing.T) {
+	vm := testWorkspace(t, map[string]string{
+		"defs.sky": testFiles,
+		"a.txt":    "a",
+		"b.txt":    "b",
+		"BUILD": `load("defs.sky", "use")
+exports_files(["b.txt"], visibility = ["//app:__pkg__"])
+use(name = "u", srcs = ["a.txt", "missing.txt"])
+`,
+	})
+	defer os.RemoveAll(vm.ws.AbsPath())
+
+	tests := []struct {
+		label      label.Label
+		visibility string
+		err        string
+	}{
+		{"//.:a.txt", "[//visibility:public]", ""},
+		{"//.:b.txt", "[//app:__pkg__]", ""},
+		{"//.:missing.txt", "", `//.:u depends on "//.:missing.txt" which is neither a target nor a file`},
+		{"//.:nope.txt", "", `couldn't find the target "//.:nope.txt"`},
+	}
+	for _, test := range tests {
+		t.Run(test.label.Name(), func(t *testing.T) {
+			f, err := vm.GetTarget(test.label)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Logf("was expecting %q got %v instead", test.err, err)
+					t.Fail()
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := f.(*sourceFile); !ok {
+				t.Fatalf("was expecting a source file got %T instead", f)
+			}
+			if got := fmt.Sprint(f.(build.Visible).Visibility()); got != test.visibility {
+				t.Logf("was expecting %s got %s instead", test.visibility, got)
+				t.Fail()
+			}
+		})
+	}
+
+	r, err := vm.GetTarget("//.:u")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deps := fmt.Sprint(r.Dependencies()); deps != "[//.:a.txt //.:missing.txt]" {
+		t.Logf("was expecting the source files to be dependencies got %s instead", deps)
+		t.Fail()
+	}
+	a, _ := vm.GetTarget("//.:a.txt")
+	b, _ := vm.GetTarget("//.:b.txt")
+	if string(a.Hash()) == string(b.Hash()) {
+		t.Log("was expecting different files to hash differently")
+		t.Fail()
+	}
+}
+
+func TestFilegroup(t *testing.T) {
+	vm := testWorkspace(t, map[string]string{
+		"defs.sky": testFiles,
+		"a.txt":    "a",
+		"b.txt":    "b",
+		"c.txt":    "c",
+		"BUILD": `load("defs.sky", "gen", "use")
+gen(name = "g")
+filegroup(
+    name = "fg",
+    srcs = [":g", "a.txt"],
+    data = ["b.txt"],
+)
+use(name = "u", srcs = [":fg", "c.txt"])
+`,
+	})
+	defer os.RemoveAll(vm.ws.AbsPath())
+
+	r, err := analyze(vm, "//.:u")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := r.Providers()[DefaultInfo].fields["files"].(*Depset)
+	if got := fmt.Sprint(files.ToList()); got != "[g.out a.txt c.txt]" {
+		t.Logf("was expecting the generated and source files got %s instead", got)
+		t.Fail()
+	}
+
+	fg, err := vm.GetTarget("//.:fg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deps := fmt.Sprint(fg.Dependencies()); deps != "[//.:g //.:a.txt //.:b.txt]" {
+		t.Logf("was expecting srcs and data to be dependencies got %s instead", deps)
+		t.Fail()
+	}
+	if outs := fmt.Sprint(fg.Outputs()); outs != "[g.out]" {
+		t.Logf("was expecting the group to pass on the outputs of its srcs got %s instead", outs)
+		t.Fail()
+	}
+	want := fmt.Sprint([]string{"g.out", filepath.Join(vm.ws.AbsPath(), "a.txt")})
+	if got := fmt.Sprint(fg.(build.Files).Files()); got != want {
+		t.Logf("was expecting %s got %s instead", want, got)
+		t.Fail()
+	}
+	runfiles := fg.(*filegroup).Providers()[DefaultInfo].fields["runfiles"].(*Depset)
+	if got := fmt.Sprint(runfiles.ToList()); got != "[b.txt]" {
+		t.Logf("was expecting data to be the runfiles got %s instead", got)
+		t.Fail()
+	}
+	if got := fmt.Sprint(fg.(build.Visible).Visibility()); got != "[//visibility:private]" {
+		t.Logf("was expecting the group to be private got %s instead", got)
+		t.Fail()
+	}
+}
+
+func TestPackageDefaults(t *testing.T) {
+	vm := testWorkspace(t, map[string]string{
+		"defs.sky": testFiles,
+		"BUILD": `load("defs.sky", "gen")
+package(
+    default_compatible_with = ["//os:linux"],
+    default_restricted_to = ["//cpu:arm"],
+)
+gen(name = "x")
+gen(name = "y", compatible_with = [], restricted_to = [])
+`,
+	})
+	defer os.RemoveAll(vm.ws.AbsPath())
+
+	config := build.DefaultConfig()
+	config.Constraints = []label.Label{"//os:linux"}
+	x, err := vm.GetTarget("//.:x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := x.(build.Restricted).CompatibleWith(config); err == nil || !strings.Contains(err.Error(), "it's restricted to [//cpu:arm]") {
+		t.Logf("was expecting the defaults of the package got %v instead", err)
+		t.Fail()
+	}
+	y, err := vm.GetTarget("//.:y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := y.(build.Restricted).CompatibleWith(build.DefaultConfig()); err != nil {
+		t.Logf("was expecting the rule to override the defaults got %v instead", err)
+		t.Fail()
+	}
+}
+
+func TestOutputFiles(t *testing.T) {
+	vm := testWorkspace(t, map[string]string{
+		"defs.sky": testFiles + `
+def _pre(ctx):
+    ctx.actions.write(output = ctx.outputs.out, content = ctx.attrs.name)
+
+pre = rule(
+    attrs = {},
+    outputs = {"out": "%{name}.txt"},
+    implementation = _pre,
+)
+`,
+		"a.txt": "a",
+		"BUILD": `load("defs.sky", "pre", "use")
+pre(name = "p")
+use(name = "u", srcs = ["p.txt", "a.txt"])
+`,
+	})
+	defer os.RemoveAll(vm.ws.AbsPath())
+
+	f, err := vm.GetTarget("//.:p.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.(*outputFile); !ok {
+		t.Fatalf("was expecting an output file got %T instead", f)
+	}
+	if deps := fmt.Sprint(f.Dependencies()); deps != "[//.:p]" {
+		t.Logf("was expecting the output file to depend on the rule that generates it got %s instead", deps)
+		t.Fail()
+	}
+	if outs := fmt.Sprint(f.Outputs()); outs != "[p.txt]" {
+		t.Logf("was expecting the output file to pass on the file got %s instead", outs)
+		t.Fail()
+	}
+	r, err := analyze(vm, "//.:u")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := r.Providers()[DefaultInfo].fields["files"].(*Depset)
+	if got := fmt.Sprint(files.ToList()); got != "[p.txt a.txt]" {
+		t.Logf("was expecting the generated and source files got %s instead", got)
+		t.Fail()
+	}
+
+	vm = testWorkspace(t, map[string]string{
+		"defs.sky": testFiles + `
+def _pre(ctx):
+    pass
+
+pre = rule(
+    attrs = {},
+    outputs = {"out": "%{name}.txt"},
+    implementation = _pre,
+)
+`,
+		"BUILD": `load("defs.sky", "pre", "gen")
+gen(name = "p.txt")
+pre(name = "p")
+`,
+	})
+	defer os.RemoveAll(vm.ws.AbsPath())
+	if _, err := vm.GetTarget("//.:p"); err == nil || !strings.Contains(err.Error(), `output "p.txt" conflicts with the target //.:p.txt`) {
+		t.Logf("was expecting outputs that conflict with targets to fail got %v instead", err)
+		t.Fail()
+	}
+}
@@ -2,9 +2,13 @@ package skylark
 
 import (
 	"fmt"
+	"os"
+	"strings"
 
+	"bldy.build/build"
 	"bldy.build/build/file"
 	"bldy.build/build/label"
+	"bldy.build/build/racy"
 	"bldy.build/build/workspace"
 	"github.com/google/skylark"
 )
@@ -19,13 +23,19 @@ func processFiles(ctx *context, ruleAttrs *skylark.Dict, kwargs []skylark.Tuple,
 
 		switch x := attr.(type) {
 		case *labelAttr:
+			if arg == skylark.None {
+				return nil
+			}
 			l, ok := arg.(label.Label)
 			if !ok {
 				return fmt.Errorf("attribute %q should be of type list consisting of strings", name)
 			}
-			if x.AllowFiles {
+			if x.AllowFiles || x.AllowSingleFile || x.Executable {
 				f := file.New(l, lbl, ws)
 				if f.Exists() {
+					if err := checkFile(f, x.AllowdExtensionsList, x.Executable); err != nil {
+						return fmt.Errorf("%s: attribute %q: %v", lbl, name, err)
+					}
 					files = append(files, f.Path())
 				}
 				ctx.files[name] = f
@@ -47,6 +57,9 @@ func processFiles(ctx *context, ruleAttrs *skylark.Dict, kwargs []skylark.Tuple,
 					}
 					f := file.New(l, lbl, ws)
 					if f.Exists() {
+						if err := checkFile(f, x.AllowdExtensionsList, x.Executable); err != nil {
+							return fmt.Errorf("%s: attribute %q: %v", lbl, name, err)
+						}
 						files = append(files, f.Path())
 					}
 
@@ -58,4 +71,197 @@ func processFiles(ctx *context, ruleAttrs *skylark.Dict, kwargs []skylark.Tuple,
 		return nil
 	})
 	return files, err
-}
\ No newline at end of file
+}
+
+// analyzeFiles replaces the files of the label attributes that allow files
+// with the files their targets provide, so the implementation sees source
+// files and the outputs of other rules the same way.
+func analyzeFiles(ctx *context, ruleAttrs *skylark.Dict) {
+	WalkDict(ruleAttrs, func(kw skylark.Value, attr Attribute) error {
+		name := string(kw.(skylark.String))
+		switch x := attr.(type) {
+		case *labelAttr:
+			if !x.AllowFiles && !x.AllowSingleFile && !x.Executable {
+				return nil
+			}
+			if t, ok := ctx.analyzed[name].(*target); ok {
+				if files := t.files(); len(files) == 1 {
+					ctx.files[name] = files[0]
+				}
+			}
+		case *labelListAttr:
+			list, ok := ctx.analyzed[name].(*skylark.List)
+			if !x.AllowFiles || !ok {
+				return nil
+			}
+			files := []skylark.Value{}
+			for i := 0; i < list.Len(); i++ {
+				if t, ok := list.Index(i).(*target); ok {
+					files = append(files, t.files()...)
+				}
+			}
+			ctx.files[name] = skylark.NewList(files)
+		}
+		return nil
+	})
+}
+
+// checkFile checks the source file f has one of the extensions, if there are
+// any, and that it's executable if it has to be.
+func checkFile(f *file.File, exts []string, executable bool) error {
+	if len(exts) > 0 {
+		ok := false
+		for _, ext := range exts {
+			if strings.HasSuffix(f.Name(), ext) {
+				ok = true
+			}
+		}
+		if !ok {
+			return fmt.Errorf("%s doesn't have one of the extensions %q", f.Name(), exts)
+		}
+	}
+	if executable {
+		fi, err := os.Stat(f.Path())
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&0111 == 0 {
+			return fmt.Errorf("%s isn't executable", f.Name())
+		}
+	}
+	return nil
+}
+
+// sourceFile is a file in the workspace. Labels that don't name a target
+// declared in their package name the file at that path in it.
+// https://docs.bazel.build/versions/master/build-ref.html#files
+type sourceFile struct {
+	platformTarget
+	label      label.Label
+	visibility []label.Label
+}
+
+func newSourceFile(lbl label.Label, ws workspace.Workspace) *sourceFile {
+	return &sourceFile{
+		platformTarget: platformTarget{name: lbl.Name(), ws: ws},
+		label:          lbl,
+	}
+}
+
+// isSourceFile reports whether path is a regular file.
+func isSourceFile(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// Visibility returns the labels the file was exported to, files that aren't
+// exported are public.
+func (f *sourceFile) Visibility() []label.Label {
+	if len(f.visibility) == 0 {
+		return []label.Label{build.PublicVisibility}
+	}
+	return f.visibility
+}
+
+// Files returns the path of the file in the workspace.
+func (f *sourceFile) Files() []string { return []string{f.ws.File(f.label)} }
+
+// Providers returns DefaultInfo with the file.
+func (f *sourceFile) Providers() map[*Provider]*Info {
+	src := file.New(label.Label(f.label.Name()), f.label, f.ws)
+	files := &Depset{order: orderDefault, direct: []skylark.Value{src}, elemType: src.Type()}
+	return map[*Provider]*Info{
+		DefaultInfo: &Info{provider: DefaultInfo, fields: skylark.StringDict{"files": files}},
+	}
+}
+
+// Hash hashes the label and the contents of the file, files that don't
+// exist only hash their label.
+func (f *sourceFile) Hash() []byte {
+	h := racy.New()
+	h.HashNamed("source_file", f.label.String())
+	if err := h.HashFiles(f.ws.File(f.label)); err != nil {
+		h.HashNamed("missing", f.label.String())
+	}
+	return h.Sum(nil)
+}
+
+// outputFile is a file a rule generates. Its label is the path of the file
+// in the package of the rule, so targets can depend on generated files the
+// same way they depend on source files.
+// https://docs.bazel.build/versions/master/build-ref.html#files
+type outputFile struct {
+	platformTarget
+	label     label.Label
+	generator label.Label
+	rule      build.Rule
+}
+
+func newOutputFile(lbl, generator label.Label, r build.Rule, ws workspace.Workspace) *outputFile {
+	return &outputFile{
+		platformTarget: platformTarget{name: lbl.Name(), ws: ws},
+		label:          lbl,
+		generator:      generator,
+		rule:           r,
+	}
+}
+
+// Dependencies returns the rule that generates the file.
+func (f *outputFile) Dependencies() []label.Label { return []label.Label{f.generator} }
+
+// Outputs returns the path of the file, it's bound in from the rule that
+// generates it.
+func (f *outputFile) Outputs() []string { return []string{f.label.Name()} }
+
+// Files returns the path of the file relative to the build directory.
+func (f *outputFile) Files() []string { return f.Outputs() }
+
+// Visibility returns the visibility of the rule that generates the file.
+func (f *outputFile) Visibility() []label.Label {
+	if v, ok := f.rule.(build.Visible); ok {
+		return v.Visibility()
+	}
+	return []label.Label{build.PublicVisibility}
+}
+
+// Providers returns DefaultInfo with the file.
+func (f *outputFile) Providers() map[*Provider]*Info {
+	return map[*Provider]*Info{DefaultInfo: defaultInfo(f.Outputs())}
+}
+
+func (f *outputFile) Hash() []byte {
+	h := racy.New()
+	h.HashNamed("output_file", f.label.String(), f.generator.String())
+	return h.Sum(nil)
+}
+
+// exportsFiles declares the files srcs of the package as targets, so they can
+// be made visible to other packages.
+// https://docs.bazel.build/versions/master/be/functions.html#exports_files
+func (s *skylarkVM) exportsFiles(thread *skylark.Thread, fn *skylark.Builtin, args skylark.Tuple, kwargs []skylark.Tuple) (skylark.Value, error) {
+	var srcs, visibility, licenses *skylark.List
+	if err := skylark.UnpackArgs(fn.Name(), args, kwargs, "srcs", &srcs, "visibility?", &visibility, "licenses?", &licenses); err != nil {
+		return nil, err
+	}
+	pkg := getPkg(thread)
+	names, err := stringList(srcs)
+	if err != nil {
+		return nil, fmt.Errorf("exports_files: srcs: %v", err)
+	}
+	vis, err := pkgLabels(pkg, visibility)
+	if err != nil {
+		return nil, fmt.Errorf("exports_files: visibility: %v", err)
+	}
+	for i := 0; i < names.Len(); i++ {
+		lbl := label.New(pkg, string(names.Index(i).(skylark.String)))
+		if err := lbl.Valid(); err != nil {
+			return nil, fmt.Errorf("exports_files: %v", err)
+		}
+		f := newSourceFile(lbl, s.ws)
+		f.visibility = vis
+		if err := s.declare(thread, lbl, f); err != nil {
+			return nil, err
+		}
+	}
+	return skylark.None, nil
+}
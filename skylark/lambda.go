@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 
+	"bldy.build/build/label"
 	"github.com/google/skylark"
 )
 
@@ -11,17 +12,23 @@ func (s *skylarkVM) makeRule(thread *skylark.Thread, fn *skylark.Builtin, args s
 	var impl *skylark.Function
 	attrs := new(skylark.Dict)
 	outputs := new(skylark.Dict)
+	var toolchains *skylark.List
 
-	err := skylark.UnpackArgs(fn.Name(), args, kwargs, skylarkKeyImpl, &impl, skylarkKeyAttrs, &attrs, skylarkKeyOutputs, &outputs)
+	err := skylark.UnpackArgs(fn.Name(), args, kwargs, skylarkKeyImpl, &impl, skylarkKeyAttrs, &attrs, skylarkKeyOutputs, &outputs, skylarkKeyToolChains+"?", &toolchains)
 	if false && attrs != nil && err != nil {
 		log.Println(err)
 	}
+	types, err := pkgLabels(getPkg(thread), toolchains)
+	if err != nil {
+		return nil, fmt.Errorf("rule: toolchains: %v", err)
+	}
 
 	x := &lambdaFunc{
-		skyFunc: impl,
-		attrs:   attrs,
-		outputs: outputs,
-		vm:      s,
+		skyFunc:    impl,
+		attrs:      attrs,
+		outputs:    outputs,
+		toolchains: types,
+		vm:         s,
 	}
 
 	return x, nil
@@ -33,6 +40,11 @@ type lambdaFunc struct {
 	attrs   *skylark.Dict
 	vm      *skylarkVM
 	outputs *skylark.Dict
+	// toolchains are the types of the toolchains the rule needs.
+	toolchains []label.Label
+
+	// module is the digest of the module the rule was declared in.
+	module string
 }
 
 func (l *lambdaFunc) Call(thread *skylark.Thread, args skylark.Tuple, kwargs []skylark.Tuple) (skylark.Value, error) {
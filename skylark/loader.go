@@ -0,0 +1,306 @@
+// Copyright 2018 Sevki <s@sevki.org>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package skylark
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"bldy.build/build"
+	"bldy.build/build/label"
+	"bldy.build/build/racy"
+	"bldy.build/build/workspace"
+	"github.com/google/skylark"
+	"github.com/pkg/errors"
+	"sevki.org/x/debug"
+)
+
+// pkg is a package whose BUILD file is being, or has been, evaluated. done
+// is closed once it's been evaluated.
+type pkg struct {
+	done      chan struct{}
+	rules     map[string]build.Rule
+	positions map[string]string
+	// referrers are the targets that depend on the labels of the
+	// package, they are named in errors for labels that aren't targets or
+	// files.
+	referrers map[string]label.Label
+	err       error
+
+	// defaults are set by package(), declared is whether it's been called.
+	declared bool
+	defaults pkgDefaults
+}
+
+// module is a loaded skylark file. Its globals are frozen once it's been
+// executed so they can be shared between packages that are evaluated
+// concurrently. digest covers the contents of the file and the digests of
+// the modules it loads, it's folded in to the hashes of the rules it
+// declares.
+type module struct {
+	done    chan struct{}
+	globals skylark.StringDict
+	digest  string
+	err     error
+}
+
+// loading is the state of a thread that's executing a module.
+type loading struct {
+	// chain is the modules that are being loaded on the thread, the
+	// outermost first, and the module the thread is executing last.
+	chain []label.Label
+	// loaded is the modules the thread has loaded so far, in order.
+	loaded []*module
+}
+
+// LoadCycleError is returned when a module loads itself.
+type LoadCycleError struct {
+	// Cycle starts and ends with the same module.
+	Cycle []label.Label
+}
+
+func (e *LoadCycleError) Error() string {
+	lbls := make([]string, len(e.Cycle))
+	for i, lbl := range e.Cycle {
+		lbls[i] = lbl.String()
+	}
+	return "load cycle: " + strings.Join(lbls, " -> ")
+}
+
+// pkg returns the package name, evaluating its BUILD file if it hasn't
+// been evaluated. Every package is evaluated exactly once, callers asking
+// for a package that's being evaluated wait for it.
+func (s *skylarkVM) pkg(name string) (*pkg, error) {
+	s.mu.Lock()
+	p, ok := s.packages[name]
+	if !ok {
+		p = &pkg{
+			done:      make(chan struct{}),
+			rules:     make(map[string]build.Rule),
+			positions: make(map[string]string),
+			referrers: make(map[string]label.Label),
+		}
+		s.packages[name] = p
+	}
+	s.mu.Unlock()
+
+	if ok {
+		<-p.done
+		return p, p.err
+	}
+	p.err = s.execPackage(name, p)
+	close(p.done)
+	return p, p.err
+}
+
+// execPackage executes the BUILD file of the package name, the targets it
+// declares are added to p.
+func (s *skylarkVM) execPackage(name string, p *pkg) error {
+	if name == "" {
+		return errors.New("skylark vm can't figure out labels without packages, for the root package please use '.'.")
+	}
+	l := label.New(name, workspace.BUILDFILE)
+	bytz, err := s.ws.LoadBuildfile(l)
+	if err != nil {
+		return err
+	}
+
+	t := &skylark.Thread{}
+	t.Load = s.load
+	t.Print = print
+	t.SetLocal(threadKeyTargets, p)
+	initPkgStack(t)
+	pushPkg(t, name)
+
+	if _, err = skylark.ExecFile(t, s.ws.Buildfile(l), bytz, s.globals); err != nil {
+		if evalErr, ok := err.(*skylark.EvalError); ok {
+			// evaluation errors don't carry their position in the message
+			return fmt.Errorf("skylark: exec: %s: %s", evalErr.Frame.Position(), evalErr.Msg)
+		}
+		return errors.Wrap(err, "skylark: exec")
+	}
+	return nil
+}
+
+// declare adds the rule r to the targets of the package being executed.
+func (s *skylarkVM) declare(thread *skylark.Thread, lbl label.Label, r build.Rule) error {
+	p, ok := thread.Local(threadKeyTargets).(*pkg)
+	if !ok {
+		return fmt.Errorf("skylark: %s: rules can only be declared in BUILD files", lbl)
+	}
+	if f, ok := p.rules[lbl.String()].(*outputFile); ok {
+		return fmt.Errorf("skylark: %s: it's already an output of %s", lbl, f.generator)
+	}
+	p.rules[lbl.String()] = r
+	// the outputs the rule declares are targets too, they depend on the
+	// rule. An output named after the rule is the rule.
+	for _, o := range r.Outputs() {
+		olbl := label.New(lbl.Package(), o)
+		if olbl == lbl {
+			continue
+		}
+		if err := olbl.Valid(); err != nil {
+			return fmt.Errorf("skylark: %s: output %q: %v", lbl, o, err)
+		}
+		if _, ok := p.rules[olbl.String()]; ok {
+			return fmt.Errorf("skylark: %s: output %q conflicts with the target %s", lbl, o, olbl)
+		}
+		p.rules[olbl.String()] = newOutputFile(olbl, lbl, r, s.ws)
+	}
+	for _, d := range r.Dependencies() {
+		if _, ok := p.referrers[d.String()]; !ok && d.Package() == lbl.Package() {
+			p.referrers[d.String()] = lbl
+		}
+	}
+	if fr := thread.Caller(); fr != nil {
+		p.positions[lbl.String()] = fr.Position().String()
+	}
+	return nil
+}
+
+func (s *skylarkVM) load(thread *skylark.Thread, module string) (skylark.StringDict, error) {
+	pkg := getPkg(thread)
+	l, err := label.Parse(module)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "skylark.load")
+	}
+	if !l.IsAbs() {
+		l = label.New(pkg, l.Name())
+	}
+
+	var chain []label.Label
+	ld, _ := thread.Local(threadKeyLoading).(*loading)
+	if ld != nil {
+		chain = ld.chain
+	}
+	m, err := s.module(l, chain)
+	if err != nil {
+		buf := bytes.NewBuffer(nil)
+		debug.Indent(buf, 1)
+		thread.Caller().WriteBacktrace(buf)
+		return nil, fmt.Errorf("skylark.load: %s\n%s", err.Error(), buf.String())
+	}
+	if ld != nil {
+		ld.loaded = append(ld.loaded, m)
+	}
+	return m.globals, nil
+}
+
+// module returns the module l, executing it if it hasn't been loaded
+// before. chain is the modules that are being loaded on the calling
+// thread, it's used for finding load cycles.
+func (s *skylarkVM) module(l label.Label, chain []label.Label) (*module, error) {
+	for i, c := range chain {
+		if c == l {
+			return nil, &LoadCycleError{append(chain[i:len(chain):len(chain)], l)}
+		}
+	}
+
+	s.mu.Lock()
+	m, ok := s.modules[l.String()]
+	if !ok {
+		m = &module{done: make(chan struct{})}
+		s.modules[l.String()] = m
+	}
+	if len(chain) > 0 {
+		// a module that is loaded on another goroutine can't be
+		// waited for if it's waiting for this one.
+		from := chain[len(chain)-1]
+		if cycle := s.waitCycle(from, l); cycle != nil {
+			s.mu.Unlock()
+			return nil, &LoadCycleError{cycle}
+		}
+		s.waits[from.String()] = l
+		defer func() {
+			s.mu.Lock()
+			delete(s.waits, from.String())
+			s.mu.Unlock()
+		}()
+	}
+	s.mu.Unlock()
+
+	if ok {
+		<-m.done
+		return m, m.err
+	}
+	m.globals, m.digest, m.err = s.execModule(l, chain)
+	close(m.done)
+	return m, m.err
+}
+
+// waitCycle returns the cycle waiting for to from would cause, or nil if
+// it wouldn't cause one.
+func (s *skylarkVM) waitCycle(from, to label.Label) []label.Label {
+	cycle := []label.Label{from, to}
+	for next := to; next != from; {
+		var ok bool
+		if next, ok = s.waits[next.String()]; !ok {
+			return nil
+		}
+		cycle = append(cycle, next)
+	}
+	return cycle
+}
+
+func (s *skylarkVM) execModule(l label.Label, chain []label.Label) (skylark.StringDict, string, error) {
+	file := s.ws.File(l)
+	if path.Ext(l.Name()) == "" {
+		file = s.ws.Buildfile(l)
+	}
+	bytz, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// modules get their own threads, they aren't part of any package so
+	// they can't declare targets.
+	t := &skylark.Thread{}
+	t.Load = s.load
+	t.Print = print
+	ld := &loading{chain: append(chain[:len(chain):len(chain)], l)}
+	t.SetLocal(threadKeyLoading, ld)
+	initPkgStack(t)
+	pushPkg(t, l.Package())
+
+	dict, err := skylark.ExecFile(t, file, bytz, s.globals)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "exec")
+	}
+
+	h := racy.NewHash()
+	h.Write(bytz)
+	for _, m := range ld.loaded {
+		io.WriteString(h, m.digest)
+	}
+	digest := fmt.Sprintf("%x", h.Sum(nil))
+
+	exportRules(dict, digest)
+	dict.Freeze()
+	return dict, digest, nil
+}
+
+// exportRules names the rules a module exports after the global they are
+// assigned to, which is what their kind is, and records the digest of the
+// module they were declared in. Providers are named the same way.
+func exportRules(dict skylark.StringDict, digest string) {
+	for name, v := range dict {
+		switch v := v.(type) {
+		case *lambdaFunc:
+			if v.name == "" {
+				v.name = name
+				v.module = digest
+			}
+		case *Provider:
+			if v.name == "" {
+				v.name = name
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package skylark
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bldy.build/build/label"
+	"bldy.build/build/workspace"
+)
+
+// testWorkspace writes files in to a new workspace and returns a vm for it.
+func testWorkspace(t *testing.T, files map[string]string) *skylarkVM {
+	dir, err := ioutil.TempDir("", "bldy_loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := files["WORKSPACE"]; !ok {
+		files["WORKSPACE"] = ""
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ws, err := workspace.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, _ := New(ws)
+	return vm.(*skylarkVM)
+}
+
+const testRule = `
+def _impl(ctx):
+    ctx.actions.do_nothing(mnemonic = "nothing")
+
+test = rule(
+    attrs = {},
+    implementation = _impl,
+)
+`
+
+func TestLoadCycle(t *testing.T) {
+	vm := testWorkspace(t, map[string]string{
+		"a.sky": `load("//.:b.sky", "b")` + "\na = 1\n",
+		"b.sky": `load("//.:a.sky", "a")` + "\nb = 1\n",
+		"BUILD": `load("//.:a.sky", "a")`,
+	})
+	defer os.RemoveAll(vm.ws.AbsPath())
+	_, err := vm.GetTarget("//.:x")
+	if err == nil || !strings.Contains(err.Error(), "load cycle: //.:a.sky -> //.:b.sky -> //.:a.sky") {
+		t.Logf("was expecting a load cycle got %v instead", err)
+		t.Fail()
+	}
+}
+
+func TestLoadFrozen(t *testing.T) {
+	vm := testWorkspace(t, map[string]string{
+		"defs.sky": "names = []\n",
+		"BUILD":    `load("//.:defs.sky", "names")` + "\nnames.append(1)\n",
+	})
+	defer os.RemoveAll(vm.ws.AbsPath())
+	_, err := vm.GetTarget("//.:x")
+	if err == nil || !strings.Contains(err.Error(), "frozen") {
+		t.Logf("was expecting the loaded list to be frozen got %v instead", err)
+		t.Fail()
+	}
+}
+
+func TestModuleDigest(t *testing.T) {
+	hash := func(consts string) []byte {
+		vm := testWorkspace(t, map[string]string{
+			"consts.sky": consts,
+			"defs.sky":   `load("//.:consts.sky", "version")` + "\n" + testRule,
+			"BUILD":      `load("//.:defs.sky", "test")` + "\ntest(name = \"x\")\n",
+		})
+		defer os.RemoveAll(vm.ws.AbsPath())
+		r, err := vm.GetTarget(label.Label("//.:x"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind := r.(*Rule).Kind(); kind != "test" {
+			t.Logf("was expecting the rule to be exported as test got %q instead", kind)
+			t.Fail()
+		}
+		return r.Hash()
+	}
+	if bytes.Equal(hash("version = 1\n"), hash("version = 2\n")) {
+		t.Log("changing a module defs.sky loads should change the hash of its rules")
+		t.Fail()
+	}
+}
@@ -1,7 +1,7 @@
 package skylark
 
 import (
-	"log"
+	"fmt"
 	"reflect"
 
 	"bldy.build/build"
@@ -19,37 +19,69 @@ func (s *skylarkVM) makeNativeRule(thread *skylark.Thread, fn *skylark.Builtin,
 		strct, err := internal.GetFieldByTag(fn.Name(), string(kwarg.Index(0).(skylark.String)), t)
 
 		if err != nil {
-			log.Println(errors.Wrap(err, "make native rule"))
+			return nil, errors.Wrap(err, "make native rule")
 		}
 		f := newStruct.FieldByName(strct.Name)
-		v := kwarg.Index(1)
-		switch f.Interface().(type) {
-		case string:
-			if s, ok := v.(skylark.String); ok {
-				f.SetString(string(s))
-			}
-		case []string:
-			if labels, ok := v.(*skylark.List); ok {
-				var stringList []string
-				i := labels.Iterate()
-				var p skylark.Value
-				for i.Next(&p) {
-					if s, ok := p.(skylark.String); ok {
-						stringList = append(stringList, string(s))
-					}
-				}
-				f.Set(reflect.ValueOf(stringList))
-			}
-		case bool:
-			if b, ok := v.(skylark.Bool); ok {
-				f.SetBool(bool(b))
+		if err := setNativeAttr(f, kwarg.Index(1)); err != nil {
+			return nil, fmt.Errorf("%s: attribute %q: %v", fn.Name(), string(kwarg.Index(0).(skylark.String)), err)
+		}
+	}
+	// rules that don't set their visibility get the default visibility of
+	// the package
+	if _, ok := findArg(skylark.String(skylarkKeyVisibility), kwargs); !ok {
+		if strct, err := internal.GetFieldByTag(fn.Name(), skylarkKeyVisibility, t); err == nil {
+			var visibility []string
+			for _, lbl := range packageDefaults(thread).visibility {
+				visibility = append(visibility, lbl.String())
 			}
+			newStruct.FieldByName(strct.Name).Set(reflect.ValueOf(visibility))
 		}
 	}
 	pkg := getPkg(thread)
 
 	newNativeRule := newReflectType.Interface().(build.Rule)
 	lbl := label.New(pkg, newNativeRule.Name())
-	s.rules[lbl.String()] = newNativeRule
+	if d, ok := newNativeRule.(build.Declarable); ok {
+		if err := d.Declare(lbl, s.ws); err != nil {
+			return nil, errors.Wrapf(err, "%s", fn.Name())
+		}
+	}
+	if err := s.declare(thread, lbl, newNativeRule); err != nil {
+		return nil, err
+	}
 	return skylark.None, nil
 }
+
+// setNativeAttr sets the field f of a native rule to v.
+func setNativeAttr(f reflect.Value, v skylark.Value) error {
+	if _, ok := v.(*selector); ok {
+		return fmt.Errorf("native rules can't select() their attributes")
+	}
+	switch f.Interface().(type) {
+	case string:
+		s, ok := v.(skylark.String)
+		if !ok {
+			return fmt.Errorf("has to be a string, not %s", v.Type())
+		}
+		f.SetString(string(s))
+	case []string:
+		list, err := stringList(v)
+		if err != nil {
+			return err
+		}
+		strs := []string{}
+		for i := 0; i < list.Len(); i++ {
+			strs = append(strs, string(list.Index(i).(skylark.String)))
+		}
+		f.Set(reflect.ValueOf(strs))
+	case bool:
+		b, ok := v.(skylark.Bool)
+		if !ok {
+			return fmt.Errorf("has to be a bool, not %s", v.Type())
+		}
+		f.SetBool(bool(b))
+	default:
+		return fmt.Errorf("native rules can't have %s attributes", f.Type())
+	}
+	return nil
+}
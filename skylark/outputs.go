@@ -1,6 +1,8 @@
 package skylark
 
 import (
+	"fmt"
+
 	"github.com/google/skylark"
 	"github.com/google/skylark/skylarkstruct"
 	"github.com/pkg/errors"
@@ -11,8 +13,8 @@ import (
 // https://docs.bazel.build/versions/master/skylark/lib/ctx.html#outputs
 func processOutputs(ctx *context, ruleAttrs *skylark.Dict, ruleOutputs *skylark.Dict) ([]string, error) {
 	outputs := []string{}
+	outs := skylark.StringDict{}
 	if ruleOutputs != nil && len(ruleOutputs.Keys()) > 0 {
-		outs := skylark.StringDict{}
 		for _, tup := range ruleOutputs.Items() {
 			if formatted, err := format(tup[1].(skylark.String), ctx.Attrs()); err == nil {
 				outputs = append(outputs, formatted)
@@ -22,8 +24,45 @@ func processOutputs(ctx *context, ruleAttrs *skylark.Dict, ruleOutputs *skylark.
 				}
 			}
 		}
+	}
+	// outputs named by the output and output_list attributes are
+	// outputs too.
+	err := WalkDict(ruleAttrs, func(kw skylark.Value, attr Attribute) error {
+		name := string(kw.(skylark.String))
+		var named []skylark.Value
+		switch attr.(type) {
+		case *outputAttr:
+			out, ok := ctx.attrs[name].(output)
+			if !ok {
+				return nil
+			}
+			named = []skylark.Value{out}
+			outs[name] = out
+		case *outputListAttr:
+			list, ok := ctx.attrs[name].(*skylark.List)
+			if !ok {
+				return nil
+			}
+			for i := 0; i < list.Len(); i++ {
+				named = append(named, list.Index(i))
+			}
+			outs[name] = list
+		}
+		for _, out := range named {
+			for _, o := range outputs {
+				if o == out.String() {
+					return fmt.Errorf("%q is already an output of the rule", o)
+				}
+			}
+			outputs = append(outputs, out.String())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(outs) > 0 {
 		ctx.outputs = skylarkstruct.FromStringDict(skylarkstruct.Default, outs)
-		return outputs, nil
 	}
 	return outputs, nil
 }
@@ -31,20 +70,25 @@ func processOutputs(ctx *context, ruleAttrs *skylark.Dict, ruleOutputs *skylark.
 type output string
 
 func (f output) String() string        { return string(f) }
-func (f output) Type() string          { return "file" }
+func (f output) Type() string          { return "File" }
 func (f output) Freeze()               {}
 func (f output) Truth() skylark.Bool   { return true }
 func (f output) Hash() (uint32, error) { return hashString(string(f)), nil }
 
+// Path returns the path of the output relative to the output directory.
+func (f output) Path() string { return string(f) }
+
 func (f output) Attr(name string) (skylark.Value, error) {
 	switch name {
 	case "path":
 		return skylark.String(string(f)), nil
+	case "is_directory":
+		return skylark.False, nil
 	default:
 		return nil, errors.New("not implemented")
 	}
 }
 
 func (f output) AttrNames() []string {
-	panic("not implemented")
+	return []string{"is_directory", "path"}
 }
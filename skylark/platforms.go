@@ -0,0 +1,436 @@
+package skylark
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"bldy.build/build"
+	"bldy.build/build/executor"
+	"bldy.build/build/label"
+	"bldy.build/build/racy"
+	"bldy.build/build/workspace"
+	"github.com/google/skylark"
+	"github.com/google/skylark/skylarkstruct"
+	"github.com/pkg/errors"
+)
+
+// ToolchainInfo is the provider toolchain implementations return, rules see
+// it as ctx.toolchains[<toolchain type>].
+// https://docs.bazel.build/versions/master/skylark/lib/ToolchainInfo.html
+var ToolchainInfo = &Provider{name: "ToolchainInfo"}
+
+var platformCommon = skylarkstruct.FromStringDict(skylark.String("platform_common"), skylark.StringDict{
+	"ToolchainInfo": ToolchainInfo,
+})
+
+// platformTarget is the base of the targets that describe platforms and
+// toolchains, none of them build anything.
+type platformTarget struct {
+	name string
+	ws   workspace.Workspace
+}
+
+func (p *platformTarget) Name() string                   { return p.name }
+func (p *platformTarget) Dependencies() []label.Label    { return nil }
+func (p *platformTarget) Outputs() []string              { return nil }
+func (p *platformTarget) Build(*executor.Executor) error { return nil }
+func (p *platformTarget) Platform() label.Label          { return build.DefaultPlatform }
+func (p *platformTarget) Workspace() workspace.Workspace { return p.ws }
+
+// constraintSetting is a property platforms can have, like the os. Platforms
+// that don't have a value for it have its default value.
+// https://docs.bazel.build/versions/master/be/platform.html#constraint_setting
+type constraintSetting struct {
+	platformTarget
+	defaultValue label.Label
+}
+
+func (s *skylarkVM) constraintSetting(thread *skylark.Thread, fn *skylark.Builtin, args skylark.Tuple, kwargs []skylark.Tuple) (skylark.Value, error) {
+	var name, defaultValue string
+	if err := skylark.UnpackArgs(fn.Name(), args, kwargs, "name", &name, "default_constraint_value?", &defaultValue); err != nil {
+		return nil, err
+	}
+	pkg := getPkg(thread)
+	c := &constraintSetting{platformTarget: platformTarget{name: name, ws: s.ws}}
+	if defaultValue != "" {
+		var err error
+		if c.defaultValue, err = pkgLabel(pkg, skylark.String(defaultValue)); err != nil {
+			return nil, fmt.Errorf("constraint_setting: default_constraint_value: %v", err)
+		}
+	}
+	return skylark.None, s.declare(thread, label.New(pkg, name), c)
+}
+
+func (c *constraintSetting) Hash() []byte {
+	h := racy.New()
+	h.HashNamed("constraint_setting", c.name, c.defaultValue.String())
+	return h.Sum(nil)
+}
+
+// constraintValue is one of the values of a constraint setting, like linux.
+// https://docs.bazel.build/versions/master/be/platform.html#constraint_value
+type constraintValue struct {
+	platformTarget
+	setting label.Label
+}
+
+func (s *skylarkVM) constraintValue(thread *skylark.Thread, fn *skylark.Builtin, args skylark.Tuple, kwargs []skylark.Tuple) (skylark.Value, error) {
+	var name, setting string
+	if err := skylark.UnpackArgs(fn.Name(), args, kwargs, "name", &name, "constraint_setting", &setting); err != nil {
+		return nil, err
+	}
+	pkg := getPkg(thread)
+	c := &constraintValue{platformTarget: platformTarget{name: name, ws: s.ws}}
+	var err error
+	if c.setting, err = pkgLabel(pkg, skylark.String(setting)); err != nil {
+		return nil, fmt.Errorf("constraint_value: constraint_setting: %v", err)
+	}
+	return skylark.None, s.declare(thread, label.New(pkg, name), c)
+}
+
+func (c *constraintValue) Hash() []byte {
+	h := racy.New()
+	h.HashNamed("constraint_value", c.name, c.setting.String())
+	return h.Sum(nil)
+}
+
+// platform is a set of constraint values targets can be built for.
+// https://docs.bazel.build/versions/master/be/platform.html#platform
+type platform struct {
+	platformTarget
+	constraints []label.Label
+}
+
+func (s *skylarkVM) platform(thread *skylark.Thread, fn *skylark.Builtin, args skylark.Tuple, kwargs []skylark.Tuple) (skylark.Value, error) {
+	var name string
+	var constraintValues *skylark.List
+	if err := skylark.UnpackArgs(fn.Name(), args, kwargs, "name", &name, "constraint_values?", &constraintValues); err != nil {
+		return nil, err
+	}
+	pkg := getPkg(thread)
+	p := &platform{platformTarget: platformTarget{name: name, ws: s.ws}}
+	var err error
+	if p.constraints, err = pkgLabels(pkg, constraintValues); err != nil {
+		return nil, fmt.Errorf("platform: constraint_values: %v", err)
+	}
+	return skylark.None, s.declare(thread, label.New(pkg, name), p)
+}
+
+// ConstraintValues returns the constraint values of the platform.
+func (p *platform) ConstraintValues() []label.Label { return p.constraints }
+
+func (p *platform) Hash() []byte {
+	h := racy.New()
+	h.HashNamed("platform", p.name)
+	for _, c := range p.constraints {
+		h.HashNamed("constraint", c.String())
+	}
+	return h.Sum(nil)
+}
+
+// toolchainType is the type rules ask for when they need a toolchain.
+// https://docs.bazel.build/versions/master/toolchains.html#writing-rules-that-use-toolchains
+type toolchainType struct {
+	platformTarget
+}
+
+func (s *skylarkVM) toolchainType(thread *skylark.Thread, fn *skylark.Builtin, args skylark.Tuple, kwargs []skylark.Tuple) (skylark.Value, error) {
+	var name string
+	if err := skylark.UnpackArgs(fn.Name(), args, kwargs, "name", &name); err != nil {
+		return nil, err
+	}
+	pkg := getPkg(thread)
+	t := &toolchainType{platformTarget{name: name, ws: s.ws}}
+	return skylark.None, s.declare(thread, label.New(pkg, name), t)
+}
+
+func (t *toolchainType) Hash() []byte {
+	h := racy.New()
+	h.HashNamed("toolchain_type", t.name)
+	return h.Sum(nil)
+}
+
+// toolchain declares the target that implements a toolchain type and the
+// platforms it can be used on.
+// https://docs.bazel.build/versions/master/be/platform.html#toolchain
+type toolchain struct {
+	platformTarget
+	vm           *skylarkVM
+	typ, impl    label.Label
+	exec, target []label.Label
+}
+
+func (s *skylarkVM) toolchain(thread *skylark.Thread, fn *skylark.Builtin, args skylark.Tuple, kwargs []skylark.Tuple) (skylark.Value, error) {
+	var name, typ, impl string
+	var exec, target *skylark.List
+	if err := skylark.UnpackArgs(fn.Name(), args, kwargs,
+		"name", &name,
+		"toolchain_type", &typ,
+		"toolchain", &impl,
+		"exec_compatible_with?", &exec,
+		"target_compatible_with?", &target,
+	); err != nil {
+		return nil, err
+	}
+	pkg := getPkg(thread)
+	t := &toolchain{platformTarget: platformTarget{name: name, ws: s.ws}, vm: s}
+	var err error
+	if t.typ, err = pkgLabel(pkg, skylark.String(typ)); err != nil {
+		return nil, fmt.Errorf("toolchain: toolchain_type: %v", err)
+	}
+	if t.impl, err = pkgLabel(pkg, skylark.String(impl)); err != nil {
+		return nil, fmt.Errorf("toolchain: toolchain: %v", err)
+	}
+	if t.exec, err = pkgLabels(pkg, exec); err != nil {
+		return nil, fmt.Errorf("toolchain: exec_compatible_with: %v", err)
+	}
+	if t.target, err = pkgLabels(pkg, target); err != nil {
+		return nil, fmt.Errorf("toolchain: target_compatible_with: %v", err)
+	}
+	return skylark.None, s.declare(thread, label.New(pkg, name), t)
+}
+
+func (t *toolchain) ToolchainType() label.Label { return t.typ }
+func (t *toolchain) Toolchain() label.Label     { return t.impl }
+
+// Matches reports whether exec has every constraint the toolchain needs to
+// run and target every constraint it needs to build for.
+func (t *toolchain) Matches(exec, target []label.Label) bool {
+	return t.vm.hasConstraints(exec, t.exec) && t.vm.hasConstraints(target, t.target)
+}
+
+func (t *toolchain) Hash() []byte {
+	h := racy.New()
+	h.HashNamed("toolchain", t.name, t.typ.String(), t.impl.String())
+	for _, c := range t.exec {
+		h.HashNamed("exec_compatible_with", c.String())
+	}
+	for _, c := range t.target {
+		h.HashNamed("target_compatible_with", c.String())
+	}
+	return h.Sum(nil)
+}
+
+// RegisteredToolchains returns the toolchains the WORKSPACE file registers
+// with register_toolchains, in the order they were registered in.
+// Registering //<package>:all registers every toolchain in the package.
+// The toolchain types of the toolchains have to be toolchain_types.
+func (s *skylarkVM) RegisteredToolchains() ([]label.Label, error) {
+	lbls, err := s.registeredToolchains()
+	if err != nil {
+		return nil, err
+	}
+	for _, lbl := range lbls {
+		r, err := s.GetTarget(lbl)
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := r.(*toolchain); ok {
+			if err := s.checkToolchainType(t); err != nil {
+				return nil, errors.Wrapf(err, "toolchain %s", lbl)
+			}
+		}
+	}
+	return lbls, nil
+}
+
+// checkToolchainType checks the toolchain type of t is a toolchain_type.
+func (s *skylarkVM) checkToolchainType(t *toolchain) error {
+	r, err := s.GetTarget(t.typ)
+	if err != nil {
+		return errors.Wrap(err, "toolchain_type")
+	}
+	if _, ok := r.(*toolchainType); !ok {
+		return fmt.Errorf("toolchain_type: %s isn't a toolchain_type", t.typ)
+	}
+	return nil
+}
+
+func (s *skylarkVM) registeredToolchains() ([]label.Label, error) {
+	s.workspaceOnce.Do(func() { s.workspaceErr = s.execWorkspace() })
+	if s.workspaceErr != nil {
+		return nil, s.workspaceErr
+	}
+	lbls := []label.Label{}
+	for _, lbl := range s.registered {
+		if lbl.Name() != "all" {
+			lbls = append(lbls, lbl)
+			continue
+		}
+		targets, err := s.Targets(lbl.Package())
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range targets {
+			r, err := s.GetTarget(t)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := r.(build.Toolchain); ok {
+				lbls = append(lbls, t)
+			}
+		}
+	}
+	return lbls, nil
+}
+
+// execWorkspace executes the WORKSPACE file, it's only ever executed once.
+func (s *skylarkVM) execWorkspace() error {
+	file := filepath.Join(s.ws.AbsPath(), "WORKSPACE")
+	bytz, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	t := &skylark.Thread{}
+	t.Load = s.load
+	t.Print = print
+	initPkgStack(t)
+	pushPkg(t, ".")
+	globals := skylark.StringDict{
+		"register_toolchains": skylark.NewBuiltin("register_toolchains", s.registerToolchains),
+	}
+	if _, err := skylark.ExecFile(t, file, bytz, globals); err != nil {
+		if evalErr, ok := err.(*skylark.EvalError); ok {
+			return fmt.Errorf("skylark: exec: %s: %s", evalErr.Frame.Position(), evalErr.Msg)
+		}
+		return errors.Wrap(err, "skylark: exec")
+	}
+	return nil
+}
+
+func (s *skylarkVM) registerToolchains(thread *skylark.Thread, fn *skylark.Builtin, args skylark.Tuple, kwargs []skylark.Tuple) (skylark.Value, error) {
+	if len(kwargs) > 0 {
+		return nil, fmt.Errorf("%s: unexpected keyword arguments", fn.Name())
+	}
+	for _, arg := range args {
+		lbl, err := pkgLabel(getPkg(thread), arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+		}
+		s.registered = append(s.registered, lbl)
+	}
+	return skylark.None, nil
+}
+
+// toolchainContext is ctx.toolchains, it's indexed by toolchain types and
+// returns the ToolchainInfo of the toolchain that was picked for the type.
+type toolchainContext struct {
+	pkg   string
+	infos map[label.Label]*Info
+}
+
+func (t *toolchainContext) Freeze()             {}
+func (t *toolchainContext) Truth() skylark.Bool { return len(t.infos) > 0 }
+func (t *toolchainContext) Type() string        { return "toolchain_context" }
+func (t *toolchainContext) String() string {
+	return fmt.Sprintf("<toolchain_context %d>", len(t.infos))
+}
+func (t *toolchainContext) Hash() (uint32, error) {
+	return 0, fmt.Errorf("unhashable type: toolchain_context")
+}
+
+func (t *toolchainContext) Get(k skylark.Value) (skylark.Value, bool, error) {
+	typ, err := pkgLabel(t.pkg, k)
+	if err != nil {
+		return nil, false, err
+	}
+	info, ok := t.infos[typ]
+	if !ok {
+		return nil, false, fmt.Errorf("the rule doesn't ask for toolchains of type %s", typ)
+	}
+	return info, true, nil
+}
+
+// pkgLabel converts v to a label, relative labels are relative to pkg.
+func pkgLabel(pkg string, v skylark.Value) (label.Label, error) {
+	lbl, err := toLabel(v)
+	if err != nil {
+		return "", err
+	}
+	if !lbl.IsAbs() && lbl.Repo() == "" {
+		lbl = label.New(pkg, lbl.Name())
+	}
+	return lbl, nil
+}
+
+// pkgLabels converts list to labels, relative labels are relative to pkg.
+func pkgLabels(pkg string, list *skylark.List) ([]label.Label, error) {
+	if list == nil {
+		return nil, nil
+	}
+	lbls := []label.Label{}
+	for i := 0; i < list.Len(); i++ {
+		lbl, err := pkgLabel(pkg, list.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		lbls = append(lbls, lbl)
+	}
+	return lbls, nil
+}
+
+// hasConstraints reports whether a platform with the constraint values have
+// has every constraint value in want. Platforms that don't have a value for
+// a constraint setting have its default value.
+func (s *skylarkVM) hasConstraints(have, want []label.Label) bool {
+	for _, w := range want {
+		if !hasAll(have, []label.Label{w}) && !s.isDefault(have, w) {
+			return false
+		}
+	}
+	return true
+}
+
+// isDefault reports whether value is the default value of its constraint
+// setting and none of the constraint values in have are values of it.
+func (s *skylarkVM) isDefault(have []label.Label, value label.Label) bool {
+	setting, ok := s.setting(value)
+	if !ok || setting.defaultValue != value {
+		return false
+	}
+	for _, h := range have {
+		if other, ok := s.setting(h); ok && other == setting {
+			return false
+		}
+	}
+	return true
+}
+
+// setting returns the constraint setting of the constraint value lbl, labels
+// that aren't constraint values don't have one.
+func (s *skylarkVM) setting(lbl label.Label) (*constraintSetting, bool) {
+	t, err := s.GetTarget(lbl)
+	if err != nil {
+		return nil, false
+	}
+	v, ok := t.(*constraintValue)
+	if !ok {
+		return nil, false
+	}
+	if t, err = s.GetTarget(v.setting); err != nil {
+		return nil, false
+	}
+	setting, ok := t.(*constraintSetting)
+	return setting, ok
+}
+
+// hasAll reports whether have has every label in want.
+func hasAll(have, want []label.Label) bool {
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			if h == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,244 @@
+package skylark
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"bldy.build/build"
+	"bldy.build/build/label"
+)
+
+const testToolchains = `
+def _toolchain(ctx):
+    return [platform_common.ToolchainInfo(compiler = ctx.attrs.compiler)]
+
+cc_toolchain = rule(
+    attrs = {"compiler": attr.string()},
+    implementation = _toolchain,
+)
+
+def _impl(ctx):
+    print(ctx.toolchains[":cc"].compiler)
+
+use_cc = rule(
+    attrs = {},
+    implementation = _impl,
+    toolchains = [":cc"],
+)
+`
+
+const testToolchainsBuild = `load("defs.sky", "cc_toolchain", "use_cc")
+
+constraint_setting(name = "os")
+
+constraint_value(
+    name = "plan9",
+    constraint_setting = ":os",
+)
+
+platform(
+    name = "p9",
+    constraint_values = [":plan9"],
+)
+
+toolchain_type(name = "cc")
+
+cc_toolchain(
+    name = "9c_impl",
+    compiler = "9c",
+)
+
+toolchain(
+    name = "9c",
+    toolchain_type = ":cc",
+    toolchain = ":9c_impl",
+    target_compatible_with = [":plan9"],
+)
+
+use_cc(name = "x")
+`
+
+func TestRegisteredToolchains(t *testing.T) {
+	tests := []struct {
+		name      string
+		workspace string
+		build     string
+		want      []label.Label
+		err       string
+	}{
+		{"none", "", "", []label.Label{}, ""},
+		{"label", `register_toolchains("//.:9c")`, "", []label.Label{"//.:9c"}, ""},
+		{"all", `register_toolchains("//.:all")`, "", []label.Label{"//.:9c"}, ""},
+		{"not a label", `register_toolchains(1)`, "", nil, "register_toolchains: has to be a label, not int"},
+		{"not a toolchain type", `register_toolchains("//.:all")`, `toolchain(name = "bad", toolchain_type = ":9c_impl", toolchain = ":9c_impl")`, nil, "toolchain //.:bad: toolchain_type: //.:9c_impl isn't a toolchain_type"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vm := testWorkspace(t, map[string]string{
+				"WORKSPACE": test.workspace,
+				"defs.sky":  testToolchains,
+				"BUILD":     testToolchainsBuild + test.build,
+			})
+			defer os.RemoveAll(vm.ws.AbsPath())
+			got, err := vm.RegisteredToolchains()
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Logf("was expecting %q got %v instead", test.err, err)
+					t.Fail()
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(test.want) || (len(got) > 0 && got[0] != test.want[0]) {
+				t.Logf("was expecting %v got %v instead", test.want, got)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestToolchains(t *testing.T) {
+	vm := testWorkspace(t, map[string]string{
+		"defs.sky": testToolchains,
+		"BUILD":    testToolchainsBuild,
+	})
+	defer os.RemoveAll(vm.ws.AbsPath())
+
+	p, err := vm.GetTarget("//.:p9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	constraints := p.(build.Platform).ConstraintValues()
+	tc, err := vm.GetTarget("//.:9c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tc.(build.Toolchain).Matches(build.HostConstraints, constraints) {
+		t.Log("was expecting //.:9c to build for //.:p9")
+		t.Fail()
+	}
+	if tc.(build.Toolchain).Matches(build.HostConstraints, build.HostConstraints) {
+		t.Log("wasn't expecting //.:9c to build for the host")
+		t.Fail()
+	}
+
+	x, err := vm.GetTarget("//.:x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := x.(*Rule)
+	if types := r.ToolchainTypes(); len(types) != 1 || types[0] != "//.:cc" {
+		t.Fatalf("was expecting //.:x to need //.:cc got %v instead", types)
+	}
+	if err := r.SetToolchains(map[label.Label]label.Label{"//.:cc": "//.:9c_impl"}); err != nil {
+		t.Fatal(err)
+	}
+	if deps := r.Dependencies(); len(deps) != 1 || deps[0] != "//.:9c_impl" {
+		t.Logf("was expecting //.:x to depend on the toolchain got %v instead", deps)
+		t.Fail()
+	}
+	if _, err := analyze(vm, "//.:x"); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.ctx.buf.String(); got != "9c" {
+		t.Logf("was expecting the toolchain to be 9c got %q instead", got)
+		t.Fail()
+	}
+}
+
+func TestCompatibleWith(t *testing.T) {
+	p9 := &build.Config{Platform: "//.:p9", Constraints: []label.Label{"//.:plan9"}}
+	tests := []struct {
+		name   string
+		attrs  string
+		config *build.Config
+		err    string
+	}{
+		{"any", ``, p9, ""},
+		{"constraint", `compatible_with = [":plan9"]`, p9, ""},
+		{"platform", `restricted_to = [":p9", ":linux"]`, p9, ""},
+		{"not compatible", `compatible_with = [":linux"]`, p9, "it's only compatible with //.:linux"},
+		{"restricted", `restricted_to = [":linux"]`, p9, "it's restricted to [//.:linux]"},
+		{"not a list", `compatible_with = ":linux"`, p9, `//.:x: attribute "compatible_with": has to be a list of labels, not string`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vm := testWorkspace(t, map[string]string{
+				"defs.sky": testToolchains,
+				"BUILD":    `load("defs.sky", "cc_toolchain")` + "\n" + `cc_toolchain(name = "x", ` + test.attrs + ")\n",
+			})
+			defer os.RemoveAll(vm.ws.AbsPath())
+			r, err := vm.GetTarget("//.:x")
+			if err == nil {
+				err = r.(build.Restricted).CompatibleWith(test.config)
+			}
+			if test.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Logf("was expecting %q got %v instead", test.err, err)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestConstraintDefaults(t *testing.T) {
+	vm := testWorkspace(t, map[string]string{
+		"defs.sky": testToolchains,
+		"BUILD": `load("defs.sky", "cc_toolchain")
+
+constraint_setting(name = "os")
+
+constraint_value(name = "plan9", constraint_setting = ":os")
+
+constraint_setting(name = "cpu", default_constraint_value = ":mips")
+
+constraint_value(name = "mips", constraint_setting = ":cpu")
+
+constraint_value(name = "arm", constraint_setting = ":cpu")
+
+config_setting(name = "is_mips", constraint_values = [":mips"])
+
+cc_toolchain(name = "x", compatible_with = [":mips"])
+`,
+	})
+	defer os.RemoveAll(vm.ws.AbsPath())
+
+	tests := []struct {
+		name        string
+		constraints []label.Label
+		matches     bool
+	}{
+		{"default", []label.Label{"//.:plan9"}, true},
+		{"set", []label.Label{"//.:plan9", "//.:mips"}, true},
+		{"other value", []label.Label{"//.:plan9", "//.:arm"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &build.Config{Platform: "//.:p", Constraints: test.constraints}
+			c, err := vm.GetTarget("//.:is_mips")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if matches := c.(build.Condition).Matches(config); matches != test.matches {
+				t.Logf("was expecting the setting to match=%t got %t instead", test.matches, matches)
+				t.Fail()
+			}
+			x, err := vm.GetTarget("//.:x")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if compatible := x.(build.Restricted).CompatibleWith(config) == nil; compatible != test.matches {
+				t.Logf("was expecting the rule to be compatible=%t got %t instead", test.matches, compatible)
+				t.Fail()
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package skylark
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"bldy.build/build/label"
+	"github.com/google/skylark"
+	"github.com/pkg/errors"
+)
+
+// Provider is a declared provider, calling it creates an instance that rule
+// implementations return to pass information to the rules that depend on
+// them.
+// https://docs.bazel.build/versions/master/skylark/rules.html#providers
+type Provider struct {
+	name string
+	doc  string
+	// fields is nil if the provider accepts any field
+	fields []string
+}
+
+// DefaultInfo is returned by every rule, if a rule implementation doesn't
+// return one it gets one with the rule's outputs as its files.
+// https://docs.bazel.build/versions/master/skylark/lib/DefaultInfo.html
+var DefaultInfo = &Provider{
+	name:   "DefaultInfo",
+	fields: []string{"files", "runfiles", "executable"},
+}
+
+func (p *Provider) Name() string          { return p.name }
+func (p *Provider) Freeze()               {}
+func (p *Provider) Truth() skylark.Bool   { return true }
+func (p *Provider) String() string        { return fmt.Sprintf("<provider %s>", p.name) }
+func (p *Provider) Type() string          { return "provider" }
+func (p *Provider) Hash() (uint32, error) { return hashString(p.name), nil }
+
+func (p *Provider) Call(thread *skylark.Thread, args skylark.Tuple, kwargs []skylark.Tuple) (skylark.Value, error) {
+	if len(args) > 0 {
+		return nil, fmt.Errorf("%s: providers only take keyword arguments", p.name)
+	}
+	info := &Info{provider: p, fields: make(skylark.StringDict)}
+	for _, kwarg := range kwargs {
+		name, _ := skylark.AsString(kwarg[0])
+		if !p.hasField(name) {
+			return nil, fmt.Errorf("%s: unexpected field %q", p.name, name)
+		}
+		info.fields[name] = kwarg[1]
+	}
+	return info, nil
+}
+
+func (p *Provider) hasField(name string) bool {
+	if p.fields == nil {
+		return true
+	}
+	for _, f := range p.fields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// provider declares a new provider.
+// https://docs.bazel.build/versions/master/skylark/lib/globals.html#provider
+func provider(thread *skylark.Thread, fn *skylark.Builtin, args skylark.Tuple, kwargs []skylark.Tuple) (skylark.Value, error) {
+	var doc string
+	var fields skylark.Value = skylark.None
+	if err := skylark.UnpackArgs(fn.Name(), args, kwargs, "doc?", &doc, "fields?", &fields); err != nil {
+		return nil, err
+	}
+	// providers are named after the global they are exported as
+	p := &Provider{doc: doc}
+	switch fields := fields.(type) {
+	case skylark.NoneType:
+	case *skylark.List:
+		p.fields = []string{}
+		for i := 0; i < fields.Len(); i++ {
+			name, ok := skylark.AsString(fields.Index(i))
+			if !ok {
+				return nil, fmt.Errorf("provider: field names have to be strings, not %s", fields.Index(i).Type())
+			}
+			p.fields = append(p.fields, name)
+		}
+	case *skylark.Dict:
+		// the values of the dict are documentation
+		p.fields = []string{}
+		for _, k := range fields.Keys() {
+			name, ok := skylark.AsString(k)
+			if !ok {
+				return nil, fmt.Errorf("provider: field names have to be strings, not %s", k.Type())
+			}
+			p.fields = append(p.fields, name)
+		}
+	default:
+		return nil, fmt.Errorf("provider: fields has to be a list or a dict, not %s", fields.Type())
+	}
+	return p, nil
+}
+
+// Info is an instance of a provider.
+type Info struct {
+	provider *Provider
+	fields   skylark.StringDict
+}
+
+func (i *Info) Freeze()               { i.fields.Freeze() }
+func (i *Info) Truth() skylark.Bool   { return true }
+func (i *Info) Type() string          { return i.provider.name }
+func (i *Info) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: %s", i.Type()) }
+func (i *Info) String() string {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString(i.provider.name)
+	buf.WriteByte('(')
+	for j, name := range i.AttrNames() {
+		if j > 0 {
+			buf.WriteString(", ")
+		}
+		fmt.Fprintf(buf, "%s = %s", name, i.fields[name])
+	}
+	buf.WriteByte(')')
+	return buf.String()
+}
+
+// Provider returns the provider i is an instance of.
+func (i *Info) Provider() *Provider { return i.provider }
+
+func (i *Info) Attr(name string) (skylark.Value, error) {
+	if v, ok := i.fields[name]; ok {
+		return v, nil
+	}
+	if i.provider.hasField(name) {
+		return skylark.None, nil
+	}
+	return nil, fmt.Errorf("%s has no field %q", i.provider.name, name)
+}
+
+func (i *Info) AttrNames() []string {
+	names := []string{}
+	for name := range i.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// providers returns the providers a rule implementation returned, ret can
+// be None or a list of provider instances.
+func providers(ret skylark.Value) (map[*Provider]*Info, error) {
+	infos := make(map[*Provider]*Info)
+	if ret == skylark.None {
+		return infos, nil
+	}
+	seq, ok := ret.(skylark.Sequence)
+	if !ok {
+		return nil, fmt.Errorf("rule implementations have to return a list of providers, not %s", ret.Type())
+	}
+	iter := seq.Iterate()
+	defer iter.Done()
+	var v skylark.Value
+	for iter.Next(&v) {
+		info, ok := v.(*Info)
+		if !ok {
+			return nil, fmt.Errorf("rule implementations have to return a list of providers, got a %s in it", v.Type())
+		}
+		if _, ok := infos[info.provider]; ok {
+			return nil, fmt.Errorf("%s was returned more than once", info.provider.name)
+		}
+		infos[info.provider] = info
+	}
+	return infos, nil
+}
+
+// target is how rule implementations see the targets they depend on, the
+// providers of a target are accessed by indexing it with the provider.
+// https://docs.bazel.build/versions/master/skylark/lib/Target.html
+type target struct {
+	label     label.Label
+	providers map[*Provider]*Info
+}
+
+func (t *target) Freeze()               {}
+func (t *target) Truth() skylark.Bool   { return true }
+func (t *target) Type() string          { return "Target" }
+func (t *target) String() string        { return fmt.Sprintf("<target %s>", t.label) }
+func (t *target) Hash() (uint32, error) { return t.label.Hash() }
+
+func (t *target) Get(k skylark.Value) (skylark.Value, bool, error) {
+	p, ok := k.(*Provider)
+	if !ok {
+		return nil, false, fmt.Errorf("targets can only be indexed by providers, not %s", k.Type())
+	}
+	info, ok := t.providers[p]
+	if !ok {
+		return nil, false, fmt.Errorf("%s doesn't provide %s", t.label, p.name)
+	}
+	return info, true, nil
+}
+
+func (t *target) Attr(name string) (skylark.Value, error) {
+	switch name {
+	case "label":
+		return t.label, nil
+	case "files":
+		return t.providers[DefaultInfo].Attr("files")
+	}
+	v, err := t.label.Attr(name)
+	return v, errors.Wrap(err, "target")
+}
+
+func (t *target) AttrNames() []string { return []string{"files", "label"} }
+
+// satisfies checks t has every provider in one of the groups.
+func (t *target) satisfies(groups [][]*Provider) error {
+	var missing *Provider
+	for _, group := range groups {
+		missing = nil
+		for _, p := range group {
+			if _, ok := t.providers[p]; !ok {
+				missing = p
+				break
+			}
+		}
+		if missing == nil {
+			return nil
+		}
+	}
+	if missing != nil {
+		return fmt.Errorf("%s doesn't provide %s", t.label, missing.name)
+	}
+	return nil
+}
+
+// files returns the files in the DefaultInfo of t.
+func (t *target) files() []skylark.Value {
+	info, ok := t.providers[DefaultInfo]
+	if !ok {
+		return nil
+	}
+	switch files := info.fields["files"].(type) {
+	case *Depset:
+		return files.ToList()
+	case *skylark.List:
+		vals := make([]skylark.Value, files.Len())
+		for i := range vals {
+			vals[i] = files.Index(i)
+		}
+		return vals
+	}
+	return nil
+}
+
+// executable reports whether t can be run, targets are executable if their
+// DefaultInfo says which file to run or if they only have one file.
+func (t *target) executable() bool {
+	if info, ok := t.providers[DefaultInfo]; ok {
+		if x, ok := info.fields["executable"]; ok && x != skylark.None {
+			return true
+		}
+	}
+	return len(t.files()) == 1
+}
@@ -0,0 +1,142 @@
+package skylark
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"bldy.build/build"
+	"bldy.build/build/label"
+)
+
+const testProviders = `
+MyInfo = provider(fields = ["value"])
+
+def _lib(ctx):
+    return [MyInfo(value = ctx.attrs.name)]
+
+lib = rule(
+    attrs = {},
+    implementation = _lib,
+)
+
+def _bin(ctx):
+    return [MyInfo(value = [d[MyInfo].value for d in ctx.attrs.deps])]
+
+bin = rule(
+    attrs = {"deps": attr.label_list(allow_empty = True)},
+    implementation = _bin,
+)
+
+def _bad(ctx):
+    return [MyInfo(nope = 1)]
+
+bad = rule(
+    attrs = {},
+    implementation = _bad,
+)
+
+def _none(ctx):
+    return "none"
+
+none = rule(
+    attrs = {},
+    implementation = _none,
+)
+`
+
+// analyze analyzes the rule lbl after analyzing its dependencies.
+func analyze(vm *skylarkVM, lbl label.Label) (*Rule, error) {
+	r, err := analyzeTarget(vm, lbl)
+	if err != nil {
+		return nil, err
+	}
+	return r.(*Rule), nil
+}
+
+// analyzeTarget analyzes the target lbl, if it can be analyzed, after
+// analyzing its dependencies.
+func analyzeTarget(vm *skylarkVM, lbl label.Label) (build.Rule, error) {
+	r, err := vm.GetTarget(lbl)
+	if err != nil {
+		return nil, err
+	}
+	deps := make(map[label.Label]build.Rule)
+	for _, d := range r.Dependencies() {
+		if deps[d], err = analyzeTarget(vm, d); err != nil {
+			return nil, err
+		}
+	}
+	if a, ok := r.(build.Analyzer); ok {
+		return r, a.Analyze(deps)
+	}
+	return r, nil
+}
+
+func TestProviders(t *testing.T) {
+	vm := testWorkspace(t, map[string]string{
+		"defs.sky": testProviders,
+		"BUILD": `load("defs.sky", "lib", "bin", "bad", "none")
+lib(name = "a")
+lib(name = "b")
+bin(name = "c", deps = [":a", "//.:b"])
+bad(name = "d")
+none(name = "e")
+`,
+	})
+	defer os.RemoveAll(vm.ws.AbsPath())
+
+	r, err := analyze(vm, "//.:c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info *Info
+	for p, i := range r.Providers() {
+		if p.Name() == "MyInfo" {
+			info = i
+		}
+	}
+	if info == nil {
+		t.Fatalf("was expecting //.:c to provide MyInfo got %v instead", r.Providers())
+	}
+	v, _ := info.Attr("value")
+	if v.String() != `["a", "b"]` {
+		t.Logf("was expecting the values of the deps got %s instead", v)
+		t.Fail()
+	}
+	if _, ok := r.Providers()[DefaultInfo]; !ok {
+		t.Log("was expecting a DefaultInfo for a rule that didn't return one")
+		t.Fail()
+	}
+
+	tests := []struct {
+		label label.Label
+		err   string
+	}{
+		{"//.:d", `MyInfo: unexpected field "nope"`},
+		{"//.:e", "have to return a list of providers, not string"},
+	}
+	for _, test := range tests {
+		if _, err := analyze(vm, test.label); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Logf("was expecting %q got %v instead", test.err, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestTargetIndex(t *testing.T) {
+	other := &Provider{name: "OtherInfo"}
+	tgt := &target{label: "//a:b", providers: map[*Provider]*Info{DefaultInfo: defaultInfo([]string{"b.out"})}}
+	if _, _, err := tgt.Get(other); err == nil || !strings.Contains(err.Error(), "//a:b doesn't provide OtherInfo") {
+		t.Logf("was expecting an error for a missing provider got %v instead", err)
+		t.Fail()
+	}
+	files, err := tgt.Attr("files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := files.(*Depset); !ok || len(d.ToList()) != 1 || d.ToList()[0].String() != "b.out" {
+		t.Logf("was expecting [b.out] got %v instead", files)
+		t.Fail()
+	}
+}
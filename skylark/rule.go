@@ -1,16 +1,14 @@
 package skylark
 
 import (
-	"bytes"
-	"encoding/binary"
 	"fmt"
-	"io"
 	"path/filepath"
 	"sort"
+	"sync"
 
 	"github.com/pkg/errors"
-	"sevki.org/x/debug"
 
+	"bldy.build/build"
 	"bldy.build/build/executor"
 	"bldy.build/build/label"
 	"bldy.build/build/racy"
@@ -20,9 +18,12 @@ import (
 
 // Rule is a bazel rule that is implemented in skylark
 type Rule struct {
-	name string
-	deps []label.Label
-	ws   workspace.Workspace
+	name   string
+	kind   string
+	module string
+	deps   []label.Label
+	ws     workspace.Workspace
+	vm     *skylarkVM
 
 	SkyFuncLabel string
 	skyThread    *skylark.Thread
@@ -30,18 +31,34 @@ type Rule struct {
 	KWArgs       []skylark.Tuple
 	SkyFunc      *skylark.Function
 	FuncAttrs    *skylark.Dict
+	FuncOutputs  *skylark.Dict
 	Attrs        *skylark.Dict
 
 	host           label.Label
 	compatibleWith []label.Label
 	toolchains     []label.Label
 	restrictedTo   []label.Label
+	visibility     []label.Label
 	tags           []string
+	conditions     []label.Label
 	outputs        []string
+	declared       []skylark.Value
 	files          []string
 	Actions        []executor.Action
 
+	// resolved are the targets that implement the toolchains.
+	resolved map[label.Label]label.Label
+	// defaults are the defaults of the package the rule is declared in.
+	defaults pkgDefaults
+
 	ctx *context
+
+	// label is set when the rule is declared, providers once it's been
+	// analyzed.
+	label      label.Label
+	analysis   sync.Once
+	analyzeErr error
+	providers  map[*Provider]*Info
 }
 
 func labelListToArray(labelList *skylark.List) ([]label.Label, error) {
@@ -62,31 +79,59 @@ func labelListToArray(labelList *skylark.List) ([]label.Label, error) {
 	panic("list can't be nil")
 }
 
-func normalDeps(deps *skylark.List, rulepkg string) ([]label.Label, error) {
+// labelDeps returns the labels the label attributes of a rule in rulepkg
+// were set to, every one of them is a dependency of the rule whether it's
+// a rule or a source file. The host the rule runs on and targets in other
+// repositories aren't.
+func labelDeps(ruleAttrs *skylark.Dict, attrs skylark.StringDict, rulepkg string) ([]label.Label, error) {
 	deplbls := []label.Label{}
-	if deps != nil {
-		i := deps.Iterate()
-		var p skylark.Value
-		for i.Next(&p) {
-			if dep, ok := p.(label.Label); ok {
-				if err := dep.Valid(); err != nil {
-					return nil, err
-				}
-				if !dep.IsAbs() {
-					dep = label.New(rulepkg, dep.Name())
-				}
-
-				deplbls = append(deplbls, dep)
-			}
+	seen := make(map[label.Label]bool)
+	add := func(v skylark.Value) error {
+		dep, ok := v.(label.Label)
+		if !ok || dep.Repo() != "" {
+			return nil
 		}
+		if err := dep.Valid(); err != nil {
+			return err
+		}
+		if !dep.IsAbs() {
+			dep = label.New(rulepkg, dep.Name())
+		}
+		if !seen[dep] {
+			seen[dep] = true
+			deplbls = append(deplbls, dep)
+		}
+		return nil
 	}
-	return deplbls, nil
+	err := WalkDict(ruleAttrs, func(kw skylark.Value, attr Attribute) error {
+		name := string(kw.(skylark.String))
+		if name == skylarkKeyHost {
+			return nil
+		}
+		var vals []skylark.Value
+		switch v := attrs[name].(type) {
+		case label.Label:
+			vals = []skylark.Value{v}
+		case *skylark.List:
+			for i := 0; i < v.Len(); i++ {
+				vals = append(vals, v.Index(i))
+			}
+		case *skylark.Dict:
+			vals = v.Keys()
+		}
+		for _, v := range vals {
+			if err := add(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return deplbls, err
 }
 
 func (f *lambdaFunc) makeSkylarkRule(thread *skylark.Thread, args skylark.Tuple, kwargs []skylark.Tuple) (skylark.Value, error) {
 	pkg := getPkg(thread)
 	var name string
-	var deps *skylark.List
 	var outputs *skylark.List
 	_ = outputs
 	if n, ok := findArg(skylark.String(skylarkKeyName), kwargs); ok {
@@ -104,65 +149,329 @@ func (f *lambdaFunc) makeSkylarkRule(thread *skylark.Thread, args skylark.Tuple,
 	}
 	lbl := label.New(pkg, name)
 
-	ctx, skyio, err := newContext(name, f.attrs, f.outputs, kwargs, lbl, f.vm.ws)
-	if err != nil {
-		return nil, errors.Wrap(err, "makeskylarkrule")
-	}
-
-	t := &skylark.Thread{
-		Print: ctx.Print,
-	}
-
-	if _, err := f.skyFunc.Call(t, []skylark.Value{ctx}, nil); err != nil {
-		buf := bytes.NewBuffer(nil)
-		thread.Caller().WriteBacktrace(buf)
-		debug.Indent(buf, 1)
-		return nil, fmt.Errorf("skylark: makeskylarkrule: call: %s\n%s", err.Error(), buf.String())
-	}
-
 	newRule := Rule{
 		name:         name,
+		kind:         f.name,
+		module:       f.module,
+		ws:           f.vm.ws,
+		vm:           f.vm,
 		Args:         args,
 		KWArgs:       kwargs,
 		SkyFunc:      f.skyFunc,
 		skyThread:    thread,
 		SkyFuncLabel: f.skyFunc.Name(),
 		FuncAttrs:    f.attrs,
-		Actions:      ctx.actionRecorder.calls,
-		outputs:      skyio.outputs,
-		files:        skyio.files,
-		ctx:          ctx,
+		FuncOutputs:  f.outputs,
+		toolchains:   f.toolchains,
+		label:        lbl,
 	}
-
-	if dps, ok := ctx.attrs[skylarkKeyDeps]; ok {
-		if d, ok := dps.(*skylark.List); ok {
-			deps = d
+	newRule.defaults = packageDefaults(thread)
+	// rules that select their attributes are processed once they are
+	// configured.
+	if newRule.conditions = conditions(kwargs); len(newRule.conditions) == 0 {
+		if err := newRule.processArgs(kwargs); err != nil {
+			return nil, errors.Wrap(err, "makeskylarkrule")
 		}
 	}
-
-	if newRule.compatibleWith, err = labelListToArray(ctx.attrs[skylarkKeyCompatibleWith].(*skylark.List)); err != nil {
+	if err := f.vm.declare(thread, lbl, &newRule); err != nil {
 		return nil, err
 	}
+	return skylark.None, nil
+}
+
+// processArgs processes the arguments the rule was called with in to its
+// attributes, files, outputs and dependencies.
+func (r *Rule) processArgs(kwargs []skylark.Tuple) error {
+	ctx, skyio, err := newContext(r.name, r.FuncAttrs, r.FuncOutputs, kwargs, r.label, r.ws)
+	if err != nil {
+		return err
+	}
+	r.ctx = ctx
+	r.outputs = skyio.outputs
+	r.files = skyio.files
+
+	if r.compatibleWith, err = labelListToArray(ctx.attrs[skylarkKeyCompatibleWith].(*skylark.List)); err != nil {
+		return err
+	}
+	if r.restrictedTo, err = labelListToArray(ctx.attrs[skylarkKeyRestrictedTo].(*skylark.List)); err != nil {
+		return err
+	}
+	if r.visibility, err = labelListToArray(ctx.attrs[skylarkKeyVisibility].(*skylark.List)); err != nil {
+		return err
+	}
+	// attributes that aren't set get the defaults of the package
+	for _, d := range []struct {
+		name     string
+		lbls     *[]label.Label
+		defaults []label.Label
+	}{
+		{skylarkKeyCompatibleWith, &r.compatibleWith, r.defaults.compatibleWith},
+		{skylarkKeyRestrictedTo, &r.restrictedTo, r.defaults.restrictedTo},
+		{skylarkKeyVisibility, &r.visibility, r.defaults.visibility},
+	} {
+		if _, ok := findArg(skylark.String(d.name), kwargs); !ok {
+			*d.lbls = d.defaults
+		}
+	}
 	ok := false
-	if newRule.host, ok = ctx.attrs[skylarkKeyHost].(label.Label); !ok {
-		return nil, fmt.Errorf("host cannot be null, as it has a default value for all skylark rules")
+	if r.host, ok = ctx.attrs[skylarkKeyHost].(label.Label); !ok {
+		return fmt.Errorf("host cannot be null, as it has a default value for all skylark rules")
 	}
-	if newRule.deps, err = normalDeps(deps, pkg); err != nil {
-		return nil, errors.Wrap(err, "makeSkylarkRule.normalDeps")
+	if r.deps, err = labelDeps(r.FuncAttrs, ctx.attrs, r.label.Package()); err != nil {
+		return errors.Wrap(err, "makeSkylarkRule.labelDeps")
 	}
-	f.vm.rules[lbl.String()] = &newRule
-	return skylark.None, nil
+	return nil
 }
 
-// Build builds the skylarkRule
-func (r *Rule) Build(e *executor.Executor) error {
-	for _, action := range r.Actions {
-		if err := action.Do(e); err != nil {
-			return err
+// Conditions returns the conditions of the select()s the rule was called
+// with.
+func (r *Rule) Conditions() []label.Label {
+	return r.conditions
+}
+
+// Configure resolves the select()s the rule was called with and processes
+// its attributes, rules that don't select any attributes are processed when
+// they are declared. The implementation sees config as ctx.var and
+// ctx.configuration.
+func (r *Rule) Configure(config *build.Config, matches map[label.Label]bool) error {
+	if len(r.conditions) == 0 {
+		r.ctx.config = config
+		return nil
+	}
+	kwargs := make([]skylark.Tuple, len(r.KWArgs))
+	for i, kwarg := range r.KWArgs {
+		kwargs[i] = kwarg
+		s, ok := kwarg.Index(1).(*selector)
+		if !ok {
+			continue
+		}
+		name := kwarg.Index(0)
+		v, err := s.resolve(matches)
+		if err != nil {
+			return fmt.Errorf("%s: attribute %s: %v", r.label, name, err)
+		}
+		kwargs[i] = skylark.Tuple{name, v}
+	}
+	if err := r.processArgs(kwargs); err != nil {
+		return err
+	}
+	r.ctx.config = config
+	// the resolved arguments are hashed instead of the selects
+	r.KWArgs = kwargs
+	return nil
+}
+
+// CompatibleWith checks the rule can be built in config. Every label in
+// compatible_with has to be the platform of the configuration or one of its
+// constraint values, and if there are any labels in restricted_to one of
+// them has to be.
+func (r *Rule) CompatibleWith(config *build.Config) error {
+	has := func(lbl label.Label) bool {
+		return lbl == config.Platform || r.vm.hasConstraints(config.Constraints, []label.Label{lbl})
+	}
+	for _, lbl := range r.compatibleWith {
+		if !has(lbl) {
+			return fmt.Errorf("it's only compatible with %s", lbl)
+		}
+	}
+	if len(r.restrictedTo) == 0 {
+		return nil
+	}
+	for _, lbl := range r.restrictedTo {
+		if has(lbl) {
+			return nil
+		}
+	}
+	return fmt.Errorf("it's restricted to %v", r.restrictedTo)
+}
+
+// Visibility returns the labels the rule is visible to, rules that don't set
+// their visibility get the default visibility of their package, and are
+// private if it doesn't have one.
+func (r *Rule) Visibility() []label.Label {
+	if len(r.visibility) == 0 {
+		return []label.Label{build.PrivateVisibility}
+	}
+	return r.visibility
+}
+
+// ToolchainTypes returns the types of the toolchains the rule was declared
+// with.
+func (r *Rule) ToolchainTypes() []label.Label {
+	return r.toolchains
+}
+
+// SetToolchains makes the rule depend on the targets that implement its
+// toolchains, the implementation sees their ToolchainInfo in ctx.toolchains.
+func (r *Rule) SetToolchains(toolchains map[label.Label]label.Label) error {
+	r.resolved = toolchains
+	for _, typ := range r.toolchains {
+		impl, ok := toolchains[typ]
+		if !ok {
+			return fmt.Errorf("%s: there is no toolchain of type %s", r.label, typ)
+		}
+		found := false
+		for _, d := range r.deps {
+			found = found || d == impl
+		}
+		if !found {
+			r.deps = append(r.deps, impl)
+		}
+	}
+	return nil
+}
+
+// Analyze runs the implementation of the rule, which records the actions
+// it takes to build it and returns its providers. deps are the rules it
+// depends on, the label attributes the implementation sees are replaced by
+// their targets so it can read their providers.
+func (r *Rule) Analyze(deps map[label.Label]build.Rule) error {
+	r.analysis.Do(func() { r.analyzeErr = r.analyze(deps) })
+	return r.analyzeErr
+}
+
+func (r *Rule) analyze(deps map[label.Label]build.Rule) error {
+	targets := make(map[label.Label]*target)
+	for lbl, dep := range deps {
+		targets[lbl] = &target{label: lbl, providers: depProviders(dep)}
+	}
+	attrs := skylark.StringDict{}
+	for k, v := range r.ctx.attrs {
+		attrs[k] = r.withTargets(v, targets)
+	}
+	if err := checkTargets(r.FuncAttrs, attrs); err != nil {
+		return fmt.Errorf("%s: %v", r.label, err)
+	}
+	r.ctx.analyzed = attrs
+	analyzeFiles(r.ctx, r.FuncAttrs)
+	r.ctx.toolchains = &toolchainContext{pkg: r.label.Package(), infos: make(map[label.Label]*Info)}
+	for typ, impl := range r.resolved {
+		t, ok := targets[impl]
+		if !ok {
+			return fmt.Errorf("%s: toolchain %s wasn't analyzed", r.label, impl)
+		}
+		info, ok := t.providers[ToolchainInfo]
+		if !ok {
+			return fmt.Errorf("%s: toolchain %s doesn't provide ToolchainInfo", r.label, impl)
+		}
+		r.ctx.toolchains.infos[typ] = info
+	}
+
+	t := &skylark.Thread{
+		Print: r.ctx.Print,
+	}
+	ret, err := r.SkyFunc.Call(t, []skylark.Value{r.ctx}, nil)
+	if err != nil {
+		if evalErr, ok := err.(*skylark.EvalError); ok {
+			return fmt.Errorf("skylark: %s: %s", evalErr.Frame.Position(), evalErr.Msg)
 		}
+		return errors.Wrap(err, "skylark")
 	}
+	if r.providers, err = providers(ret); err != nil {
+		return fmt.Errorf("skylark: %s: %v", r.SkyFunc.Position(), err)
+	}
+	r.declared = r.ctx.declarer.declared
+	for _, d := range r.declared {
+		r.outputs = append(r.outputs, d.(interface{ Path() string }).Path())
+	}
+	if _, ok := r.providers[DefaultInfo]; !ok {
+		r.providers[DefaultInfo] = defaultInfo(r.outputs)
+	}
+	r.Actions = r.ctx.actionRecorder.calls
 	return nil
+}
+
+// withTargets replaces the labels in v that are dependencies with their
+// targets.
+func (r *Rule) withTargets(v skylark.Value, targets map[label.Label]*target) skylark.Value {
+	switch v := v.(type) {
+	case label.Label:
+		lbl := v
+		if !lbl.IsAbs() {
+			lbl = label.New(r.label.Package(), lbl.Name())
+		}
+		if t, ok := targets[lbl]; ok {
+			return t
+		}
+	case *skylark.List:
+		vals := make([]skylark.Value, v.Len())
+		for i := range vals {
+			vals[i] = r.withTargets(v.Index(i), targets)
+		}
+		return skylark.NewList(vals)
+	case *skylark.Dict:
+		d := new(skylark.Dict)
+		for _, item := range v.Items() {
+			d.Set(r.withTargets(item[0], targets), item[1])
+		}
+		return d
+	}
+	return v
+}
+
+// Providers returns the providers the implementation of the rule returned,
+// it's nil until the rule has been analyzed.
+func (r *Rule) Providers() map[*Provider]*Info {
+	return r.providers
+}
 
+// depProviders returns the providers of dep, targets that don't return any,
+// like native rules, only provide DefaultInfo with their outputs.
+func depProviders(dep build.Rule) map[*Provider]*Info {
+	if p, ok := dep.(interface{ Providers() map[*Provider]*Info }); ok {
+		return p.Providers()
+	}
+	return map[*Provider]*Info{DefaultInfo: defaultInfo(dep.Outputs())}
+}
+
+func defaultInfo(outputs []string) *Info {
+	files := &Depset{order: orderDefault}
+	for _, o := range outputs {
+		files.direct = append(files.direct, output(o))
+		files.elemType = output(o).Type()
+	}
+	return &Info{
+		provider: DefaultInfo,
+		fields:   skylark.StringDict{"files": files},
+	}
+}
+
+// Build builds the skylarkRule, actions that don't depend on each other run
+// concurrently. After each action it checks the action created its
+// outputs, and once every action has run that every declared output was
+// created.
+func (r *Rule) Build(e *executor.Executor) error {
+	if err := prepareOutputs(e, r.declared); err != nil {
+		return err
+	}
+	dirs := make(map[string]bool)
+	for _, d := range r.declared {
+		if d, ok := d.(directory); ok {
+			dirs[string(d)] = true
+		}
+	}
+	err := e.RunActions(r.Actions, func(action executor.Action) error {
+		d, ok := action.(executor.Describer)
+		if !ok {
+			return nil
+		}
+		for _, o := range d.Describe().Outputs {
+			if err := checkOutput(e, o, dirs[o]); err != nil {
+				return fmt.Errorf("%s: action didn't create its outputs: %v", r.label, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, d := range r.declared {
+		o := d.(interface{ Path() string }).Path()
+		if err := checkOutput(e, o, dirs[o]); err != nil {
+			return fmt.Errorf("%s: declared output: %v", r.label, err)
+		}
+	}
+	return nil
 }
 
 func (r *Rule) Platform() label.Label          { return r.host }
@@ -170,7 +479,15 @@ func (r *Rule) Workspace() workspace.Workspace { return r.ws }
 
 // Hash returns the calculated hash of a target
 func (r *Rule) Hash() []byte {
+	return r.hasher().Sum(nil)
+}
+
+// HashInputs returns the inputs that went in to the hash of the target.
+func (r *Rule) HashInputs() []racy.Input {
+	return r.hasher().Inputs()
+}
 
+func (r *Rule) hasher() *racy.Racy {
 	opts := []racy.Option{}
 	for _, f := range r.files {
 		opts = append(opts, racy.AllowExtension(filepath.Ext(f)))
@@ -181,13 +498,18 @@ func (r *Rule) Hash() []byte {
 		panic(err)
 	}
 
-	io.WriteString(h, r.SkyFuncLabel)
+	h.HashNamed("function", r.SkyFuncLabel)
+	if r.module != "" {
+		h.HashNamed("module", r.module)
+	}
 	funcHash, err := r.SkyFunc.Hash()
 	if err != nil {
 		l.Fatal(err)
 	}
-	if err := binary.Write(h, binary.BigEndian, funcHash); err != nil {
-		l.Fatal(err)
+	h.HashNamed("function hash", fmt.Sprintf("%x", funcHash))
+	// actions are only recorded once the rule has been analyzed
+	for i, a := range r.Actions {
+		h.HashNamed(fmt.Sprintf("action %d", i), actionKey(a))
 	}
 	// sort Attributes
 	keys := []string{}
@@ -198,15 +520,17 @@ func (r *Rule) Hash() []byte {
 	for _, k := range keys {
 		v, ok := r.ctx.attrs[k].(skylark.Value)
 		if ok {
-			h.HashSkylarkValue(v)
+			h.HashNamed("attr "+k, v.String())
 		}
 	}
-	x := h.Sum(nil)
 	WalkDict(r.FuncAttrs, func(kw skylark.Value, attr Attribute) error {
-		x = racy.XOR(x, r.hashArg(kw, attr))
+		if v, ok := findArg(kw, r.KWArgs); ok {
+			name, _ := skylark.AsString(kw)
+			h.HashNamed("arg "+name, v.String())
+		}
 		return nil
 	})
-	return x
+	return h
 }
 
 func findArg(kw skylark.Value, kwargs []skylark.Tuple) (skylark.Value, bool) {
@@ -220,14 +544,28 @@ func findArg(kw skylark.Value, kwargs []skylark.Tuple) (skylark.Value, bool) {
 	return nil, false
 }
 
-func (r *Rule) hashArg(kw skylark.Value, a Attribute) []byte {
-	h := racy.New()
-	v, ok := findArg(kw, r.KWArgs)
-	if !ok {
-		return nil
+// Kind returns the name the rule was exported as, or the name of its
+// implementation function if it wasn't loaded from another module.
+func (r *Rule) Kind() string {
+	if r.kind != "" {
+		return r.kind
+	}
+	return r.SkyFuncLabel
+}
+
+// Attr returns the value of the attribute name as a string.
+func (r *Rule) Attr(name string) (string, bool) {
+	if r.ctx == nil { // it hasn't been configured yet
+		return "", false
+	}
+	v, ok := r.ctx.attrs[name]
+	if !ok || v == nil {
+		return "", false
+	}
+	if s, ok := skylark.AsString(v); ok {
+		return s, true
 	}
-	io.WriteString(h, v.String())
-	return h.Sum(nil)
+	return v.String(), true
 }
 
 // GetName returns the name of the SkylarkRule
@@ -0,0 +1,169 @@
+package skylark
+
+import (
+	"fmt"
+	"strings"
+
+	"bldy.build/build"
+	"bldy.build/build/label"
+	"github.com/google/skylark"
+	"github.com/google/skylark/syntax"
+)
+
+// selector is the value select() returns. Which branch of it an attribute
+// gets is decided once the configuration is known, selects can be added to
+// each other and to plain values, parts are the values in the order they
+// were added.
+type selector struct {
+	parts []selectPart
+}
+
+// selectPart is either a plain value or the branches of a select.
+type selectPart struct {
+	value skylark.Value
+
+	conds        []label.Label
+	values       []skylark.Value
+	noMatchError string
+}
+
+func (s *selector) Freeze()               {}
+func (s *selector) Truth() skylark.Bool   { return true }
+func (s *selector) Type() string          { return "select" }
+func (s *selector) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: select") }
+func (s *selector) String() string {
+	strs := []string{}
+	for _, p := range s.parts {
+		if p.conds == nil {
+			strs = append(strs, p.value.String())
+			continue
+		}
+		branches := []string{}
+		for i, c := range p.conds {
+			branches = append(branches, fmt.Sprintf("%q: %s", c, p.values[i]))
+		}
+		strs = append(strs, fmt.Sprintf("select({%s})", strings.Join(branches, ", ")))
+	}
+	return strings.Join(strs, " + ")
+}
+
+// Binary implements skylark.HasBinary so selects can be added to lists and
+// other selects.
+func (s *selector) Binary(op syntax.Token, y skylark.Value, side skylark.Side) (skylark.Value, error) {
+	if op != syntax.PLUS {
+		return nil, nil
+	}
+	other := []selectPart{{value: y}}
+	if y, ok := y.(*selector); ok {
+		other = y.parts
+	}
+	parts := []selectPart{}
+	if side == skylark.Left {
+		parts = append(append(parts, s.parts...), other...)
+	} else {
+		parts = append(append(parts, other...), s.parts...)
+	}
+	return &selector{parts: parts}, nil
+}
+
+// newSelect implements select(), conditions are labels of config_settings
+// and relative to the package select was called in.
+func newSelect(thread *skylark.Thread, fn *skylark.Builtin, args skylark.Tuple, kwargs []skylark.Tuple) (skylark.Value, error) {
+	var branches *skylark.Dict
+	var noMatchError string
+	if err := skylark.UnpackArgs(fn.Name(), args, kwargs, "x", &branches, "no_match_error?", &noMatchError); err != nil {
+		return nil, err
+	}
+	if branches.Len() == 0 {
+		return nil, fmt.Errorf("select: there has to be at least one condition")
+	}
+	part := selectPart{noMatchError: noMatchError}
+	pkg := getPkg(thread)
+	for _, item := range branches.Items() {
+		s, ok := skylark.AsString(item[0])
+		if !ok {
+			return nil, fmt.Errorf("select: conditions have to be labels, not %s", item[0].Type())
+		}
+		cond, err := label.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("select: %q isn't a valid label: %v", s, err)
+		}
+		if !cond.IsAbs() && cond.Repo() == "" {
+			cond = label.New(pkg, cond.Name())
+		}
+		part.conds = append(part.conds, cond)
+		part.values = append(part.values, item[1])
+	}
+	return &selector{parts: []selectPart{part}}, nil
+}
+
+// conditions returns the conditions of the selects in kwargs, except the
+// default condition.
+func conditions(kwargs []skylark.Tuple) []label.Label {
+	var conds []label.Label
+	seen := make(map[label.Label]bool)
+	for _, kwarg := range kwargs {
+		s, ok := kwarg.Index(1).(*selector)
+		if !ok {
+			continue
+		}
+		for _, p := range s.parts {
+			for _, c := range p.conds {
+				if c != build.DefaultCondition && !seen[c] {
+					seen[c] = true
+					conds = append(conds, c)
+				}
+			}
+		}
+	}
+	return conds
+}
+
+// resolve returns the value of the selector with the branches the matching
+// conditions pick.
+func (s *selector) resolve(matches map[label.Label]bool) (skylark.Value, error) {
+	var resolved skylark.Value
+	for _, p := range s.parts {
+		v := p.value
+		if p.conds != nil {
+			var err error
+			if v, err = p.pick(matches); err != nil {
+				return nil, err
+			}
+		}
+		if resolved == nil {
+			resolved = v
+			continue
+		}
+		var err error
+		if resolved, err = skylark.Binary(syntax.PLUS, resolved, v); err != nil {
+			return nil, err
+		}
+	}
+	return resolved, nil
+}
+
+func (p *selectPart) pick(matches map[label.Label]bool) (skylark.Value, error) {
+	picked := -1
+	for i, c := range p.conds {
+		if c == build.DefaultCondition || !matches[c] {
+			continue
+		}
+		if picked >= 0 {
+			return nil, fmt.Errorf("select: both %s and %s match, conditions can't overlap", p.conds[picked], c)
+		}
+		picked = i
+	}
+	if picked >= 0 {
+		return p.values[picked], nil
+	}
+	for i, c := range p.conds {
+		if c == build.DefaultCondition {
+			return p.values[i], nil
+		}
+	}
+	if p.noMatchError != "" {
+		return nil, fmt.Errorf("select: %s", p.noMatchError)
+	}
+	return nil, fmt.Errorf("select: none of %v match and there is no %s", p.conds, build.DefaultCondition)
+}
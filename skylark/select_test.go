@@ -0,0 +1,215 @@
+package skylark
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"bldy.build/build"
+	"bldy.build/build/label"
+)
+
+const testSelect = `
+def _impl(ctx):
+    pass
+
+pick = rule(
+    attrs = {
+        "opts": attr.string_list(),
+        "deps": attr.label_list(allow_empty = True),
+    },
+    implementation = _impl,
+)
+`
+
+const testSelectBuild = `load("defs.sky", "pick")
+
+config_setting(
+    name = "debug",
+    values = {"define": "mode=debug"},
+)
+
+config_setting(
+    name = "linux",
+    constraint_values = ["@bldy//platforms/os:linux"],
+)
+
+pick(
+    name = "x",
+    opts = ["-Wall"] + select({
+        ":debug": ["-g"],
+        "//conditions:default": ["-O2"],
+    }),
+    deps = select({
+        ":debug": [":d"],
+        "//conditions:default": [],
+    }),
+)
+
+pick(name = "d")
+
+pick(
+    name = "strict",
+    opts = select({":debug": []}, no_match_error = "strict only builds in debug mode"),
+)
+
+pick(
+    name = "both",
+    opts = select({":debug": [], ":linux": []}),
+)
+`
+
+// configure does what the graph does for rules that select their
+// attributes.
+func configure(vm *skylarkVM, lbl label.Label, config *build.Config) (*Rule, error) {
+	t, err := vm.GetTarget(lbl)
+	if err != nil {
+		return nil, err
+	}
+	r := t.(*Rule)
+	matches := make(map[label.Label]bool)
+	for _, cond := range r.Conditions() {
+		c, err := vm.GetTarget(cond)
+		if err != nil {
+			return nil, err
+		}
+		matches[cond] = c.(build.Condition).Matches(config)
+	}
+	return r, r.Configure(config, matches)
+}
+
+func TestSelect(t *testing.T) {
+	debug := &build.Config{Defines: map[string]string{"mode": "debug"}}
+	linuxDebug := &build.Config{
+		Defines:     map[string]string{"mode": "debug"},
+		Constraints: []label.Label{"@bldy//platforms/os:linux"},
+	}
+	tests := []struct {
+		name   string
+		label  label.Label
+		config *build.Config
+		opts   string
+		deps   []label.Label
+		err    string
+	}{
+		{name: "default", label: "//.:x", config: build.DefaultConfig(), opts: `["-Wall", "-O2"]`},
+		{name: "debug", label: "//.:x", config: debug, opts: `["-Wall", "-g"]`, deps: []label.Label{"//.:d"}},
+		{name: "no match", label: "//.:strict", config: build.DefaultConfig(), err: "//.:strict: attribute \"opts\": select: strict only builds in debug mode"},
+		{name: "overlap", label: "//.:both", config: linuxDebug, err: "select: both //.:debug and //.:linux match"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vm := testWorkspace(t, map[string]string{
+				"defs.sky": testSelect,
+				"BUILD":    testSelectBuild,
+			})
+			defer os.RemoveAll(vm.ws.AbsPath())
+			r, err := configure(vm, test.label, test.config)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Logf("was expecting %q got %v instead", test.err, err)
+					t.Fail()
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := r.ctx.attrs["opts"].String(); got != test.opts {
+				t.Logf("was expecting %s got %s instead", test.opts, got)
+				t.Fail()
+			}
+			if got := r.Dependencies(); len(got) != len(test.deps) || (len(got) > 0 && got[0] != test.deps[0]) {
+				t.Logf("was expecting %v got %v instead", test.deps, got)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestSelectHash(t *testing.T) {
+	hash := func(config *build.Config) []byte {
+		vm := testWorkspace(t, map[string]string{
+			"defs.sky": testSelect,
+			"BUILD":    testSelectBuild,
+		})
+		defer os.RemoveAll(vm.ws.AbsPath())
+		r, err := configure(vm, "//.:x", config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.Hash()
+	}
+	debug := &build.Config{Defines: map[string]string{"mode": "debug"}}
+	if bytes.Equal(hash(build.DefaultConfig()), hash(debug)) {
+		t.Log("was expecting the hash to change with the branch the select picks")
+		t.Fail()
+	}
+	if !bytes.Equal(hash(debug), hash(debug)) {
+		t.Log("was expecting the same branch to hash the same")
+		t.Fail()
+	}
+}
+
+const testConfiguration = `
+def _impl(ctx):
+    print(ctx.var["COMPILATION_MODE"], ctx.var.get("level"), ctx.configuration.copts, ctx.configuration.default_shell_env, ctx.attrs.opts)
+
+show = rule(
+    attrs = {"opts": attr.string_list()},
+    implementation = _impl,
+)
+`
+
+const testConfigurationBuild = `load("defs.sky", "show")
+
+config_setting(
+    name = "opt",
+    values = {"compilation_mode": "opt"},
+)
+
+show(
+    name = "x",
+    opts = select({
+        ":opt": ["-O2"],
+        "//conditions:default": [],
+    }),
+)
+`
+
+func TestConfiguration(t *testing.T) {
+	opt := build.DefaultConfig()
+	opt.CompilationMode = build.Opt
+	opt.Defines["level"] = "3"
+	opt.Copts = []string{"-Wall"}
+	opt.ActionEnv["PATH"] = "/bin"
+	tests := []struct {
+		name   string
+		config *build.Config
+		out    string
+	}{
+		{"default", build.DefaultConfig(), `fastbuild None [] {} []`},
+		{"opt", opt, `opt 3 ["-Wall"] {"PATH": "/bin"} ["-O2"]`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vm := testWorkspace(t, map[string]string{
+				"defs.sky": testConfiguration,
+				"BUILD":    testConfigurationBuild,
+			})
+			defer os.RemoveAll(vm.ws.AbsPath())
+			r, err := configure(vm, "//.:x", test.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Analyze(nil); err != nil {
+				t.Fatal(err)
+			}
+			if got := r.ctx.buf.String(); got != test.out {
+				t.Logf("was expecting %s got %s instead", test.out, got)
+				t.Fail()
+			}
+		})
+	}
+}
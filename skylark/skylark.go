@@ -1,17 +1,15 @@
 package skylark
 
 import (
-	"bytes"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
-	"path"
+	"sort"
+	"sync"
 
 	"bldy.build/build/internal"
 	"bldy.build/build/label"
 	"bldy.build/build/workspace"
-	"sevki.org/x/debug"
 
 	"bldy.build/build"
 	"github.com/google/skylark"
@@ -30,11 +28,13 @@ const (
 	skylarkKeyHost           = "host"
 	skylarkKeyRestrictedTo   = "restricted_to"
 	skylarkKeyTags           = "tags"
+	skylarkKeyVisibility     = "visibility"
 
 	threadKeyTargets = "__targets"
 	threadKeyWD      = "__wd"
 	threadKeyContext = "__ctx"
 	threadKeyPackage = "__package"
+	threadKeyLoading = "__loading"
 )
 
 var (
@@ -48,17 +48,29 @@ func init() {
 }
 
 type skylarkVM struct {
-	pkg     string
-	rules   map[string]build.Rule
 	ws      workspace.Workspace
 	globals skylark.StringDict
+
+	mu       sync.Mutex
+	packages map[string]*pkg
+	modules  map[string]*module
+	// waits is what the modules that are being loaded are waiting for.
+	waits map[string]label.Label
+
+	// registered is the toolchains the WORKSPACE file registers, it's
+	// executed the first time they are asked for.
+	workspaceOnce sync.Once
+	workspaceErr  error
+	registered    []label.Label
 }
 
-// New returns a new skylarkVM
+// New returns a new skylarkVM, it's safe to use from multiple goroutines.
 func New(ws workspace.Workspace) (build.VM, error) {
 	s := &skylarkVM{
-		ws:    ws,
-		rules: make(map[string]build.Rule),
+		ws:       ws,
+		packages: make(map[string]*pkg),
+		modules:  make(map[string]*module),
+		waits:    make(map[string]label.Label),
 	}
 
 	natives := skylark.StringDict{}
@@ -68,8 +80,35 @@ func New(ws workspace.Workspace) (build.VM, error) {
 	globals := skylark.StringDict{
 		"rule":   skylark.NewBuiltin("rule", s.makeRule),
 		"glob":   skylark.NewBuiltin("glob", s.glob),
+		"select": skylark.NewBuiltin("select", newSelect),
 		"native": skylarkstruct.FromStringDict(skylarkstruct.Default, natives),
 		"struct": skylark.NewBuiltin("struct", skylarkstruct.Make),
+
+		"provider":    skylark.NewBuiltin("provider", provider),
+		"depset":      skylark.NewBuiltin("depset", depset),
+		"DefaultInfo": DefaultInfo,
+
+		"config_setting": skylark.NewBuiltin("config_setting", s.configSetting),
+
+		"platform":           skylark.NewBuiltin("platform", s.platform),
+		"constraint_setting": skylark.NewBuiltin("constraint_setting", s.constraintSetting),
+		"constraint_value":   skylark.NewBuiltin("constraint_value", s.constraintValue),
+		"toolchain_type":     skylark.NewBuiltin("toolchain_type", s.toolchainType),
+		"toolchain":          skylark.NewBuiltin("toolchain", s.toolchain),
+
+		"package":       skylark.NewBuiltin("package", s.packageFunc),
+		"package_group": skylark.NewBuiltin("package_group", s.packageGroup),
+		"exports_files": skylark.NewBuiltin("exports_files", s.exportsFiles),
+		"filegroup":     skylark.NewBuiltin("filegroup", s.filegroup),
+
+		"platform_common": platformCommon,
+	}
+	// native rules can be called from BUILD files without native, unless
+	// there is a builtin with the same name.
+	for name, nativeRule := range natives {
+		if _, ok := globals[name]; !ok {
+			globals[name] = nativeRule
+		}
 	}
 	s.globals = globals
 	return s, nil
@@ -84,72 +123,48 @@ func (s *skylarkVM) GetPackageDir(l label.Label) string {
 
 }
 func (s *skylarkVM) GetTarget(l label.Label) (build.Rule, error) {
-
-	if r, ok := s.rules[l.String()]; ok {
-		return r, nil
+	if err := l.Valid(); err != nil {
+		return nil, errors.Wrap(err, "skylark.get_target")
 	}
-
-	bytz, err := s.ws.LoadBuildfile(l)
+	p, err := s.pkg(l.Package())
 	if err != nil {
-		return nil, errors.Wrap(err, "skylark.get_target:")
-	}
-	if err := l.Valid(); err != nil {
-		return nil, errors.Wrap(err, "skylark.get_target:")
+		return nil, errors.Wrap(err, "skylark.get_target")
 	}
-	if l.Package() == "" {
-		return nil, errors.New("skylark vm can't figure out labels without packages, for the root package please use '.'.")
+	if r, ok := p.rules[l.String()]; ok {
+		return r, nil
 	}
-
-	t := &skylark.Thread{}
-	t.Load = s.load
-	t.Print = print
-	initPkgStack(t)
-	pushPkg(t, l.Package())
-
-	if _, err = skylark.ExecFile(t, s.ws.Buildfile(l), bytz, s.globals); err != nil {
-		return nil, errors.Wrap(err, "skylark: gettarget: exec")
+	// labels that don't name a target name a source file in the package.
+	if isSourceFile(s.ws.File(l)) {
+		return newSourceFile(l, s.ws), nil
 	}
-	if r, ok := s.rules[l.String()]; ok {
-		r.(*Rule).ws = s.ws // TODO: fix this
-
-		return r, nil
+	if ref, ok := p.referrers[l.String()]; ok {
+		return nil, fmt.Errorf("skylark: %s depends on %q which is neither a target nor a file in %s", ref, l, s.ws.Buildfile(l))
 	}
-
 	return nil, fmt.Errorf("skylark: couldn't find the target %q in %s", l, s.ws.Buildfile(l))
 }
 
-func (s *skylarkVM) load(thread *skylark.Thread, module string) (skylark.StringDict, error) {
-	pkg := getPkg(thread)
-	l, err := label.Parse(module)
-
+// Targets returns the labels of every target declared in the package pkg
+func (s *skylarkVM) Targets(pkg string) ([]label.Label, error) {
+	p, err := s.pkg(pkg)
 	if err != nil {
-		return nil, errors.Wrap(err, "skylark.load")
+		return nil, errors.Wrap(err, "skylark.targets")
 	}
-	if !l.IsAbs() {
-		l = label.New(pkg, module)
-	}
-
-	file := ""
-	if path.Ext(l.Name()) != "" {
-		file = s.ws.File(l)
-	} else {
-		file = s.ws.Buildfile(l)
-	}
-	bytz, err := ioutil.ReadFile(file)
-	if err != nil {
-		buf := bytes.NewBuffer(nil)
-		thread.Caller().WriteBacktrace(buf)
-		return nil, fmt.Errorf("skylark.load: %s\n%s", err.Error(), buf.String())
+	lbls := []label.Label{}
+	for k := range p.rules {
+		lbls = append(lbls, label.Label(k))
 	}
+	sort.Slice(lbls, func(i, j int) bool { return lbls[i] < lbls[j] })
+	return lbls, nil
+}
 
-	pushPkg(thread, l.Package())
-	dict, err := skylark.ExecFile(thread, s.ws.Buildfile(l), bytz, s.globals)
-	if err != nil {
-		buf := bytes.NewBuffer(nil)
-		debug.Indent(buf, 1)
-		thread.Caller().WriteBacktrace(buf)
-		return nil, fmt.Errorf("skylark: load: exec: %s\n%s", err.Error(), buf.String())
+// Position returns where the target l was declared.
+func (s *skylarkVM) Position(l label.Label) string {
+	s.mu.Lock()
+	p, ok := s.packages[l.Package()]
+	s.mu.Unlock()
+	if !ok {
+		return ""
 	}
-	popPkg(thread)
-	return dict, err
+	<-p.done
+	return p.positions[l.String()]
 }
@@ -4,8 +4,11 @@ import (
 	"errors"
 	"os"
 	"path"
+	"strings"
+	"sync"
 	"testing"
 
+	"bldy.build/build"
 	"bldy.build/build/label"
 	"bldy.build/build/workspace"
 )
@@ -74,3 +77,58 @@ func TestEval(t *testing.T) {
 		})
 	}
 }
+
+func TestPackagesEvaluatedOnce(t *testing.T) {
+	wd, _ := os.Getwd()
+	ws, err := workspace.New(path.Join(wd, "testdata", "packages"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, _ := New(ws)
+
+	lbls := []label.Label{"//a:x", "//a:z", "//b:y"}
+	rules := make([][]build.Rule, 8)
+	var wg sync.WaitGroup
+	for i := range rules {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for _, l := range lbls {
+				r, err := vm.GetTarget(l)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				rules[i] = append(rules[i], r)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+	for i := range rules {
+		for j, r := range rules[i] {
+			if r != rules[0][j] {
+				t.Fatalf("%s was declared more than once", lbls[j])
+			}
+		}
+	}
+	x, y := rules[0][0].(*Rule), rules[0][2].(*Rule)
+	if x.SkyFunc != y.SkyFunc {
+		t.Log("//a:x and //b:y should share the rule loaded from defs.sky")
+		t.Fail()
+	}
+	if pos := vm.Position("//b:y"); !strings.Contains(pos, "b/BUILD:3") {
+		t.Logf("was expecting //b:y to be declared at b/BUILD:3 got %q instead", pos)
+		t.Fail()
+	}
+	targets, err := vm.Targets("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 2 || targets[0] != "//a:x" || targets[1] != "//a:z" {
+		t.Logf("was expecting //a:x and //a:z got %v instead", targets)
+		t.Fail()
+	}
+}
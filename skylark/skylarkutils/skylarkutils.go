@@ -27,12 +27,47 @@ func ListToGo(x *skylark.List) (interface{}, error) {
 			if vals == nil {
 				vals = []string{}
 			}
-			vals = append(vals.([]string), n)
+			strs, ok := vals.([]string)
+			if !ok {
+				return nil, errors.New("lists can't mix strings and ints")
+			}
+			vals = append(strs, n)
+		case int64:
+			if vals == nil {
+				vals = []int64{}
+			}
+			ints, ok := vals.([]int64)
+			if !ok {
+				return nil, errors.New("lists can't mix strings and ints")
+			}
+			vals = append(ints, n)
 		}
 	}
 	return vals, nil
 }
 
+// DictToGo converts a dict of strings to a map[string]string.
+func DictToGo(x *skylark.Dict) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, item := range x.Items() {
+		k, ok := skylark.AsString(item[0])
+		if !ok {
+			return nil, fmt.Errorf("dict keys have to be strings, not %s", item[0].Type())
+		}
+		v, ok := skylark.AsString(item[1])
+		if !ok {
+			return nil, fmt.Errorf("dict values have to be strings, not %s", item[1].Type())
+		}
+		m[k] = v
+	}
+	return m, nil
+}
+
+// pather is implemented by skylark values that are files.
+type pather interface {
+	Path() string
+}
+
 func ValueToGo(i interface{}) (interface{}, error) {
 	switch x := i.(type) {
 	case label.Label:
@@ -43,8 +78,12 @@ func ValueToGo(i interface{}) (interface{}, error) {
 		return bool(x), nil
 	case *skylark.List:
 		return ListToGo(x)
+	case *skylark.Dict:
+		return DictToGo(x)
 	case *file.File:
 		return x.Path(), nil
+	case pather:
+		return x.Path(), nil
 	case skylark.Int:
 		if n, ok := x.Int64(); ok {
 			return n, nil
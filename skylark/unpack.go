@@ -53,9 +53,25 @@ func unpackStruct(i interface{}, kwargs []skylark.Tuple) error {
 				}
 			}
 
+			// allow_files and allow_single_file take either a bool or
+			// the extensions of the files that are allowed.
+			if exts, ok := val.([]string); ok && field.Kind() == reflect.Bool {
+				if list := v.FieldByName("AllowdExtensionsList"); list.IsValid() {
+					list.Set(reflect.ValueOf(exts))
+					val = true
+				}
+			}
+
 		} else {
 			val = value
 		}
+		if val == nil { // empty lists don't have a type
+			field.Set(reflect.Zero(field.Type()))
+			continue
+		}
+		if !reflect.TypeOf(val).AssignableTo(field.Type()) {
+			return fmt.Errorf("%s can't be a %s", name, value.Type())
+		}
 		field.Set(reflect.ValueOf(val))
 	}
 
@@ -0,0 +1,138 @@
+package skylark
+
+import (
+	"fmt"
+	"strings"
+
+	"bldy.build/build/label"
+	"bldy.build/build/racy"
+	"github.com/google/skylark"
+)
+
+// pkgDefaults are the attributes the rules in a package get if they don't
+// set them.
+type pkgDefaults struct {
+	visibility     []label.Label
+	compatibleWith []label.Label
+	restrictedTo   []label.Label
+}
+
+// packageFunc implements package(), it sets the defaults of the targets in
+// the package and has to be called before any of them are declared.
+// https://docs.bazel.build/versions/master/be/functions.html#package
+func (s *skylarkVM) packageFunc(thread *skylark.Thread, fn *skylark.Builtin, args skylark.Tuple, kwargs []skylark.Tuple) (skylark.Value, error) {
+	var visibility, compatibleWith, restrictedTo *skylark.List
+	if err := skylark.UnpackArgs(fn.Name(), args, kwargs,
+		"default_visibility?", &visibility,
+		"default_compatible_with?", &compatibleWith,
+		"default_restricted_to?", &restrictedTo,
+	); err != nil {
+		return nil, err
+	}
+	p, ok := thread.Local(threadKeyTargets).(*pkg)
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("package: can only be called in BUILD files")
+	case p.declared:
+		return nil, fmt.Errorf("package: can only be called once")
+	case len(p.rules) > 0:
+		return nil, fmt.Errorf("package: has to be called before any targets are declared")
+	}
+	p.declared = true
+	pkg := getPkg(thread)
+	var err error
+	if p.defaults.visibility, err = pkgLabels(pkg, visibility); err != nil {
+		return nil, fmt.Errorf("package: default_visibility: %v", err)
+	}
+	if p.defaults.compatibleWith, err = pkgLabels(pkg, compatibleWith); err != nil {
+		return nil, fmt.Errorf("package: default_compatible_with: %v", err)
+	}
+	if p.defaults.restrictedTo, err = pkgLabels(pkg, restrictedTo); err != nil {
+		return nil, fmt.Errorf("package: default_restricted_to: %v", err)
+	}
+	return skylark.None, nil
+}
+
+// packageDefaults returns the defaults of the package being executed on
+// thread.
+func packageDefaults(thread *skylark.Thread) pkgDefaults {
+	if p, ok := thread.Local(threadKeyTargets).(*pkg); ok {
+		return p.defaults
+	}
+	return pkgDefaults{}
+}
+
+// packageGroup is a set of packages targets can be made visible to.
+// https://docs.bazel.build/versions/master/be/functions.html#package_group
+type packageGroup struct {
+	platformTarget
+	packages []label.Pattern
+	includes []label.Label
+}
+
+func (s *skylarkVM) packageGroup(thread *skylark.Thread, fn *skylark.Builtin, args skylark.Tuple, kwargs []skylark.Tuple) (skylark.Value, error) {
+	var name string
+	var packages, includes *skylark.List
+	if err := skylark.UnpackArgs(fn.Name(), args, kwargs, "name", &name, "packages?", &packages, "includes?", &includes); err != nil {
+		return nil, err
+	}
+	pkg := getPkg(thread)
+	g := &packageGroup{platformTarget: platformTarget{name: name, ws: s.ws}}
+	if packages != nil {
+		specs, err := stringList(packages)
+		if err != nil {
+			return nil, fmt.Errorf("package_group: packages: %v", err)
+		}
+		for i := 0; i < specs.Len(); i++ {
+			p, err := packageSpec(string(specs.Index(i).(skylark.String)))
+			if err != nil {
+				return nil, fmt.Errorf("package_group: packages: %v", err)
+			}
+			g.packages = append(g.packages, p)
+		}
+	}
+	var err error
+	if g.includes, err = pkgLabels(pkg, includes); err != nil {
+		return nil, fmt.Errorf("package_group: includes: %v", err)
+	}
+	return skylark.None, s.declare(thread, label.New(pkg, name), g)
+}
+
+// packageSpec parses the packages of a package group, they are //<package>
+// or //<package>/... and negated if they start with a -.
+func packageSpec(spec string) (label.Pattern, error) {
+	if !strings.HasPrefix(strings.TrimPrefix(spec, "-"), "//") || strings.Contains(spec, ":") {
+		return label.Pattern{}, fmt.Errorf("%q isn't a package, packages look like //<package> or //<package>/...", spec)
+	}
+	return label.ParsePattern(spec+":all", "")
+}
+
+// Contains reports whether pkg is matched by any of the packages of the group
+// and none of the negated ones, regardless of their order.
+func (g *packageGroup) Contains(pkg string) bool {
+	contains := false
+	for _, p := range g.packages {
+		if !p.Matches(label.New(pkg, "all")) {
+			continue
+		}
+		if p.Negative {
+			return false
+		}
+		contains = true
+	}
+	return contains
+}
+
+func (g *packageGroup) Includes() []label.Label { return g.includes }
+
+func (g *packageGroup) Hash() []byte {
+	h := racy.New()
+	h.HashNamed("package_group", g.name)
+	for _, p := range g.packages {
+		h.HashNamed("package", p.String())
+	}
+	for _, lbl := range g.includes {
+		h.HashNamed("include", lbl.String())
+	}
+	return h.Sum(nil)
+}
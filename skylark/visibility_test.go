@@ -0,0 +1,123 @@
+package skylark
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"bldy.build/build"
+)
+
+func TestVisibility(t *testing.T) {
+	tests := []struct {
+		name  string
+		build string
+		want  string
+		err   string
+	}{
+		{"private", `noop(name = "x")`, "[//visibility:private]", ""},
+		{"explicit", `noop(name = "x", visibility = [":__pkg__", "//a:__subpackages__"])`, "[//.:__pkg__ //a:__subpackages__]", ""},
+		{"default", `package(default_visibility = ["//visibility:public"])
+noop(name = "x")`, "[//visibility:public]", ""},
+		{"overridden", `package(default_visibility = ["//visibility:public"])
+noop(name = "x", visibility = ["//visibility:private"])`, "[//visibility:private]", ""},
+		{"late", `noop(name = "y")
+package(default_visibility = ["//visibility:public"])`, "", "package: has to be called before any targets are declared"},
+		{"twice", `package()
+package()`, "", "package: can only be called once"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vm := testWorkspace(t, map[string]string{
+				"defs.sky": "def _impl(ctx):\n    pass\n\nnoop = rule(attrs = {}, implementation = _impl)\n",
+				"BUILD":    `load("defs.sky", "noop")` + "\n" + test.build + "\n",
+			})
+			defer os.RemoveAll(vm.ws.AbsPath())
+			r, err := vm.GetTarget("//.:x")
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Logf("was expecting %q got %v instead", test.err, err)
+					t.Fail()
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(r.(build.Visible).Visibility()); got != test.want {
+				t.Logf("was expecting %s got %s instead", test.want, got)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestPackageGroup(t *testing.T) {
+	vm := testWorkspace(t, map[string]string{
+		"BUILD": `package_group(
+    name = "bad",
+    packages = ["//app:x"],
+)
+`,
+	})
+	defer os.RemoveAll(vm.ws.AbsPath())
+	if _, err := vm.GetTarget("//.:bad"); err == nil || !strings.Contains(err.Error(), `"//app:x" isn't a package`) {
+		t.Fatalf("was expecting package_group to only take packages got %v instead", err)
+	}
+
+	vm = testWorkspace(t, map[string]string{
+		"BUILD": `package_group(
+    name = "friends",
+    packages = ["//app", "//lib/...", "-//lib/internal/..."],
+    includes = [":others"],
+)
+`,
+	})
+	defer os.RemoveAll(vm.ws.AbsPath())
+	r, err := vm.GetTarget("//.:friends")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := r.(build.PackageGroup)
+	for pkg, want := range map[string]bool{
+		"app":          true,
+		"app/sub":      false,
+		"lib":          true,
+		"lib/a/b":      true,
+		"lib/internal": false,
+		"other":        false,
+	} {
+		if got := g.Contains(pkg); got != want {
+			t.Logf("was expecting %s to be in the group to be %v", pkg, want)
+			t.Fail()
+		}
+	}
+	if includes := g.Includes(); len(includes) != 1 || includes[0] != "//.:others" {
+		t.Logf("was expecting the group to include //.:others got %v instead", includes)
+		t.Fail()
+	}
+
+	vm = testWorkspace(t, map[string]string{
+		"BUILD": `package_group(
+    name = "negated",
+    packages = ["-//foo/bar", "//foo/..."],
+)
+`,
+	})
+	defer os.RemoveAll(vm.ws.AbsPath())
+	if r, err = vm.GetTarget("//.:negated"); err != nil {
+		t.Fatal(err)
+	}
+	g = r.(build.PackageGroup)
+	for pkg, want := range map[string]bool{
+		"foo":     true,
+		"foo/bar": false,
+		"foo/baz": true,
+	} {
+		if got := g.Contains(pkg); got != want {
+			t.Logf("was expecting %s to be in the group to be %v", pkg, want)
+			t.Fail()
+		}
+	}
+}
@@ -1,9 +1,14 @@
 """CC Binary is an example for compiling a c binary
 """
 
+load("cc_library.bzl", "CcInfo")
+
 def _impl(ctx):
+    # libraries come before the libraries they depend on when linking
+    libs = depset(transitive = [d[CcInfo].libs for d in ctx.attrs.deps], order = "topological")
+
     # The list of arguments we pass to the script.
-    args = [f.path for f in ctx.files.srcs] + ["-o"] + [ctx.outputs.binary.path] + ["-l{}".format(d.name[3:]) for d in ctx.attrs.deps]
+    args = [f.path for f in ctx.files.srcs] + ["-o"] + [ctx.outputs.binary.path] + ["-l{}".format(lib[3:]) for lib in libs.to_list()]
     print(args)
     ctx.actions.run(
         arguments = args,
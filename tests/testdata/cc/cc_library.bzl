@@ -1,15 +1,35 @@
 """CC library is an example for compiling a c library
 """
 
+CcInfo = provider(fields = ["libs"])
+
 def _impl(ctx):
-    # The list of arguments we pass to the script.
-    args = ["-c"] + [f.path for f in ctx.files.srcs] + ["-o"] + [ctx.outputs.library.path]
-    print(args)
+    # every source is compiled on its own so they can be compiled
+    # concurrently, and cached one by one.
+    objs = []
+    for src in ctx.files.srcs:
+        obj = ctx.actions.declare_file("obj/{}/{}.o".format(ctx.attrs.name, src.name))
+        ctx.actions.run(
+            inputs = [src],
+            outputs = [obj],
+            arguments = ["-c", src.path, "-o", obj.path],
+            progress_message = "Compiling %s" % src.name,
+            executable = "/usr/bin/clang",
+        )
+        objs.append(obj)
     ctx.actions.run(
-        arguments = args,
-        progress_message = "Running: %s" % args,
-        executable = "/usr/bin/clang",
+        inputs = objs,
+        outputs = [ctx.outputs.library],
+        arguments = ["rcs", ctx.outputs.library.path] + [o.path for o in objs],
+        progress_message = "Archiving %s" % ctx.outputs.library.path,
+        executable = "/usr/bin/ar",
+    )
+    libs = depset(
+        [ctx.attrs.name],
+        transitive = [d[CcInfo].libs for d in ctx.attrs.deps],
+        order = "topological",
     )
+    return [CcInfo(libs = libs)]
 
 cc_library = rule(
     attrs = {
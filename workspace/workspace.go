@@ -5,6 +5,7 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 
 	"bldy.build/build/label"
@@ -81,3 +82,40 @@ func FindWorkspace(a string, stat Stat) (string, error) {
 	}
 	return "", fmt.Errorf("workspace: new: %s is not a workspace", a)
 }
+
+// Packages returns the names of pkg and every package beneath it, in
+// lexical order. The package at the root of the workspace is called ".".
+//
+// Hidden directories and directories that are workspaces of their own are
+// not descended in to.
+func Packages(ws Workspace, pkg string) ([]string, error) {
+	root := ws.AbsPath()
+	var pkgs []string
+	err := filepath.Walk(filepath.Join(root, pkg), func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if p != root && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if _, err := os.Lstat(filepath.Join(p, "WORKSPACE")); err == nil && p != root {
+			return filepath.SkipDir
+		}
+		if _, err := os.Lstat(filepath.Join(p, BUILDFILE)); err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		pkgs = append(pkgs, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "workspace: packages")
+	}
+	return pkgs, nil
+}